@@ -15,11 +15,40 @@ import (
 	"github.com/ryutah/osin-datastore/v1"
 )
 
+// newServer creates storage and server bound to App Engine context of r.
+// aedatastore builds keys with the context which the client is created from,
+// and App Engine context is available only in requests on first generation runtime,
+// so they are created for each request. The caller must close the storage.
+func newServer(cfg *osin.ServerConfig, r *http.Request) (*osin.Server, *datastore.Storage, error) {
+	storage, err := datastore.NewStorageForGAE(appengine.NewContext(r))
+	if err != nil {
+		return nil, nil, err
+	}
+	return osin.NewServer(cfg, storage), storage, nil
+}
+
 func init() {
 	cfg := osin.NewServerConfig()
 	cfg.AllowGetAccessRequest = true
 	cfg.AllowClientSecretInParams = true
 
+	// handle runs f with server and response for r, which share config between requests.
+	handle := func(f func(w http.ResponseWriter, r *http.Request, server *osin.Server, resp *osin.Response)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			server, storage, err := newServer(cfg, r)
+			if err != nil {
+				log.Errorf(appengine.NewContext(r), "failed to create storage: %v", err)
+				http.Error(w, "failed to create storage", http.StatusInternalServerError)
+				return
+			}
+			defer storage.Close()
+
+			resp := server.NewResponse()
+			defer resp.Close()
+			f(w, r, server, resp)
+		}
+	}
+
 	http.HandleFunc("/initialize", func(w http.ResponseWriter, r *http.Request) {
 		ctx := appengine.NewContext(r)
 		cstorage, err := datastore.NewClientStorageForGAE(ctx)
@@ -41,22 +70,8 @@ func init() {
 		http.Redirect(w, r, "/app", http.StatusFound)
 	})
 
-	http.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
-		ctx := appengine.NewContext(r)
-		storage, err := datastore.NewStorageForGAE(ctx)
-		if err != nil {
-			log.Errorf(ctx, "failed to put initialize storage client: %v", err)
-			http.Error(w, "failed to initialize storage client", http.StatusInternalServerError)
-			return
-		}
-		defer storage.Close()
-
-		server := osin.NewServer(cfg, storage)
-
-		resp := server.NewResponse()
-		defer resp.Close()
-
-		if ar := server.HandleAuthorizeRequest(resp, r); ar != nil {
+	http.HandleFunc("/authorize", handle(func(w http.ResponseWriter, r *http.Request, server *osin.Server, resp *osin.Response) {
+		if ar := server.HandleAuthorizeRequest(resp, r); ar != nil && datastore.CheckAuthorizeRequest(resp, ar) {
 			if !example.HandleLoginPage(ar, w, r) {
 				return
 			}
@@ -64,24 +79,10 @@ func init() {
 			server.FinishAuthorizeRequest(resp, r, ar)
 		}
 		osin.OutputJSON(resp, w, r)
-	})
+	}))
 
-	http.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
-		ctx := appengine.NewContext(r)
-		storage, err := datastore.NewStorageForGAE(ctx)
-		if err != nil {
-			log.Errorf(ctx, "failed to put initialize storage client: %v", err)
-			http.Error(w, "failed to initialize storage client", http.StatusInternalServerError)
-			return
-		}
-		defer storage.Close()
-
-		server := osin.NewServer(cfg, storage)
-
-		resp := server.NewResponse()
-		defer resp.Close()
-
-		if ar := server.HandleAccessRequest(resp, r); ar != nil {
+	http.HandleFunc("/token", handle(func(w http.ResponseWriter, r *http.Request, server *osin.Server, resp *osin.Response) {
+		if ar := server.HandleAccessRequest(resp, r); ar != nil && datastore.CheckAccessRequest(resp, ar) {
 			ar.Authorized = true
 			server.FinishAccessRequest(resp, r, ar)
 		}
@@ -89,28 +90,14 @@ func init() {
 			fmt.Printf("ERROR: %s\n", resp.InternalError)
 		}
 		osin.OutputJSON(resp, w, r)
-	})
-
-	http.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
-		ctx := appengine.NewContext(r)
-		storage, err := datastore.NewStorageForGAE(ctx)
-		if err != nil {
-			log.Errorf(ctx, "failed to put initialize storage client: %v", err)
-			http.Error(w, "failed to initialize storage client", http.StatusInternalServerError)
-			return
-		}
-		defer storage.Close()
-
-		server := osin.NewServer(cfg, storage)
-
-		resp := server.NewResponse()
-		defer resp.Close()
+	}))
 
+	http.HandleFunc("/info", handle(func(w http.ResponseWriter, r *http.Request, server *osin.Server, resp *osin.Response) {
 		if ir := server.HandleInfoRequest(resp, r); ir != nil {
 			server.FinishInfoRequest(resp, r, ir)
 		}
 		osin.OutputJSON(resp, w, r)
-	})
+	}))
 
 	http.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("<html><body>"))
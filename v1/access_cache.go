@@ -0,0 +1,67 @@
+package datastore
+
+import (
+	"context"
+	"time"
+)
+
+// AccessCache caches access data entities resolved by Storage.LoadAccess.
+// Cached entities are always denormalized, so they can be encoded and stored in external cache as they are.
+// Implementations must be safe for concurrent use.
+type AccessCache interface {
+	// Get returns cached access data for token. ok is false if the token is not cached.
+	// Errors of external cache should be reported as a miss.
+	Get(ctx context.Context, token string) (ad *AccessData, ok bool)
+	// Set caches ad for token for ttl.
+	Set(ctx context.Context, token string, ad *AccessData, ttl time.Duration)
+	// Delete evicts cached access data for token.
+	// The error is returned by Storage.RemoveAccess, because the revoked token may be served until it expires otherwise.
+	Delete(ctx context.Context, token string) error
+}
+
+// LRUAccessCache is in-process AccessCache which evicts least recently used tokens.
+type LRUAccessCache struct {
+	lru *lru
+}
+
+// NewLRUAccessCache create LRUAccessCache which holds up to size tokens.
+func NewLRUAccessCache(size int) *LRUAccessCache {
+	return &LRUAccessCache{lru: newLRU(size)}
+}
+
+// Get returns copy of cached access data for token.
+func (l *LRUAccessCache) Get(_ context.Context, token string) (*AccessData, bool) {
+	v, ok := l.lru.get(token)
+	if !ok {
+		return nil, false
+	}
+	ad := *v.(*AccessData)
+	return &ad, true
+}
+
+// Set caches copy of ad for token for ttl.
+func (l *LRUAccessCache) Set(_ context.Context, token string, ad *AccessData, ttl time.Duration) {
+	copied := *ad
+	l.lru.add(token, &copied, timeNow().Add(ttl))
+}
+
+// Delete evicts cached access data for token.
+func (l *LRUAccessCache) Delete(_ context.Context, token string) error {
+	l.lru.remove(token)
+	return nil
+}
+
+// Len returns number of cached tokens including expired ones not removed yet.
+func (l *LRUAccessCache) Len() int {
+	return l.lru.len()
+}
+
+// accessCacheTTL returns how long ad can be cached, which is bounded by remaining lifetime of the token and maxTTL.
+// Zero or negative value means ad must not be cached.
+func accessCacheTTL(ad *AccessData, maxTTL time.Duration) time.Duration {
+	ttl := ad.CreatedAt.Add(time.Duration(ad.ExpiresIn) * time.Second).Sub(timeNow())
+	if maxTTL > 0 && ttl > maxTTL {
+		return maxTTL
+	}
+	return ttl
+}
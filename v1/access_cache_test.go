@@ -0,0 +1,215 @@
+package datastore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+)
+
+func TestLRUAccessCache(t *testing.T) {
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	ctx := context.Background()
+	cache := NewLRUAccessCache(1)
+	cache.Set(ctx, "token1", &AccessData{AccessToken: "token1"}, time.Minute)
+
+	got, ok := cache.Get(ctx, "token1")
+	if !ok || !reflect.DeepEqual(&AccessData{AccessToken: "token1"}, got) {
+		t.Errorf("cached token\nwant: %#v\n got: %#v, %v", &AccessData{AccessToken: "token1"}, got, ok)
+	}
+	got.RefreshToken = "modified"
+	if got, _ := cache.Get(ctx, "token1"); got.RefreshToken != "" {
+		t.Error("modifying returned access data must not change cached one")
+	}
+
+	cache.Set(ctx, "token2", &AccessData{AccessToken: "token2"}, time.Minute)
+	if _, ok := cache.Get(ctx, "token1"); ok {
+		t.Error("least recently used token must be evicted")
+	}
+
+	if err := cache.Delete(ctx, "token2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(ctx, "token2"); ok {
+		t.Error("deleted token must not be cached")
+	}
+
+	cache.Set(ctx, "token3", &AccessData{AccessToken: "token3"}, time.Minute)
+	timeNow = func() time.Time { return now.Add(time.Minute) }
+	if _, ok := cache.Get(ctx, "token3"); ok {
+		t.Error("expired token must not be cached")
+	}
+}
+
+func TestAccessCacheTTL(t *testing.T) {
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	tests := []struct {
+		testName string
+		ad       *AccessData
+		maxTTL   time.Duration
+		want     time.Duration
+	}{
+		{
+			testName: "remaining lifetime",
+			ad:       &AccessData{CreatedAt: now.Add(-time.Minute), ExpiresIn: 3600},
+			want:     59 * time.Minute,
+		},
+		{
+			testName: "bounded by max ttl",
+			ad:       &AccessData{CreatedAt: now.Add(-time.Minute), ExpiresIn: 3600},
+			maxTTL:   time.Minute,
+			want:     time.Minute,
+		},
+		{
+			testName: "expired",
+			ad:       &AccessData{CreatedAt: now.Add(-time.Hour), ExpiresIn: 60},
+			maxTTL:   time.Minute,
+			want:     -59 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := accessCacheTTL(tt.ad, tt.maxTTL); got != tt.want {
+				t.Errorf("want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStorage_LoadAccess_Cache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach = NewMockAccessDataHandler(ctrl)
+		mch  = NewMockClientGetter(ctrl)
+		mauh = NewMockAuthorizeDataHandler(ctrl)
+	)
+	// Token and authorize data are loaded only on the first call, and client is loaded on every call.
+	mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{
+		AccessToken:   "token",
+		ClientKey:     "client",
+		AuthorizeCode: "auth",
+		ExpiresIn:     3600,
+		CreatedAt:     time.Now(),
+	}, nil)
+	mauh.EXPECT().Get(gomock.Any(), "auth").Return(&AuthorizeData{Code: "auth", ClientKey: "client", State: "state"}, nil)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(&Client{ID: "client"}, nil).Times(2)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{
+		Client:        mch,
+		AuthorizeData: mauh,
+		AccessData:    mach,
+	}, WithAccessCache(NewLRUAccessCache(10), time.Minute))
+
+	first, err := storage.LoadAccess("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := storage.LoadAccess("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("\nwant: %#v\n got: %#v", first, second)
+	}
+}
+
+func TestStorage_LoadAccess_CacheRemovedAuthorize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach   = NewMockAccessDataHandler(ctrl)
+		mch    = NewMockClientGetter(ctrl)
+		mauh   = NewMockAuthorizeDataHandler(ctrl)
+		client = &Client{ID: "client"}
+	)
+	// Legacy token whose code has been exchanged, so that osin has removed its authorize data.
+	mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{
+		AccessToken:   "token",
+		ClientKey:     "client",
+		AuthorizeCode: "code",
+		ExpiresIn:     3600,
+		CreatedAt:     time.Now(),
+	}, nil)
+	mauh.EXPECT().Get(gomock.Any(), "code").Return(nil, datastore.ErrNoSuchEntity)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(client, nil).Times(2)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{Client: mch, AuthorizeData: mauh, AccessData: mach},
+		WithAccessCache(NewLRUAccessCache(10), time.Minute))
+
+	miss, err := storage.LoadAccess("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hit, err := storage.LoadAccess("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if miss.AuthorizeData != nil || !reflect.DeepEqual(miss, hit) {
+		t.Errorf("cache hit must return the same as cache miss without authorize data\nmiss: %#v\n hit: %#v", miss, hit)
+	}
+}
+
+func TestStorage_LoadAccess_CacheExpiredToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach  = NewMockAccessDataHandler(ctrl)
+		mch   = NewMockClientGetter(ctrl)
+		cache = NewLRUAccessCache(10)
+	)
+	mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{
+		AccessToken: "token",
+		ClientKey:   "client",
+		ExpiresIn:   60,
+		CreatedAt:   time.Now().Add(-time.Hour),
+	}, nil)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(&Client{ID: "client"}, nil)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{
+		Client:     mch,
+		AccessData: mach,
+	}, WithAccessCache(cache, time.Minute))
+
+	if _, err := storage.LoadAccess("token"); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 0 {
+		t.Error("expired token must not be cached")
+	}
+}
+
+func TestStorage_RemoveAccess_EvictsCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		ctx   = context.Background()
+		mach  = NewMockAccessDataHandler(ctrl)
+		cache = NewLRUAccessCache(10)
+	)
+	cache.Set(ctx, "token", &AccessData{AccessToken: "token"}, time.Minute)
+	mach.EXPECT().Delete(gomock.Any(), "token").Return(nil)
+
+	storage := NewStorageWithHandlers(ctx, Handlers{AccessData: mach}, WithAccessCache(cache, time.Minute))
+	if err := storage.RemoveAccess("token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get(ctx, "token"); ok {
+		t.Error("removed token must be evicted")
+	}
+}
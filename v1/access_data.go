@@ -12,7 +12,9 @@ import (
 // KindAccessData is datastore kind name of OAuth2 access token
 const KindAccessData = "access_data"
 
-type accessData struct {
+// AccessData is datastore entity of OAuth2 access token.
+// AccessToken is used as Datastore's key.
+type AccessData struct {
 	AccessToken       string `datastore:"-"`
 	ParentAccessToken string
 	ClientKey         string
@@ -23,12 +25,20 @@ type accessData struct {
 	RedirectURI       string    `datastore:",noindex"`
 	CreatedAt         time.Time `datastore:",noindex"`
 	UserData          string    `datastore:",noindex"`
+
+	// Denormalized reports whether Authorize holds copy of authorize data.
+	// It is false for entities stored with legacy schema.
+	Denormalized bool `datastore:",noindex"`
+	// Authorize is copy of authorize data which the token is issued with. Code of it is not stored.
+	// It is not indexed, since tokens are never queried by it.
+	Authorize AuthorizeData `datastore:",noindex"`
 }
 
-func newAccessDataFrom(a *osin.AccessData) (*accessData, error) {
+func newAccessDataFrom(a *osin.AccessData) (*AccessData, error) {
 	var (
 		userData          string
 		parentAccessToken string
+		authorizeCode     string
 	)
 	if a.UserData != nil {
 		ud, ok := a.UserData.(string)
@@ -40,12 +50,16 @@ func newAccessDataFrom(a *osin.AccessData) (*accessData, error) {
 	if a.AccessData != nil {
 		parentAccessToken = a.AccessData.AccessToken
 	}
+	// AuthorizeData is nil for grants other than authorization_code (e.g. client_credentials).
+	if a.AuthorizeData != nil {
+		authorizeCode = a.AuthorizeData.Code
+	}
 
-	return &accessData{
+	return &AccessData{
 		AccessToken:       a.AccessToken,
 		ParentAccessToken: parentAccessToken,
 		ClientKey:         a.Client.GetId(),
-		AuthorizeCode:     a.AuthorizeData.Code,
+		AuthorizeCode:     authorizeCode,
 		RefreshToken:      a.RefreshToken,
 		ExpiresIn:         int64(a.ExpiresIn),
 		Scope:             strings.Split(a.Scope, " "),
@@ -55,23 +69,69 @@ func newAccessDataFrom(a *osin.AccessData) (*accessData, error) {
 	}, nil
 }
 
-type accessDataStorage struct {
+// denormalize copies authorize data of a into the entity.
+// If a has no authorize data, AuthorizeCode is cleared, so that the entity is loaded without authorize data
+// as well as a of legacy token whose authorize data has been removed by osin.
+func (ad *AccessData) denormalize(a *osin.AccessData) error {
+	if a.AuthorizeData == nil {
+		ad.setAuthorize(nil)
+		return nil
+	}
+	auth, err := newAuthorizeDataFrom(a.AuthorizeData)
+	if err != nil {
+		return err
+	}
+	ad.setAuthorize(auth)
+	return nil
+}
+
+// setAuthorize stores copy of auth in the entity with denormalized schema.
+// auth is nil if the token has no authorize data or it has been removed, in which case AuthorizeCode is cleared.
+func (ad *AccessData) setAuthorize(auth *AuthorizeData) {
+	ad.Denormalized = true
+	if auth == nil {
+		ad.AuthorizeCode = ""
+		ad.Authorize = AuthorizeData{}
+		return
+	}
+	ad.Authorize = *auth
+}
+
+func (ad *AccessData) toOsin(client osin.Client, auth *osin.AuthorizeData) *osin.AccessData {
+	return &osin.AccessData{
+		AccessToken:   ad.AccessToken,
+		AuthorizeData: auth,
+		Client:        client,
+		RefreshToken:  ad.RefreshToken,
+		ExpiresIn:     int32(ad.ExpiresIn),
+		Scope:         strings.Join(ad.Scope, " "),
+		RedirectUri:   ad.RedirectURI,
+		CreatedAt:     ad.CreatedAt,
+		UserData:      ad.UserData,
+	}
+}
+
+// AccessDataStorage is datastore handler for access data.
+type AccessDataStorage struct {
 	client datastore.Client
 }
 
-func newAccessDataStorage(client datastore.Client) *accessDataStorage {
-	return &accessDataStorage{client: client}
+// NewAccessDataStorage create AccessDataStorage object which uses given datastore client.
+func NewAccessDataStorage(client datastore.Client) *AccessDataStorage {
+	return &AccessDataStorage{client: client}
 }
 
-func (a *accessDataStorage) put(ctx context.Context, ac *accessData) error {
+// Put create or update access data entity.
+func (a *AccessDataStorage) Put(ctx context.Context, ac *AccessData) error {
 	key := a.client.NameKey(KindAccessData, ac.AccessToken, nil)
 	_, err := a.client.Put(ctx, key, ac)
 	return err
 }
 
-func (a *accessDataStorage) get(ctx context.Context, token string) (*accessData, error) {
+// Get search access data for given token.
+func (a *AccessDataStorage) Get(ctx context.Context, token string) (*AccessData, error) {
 	key := a.client.NameKey(KindAccessData, token, nil)
-	access := new(accessData)
+	access := new(AccessData)
 	if err := a.client.Get(ctx, key, access); err != nil {
 		return nil, err
 	}
@@ -79,7 +139,8 @@ func (a *accessDataStorage) get(ctx context.Context, token string) (*accessData,
 	return access, nil
 }
 
-func (a *accessDataStorage) delete(ctx context.Context, token string) error {
+// Delete removes access data entity for token from Datastore.
+func (a *AccessDataStorage) Delete(ctx context.Context, token string) error {
 	key := a.client.NameKey(KindAccessData, token, nil)
 	return a.client.Delete(ctx, key)
 }
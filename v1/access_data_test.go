@@ -15,7 +15,7 @@ func TestAccessDataStorage_Put(t *testing.T) {
 	createAt := time.Now()
 	type (
 		in struct {
-			accessData *accessData
+			accessData *AccessData
 		}
 
 		returns struct {
@@ -30,7 +30,7 @@ func TestAccessDataStorage_Put(t *testing.T) {
 		{
 			testName: "test1",
 			in: in{
-				&accessData{
+				&AccessData{
 					AccessToken:       "token",
 					ParentAccessToken: "parentToken",
 					ClientKey:         "client",
@@ -58,8 +58,8 @@ func TestAccessDataStorage_Put(t *testing.T) {
 			mockDSClient.EXPECT().NameKey(KindAccessData, tt.in.accessData.AccessToken, gomock.Nil()).Return(tt.returns.key)
 			mockDSClient.EXPECT().Put(gomock.Any(), tt.returns.key, tt.in.accessData).Return(tt.returns.key, nil)
 
-			storage := &accessDataStorage{client: mockDSClient}
-			err := storage.put(context.Background(), tt.in.accessData)
+			storage := &AccessDataStorage{client: mockDSClient}
+			err := storage.Put(context.Background(), tt.in.accessData)
 			if err != nil {
 				t.Error(err)
 			}
@@ -74,12 +74,12 @@ func TestAccessDataStorage_Get(t *testing.T) {
 		}
 
 		out struct {
-			accessData *accessData
+			accessData *AccessData
 		}
 
 		returns struct {
 			key        datastore.Key
-			accessData *accessData
+			accessData *AccessData
 		}
 	)
 
@@ -96,7 +96,7 @@ func TestAccessDataStorage_Get(t *testing.T) {
 				token: "token",
 			},
 			out: out{
-				accessData: &accessData{
+				accessData: &AccessData{
 					AccessToken:       "token",
 					ParentAccessToken: "parentToken",
 					ClientKey:         "client",
@@ -111,7 +111,7 @@ func TestAccessDataStorage_Get(t *testing.T) {
 			},
 			returns: returns{
 				key: &mockKey{kind: "kind", name: "token"},
-				accessData: &accessData{
+				accessData: &AccessData{
 					ParentAccessToken: "parentToken",
 					ClientKey:         "client",
 					AuthorizeCode:     "authCode",
@@ -140,8 +140,8 @@ func TestAccessDataStorage_Get(t *testing.T) {
 				return nil
 			})
 
-			storage := &accessDataStorage{client: mockDatastoreClient}
-			got, err := storage.get(context.Background(), tt.in.token)
+			storage := &AccessDataStorage{client: mockDatastoreClient}
+			got, err := storage.Get(context.Background(), tt.in.token)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -188,8 +188,8 @@ func TestAccessDataStorage_Get_Error(t *testing.T) {
 			mockDatastoreClient.EXPECT().NameKey(gomock.Any(), gomock.Any(), gomock.Any()).Return(new(mockKey))
 			mockDatastoreClient.EXPECT().Get(gomock.Any(), gomock.Any(), gomock.Any()).Return(datastore.ErrNoSuchEntity)
 
-			storage := &accessDataStorage{client: mockDatastoreClient}
-			_, err := storage.get(context.Background(), tt.in.token)
+			storage := &AccessDataStorage{client: mockDatastoreClient}
+			_, err := storage.Get(context.Background(), tt.in.token)
 			if err != tt.out.err {
 				t.Errorf("\nwant %#v\n got %#v", tt.out.err, err)
 			}
@@ -233,8 +233,8 @@ func TestAccessDataStorage_Delete(t *testing.T) {
 			mockDatastoreClient.EXPECT().NameKey(KindAccessData, tt.in.token, gomock.Nil()).Return(tt.returns.key)
 			mockDatastoreClient.EXPECT().Delete(gomock.Any(), tt.returns.key).Return(nil)
 
-			storage := &accessDataStorage{client: mockDatastoreClient}
-			if err := storage.delete(context.Background(), tt.in.token); err != nil {
+			storage := &AccessDataStorage{client: mockDatastoreClient}
+			if err := storage.Delete(context.Background(), tt.in.token); err != nil {
 				t.Error(err)
 			}
 		})
@@ -0,0 +1,210 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// KindAuditEvent is datastore kind name of audit events of grants.
+const KindAuditEvent = "audit_event"
+
+// AuditEventType is type of grant lifecycle event.
+type AuditEventType string
+
+// Types of audit events.
+const (
+	// AuditCodeIssued is recorded by SaveAuthorize.
+	AuditCodeIssued AuditEventType = "code_issued"
+	// AuditCodeExchanged is recorded by SaveAccess of the token issued for authorization code.
+	AuditCodeExchanged AuditEventType = "code_exchanged"
+	// AuditTokenIssued is recorded by SaveAccess of the token issued by grants other than refresh token.
+	AuditTokenIssued AuditEventType = "token_issued"
+	// AuditTokenRefreshed is recorded by SaveAccess of the token issued for refresh token.
+	AuditTokenRefreshed AuditEventType = "token_refreshed"
+	// AuditTokenRevoked is recorded by RemoveAccess of existing token.
+	AuditTokenRevoked AuditEventType = "token_revoked"
+	// AuditTokenRotated is recorded instead of AuditTokenRevoked by RemoveAccess of the token replaced by refresh,
+	// which osin calls on the view which has saved the refreshed token.
+	AuditTokenRotated AuditEventType = "token_rotated"
+)
+
+// AuditEvent is datastore entity of grant lifecycle event.
+// Datastore allocates ID of the entity, which is used as Datastore's key.
+// Token is recorded only as its fingerprint, see TokenFingerprint.
+type AuditEvent struct {
+	ID               int64          `json:"id,omitempty" datastore:"-"`
+	Type             AuditEventType `json:"type,omitempty"`
+	ClientID         string         `json:"client_id,omitempty"`
+	Subject          string         `json:"subject,omitempty"`
+	Scope            []string       `json:"scope,omitempty" datastore:",noindex"`
+	TokenFingerprint string         `json:"token_fingerprint,omitempty"`
+	CreatedAt        time.Time      `json:"created_at,omitempty"`
+}
+
+// NewAuditEvent creates AuditEvent of typ for token, which is created now.
+// Subject is user data of the grant, which is how osin identifies the resource owner.
+func NewAuditEvent(typ AuditEventType, clientID, subject string, scope []string, token string) *AuditEvent {
+	return &AuditEvent{
+		Type:             typ,
+		ClientID:         clientID,
+		Subject:          subject,
+		Scope:            scope,
+		TokenFingerprint: TokenFingerprint(token),
+		CreatedAt:        timeNow(),
+	}
+}
+
+// AuditQuery is condition of audit events to query.
+// Zero fields are not used as condition. Events are ordered by CreatedAt, so querying by ClientID or Subject needs composite index
+// with CreatedAt in descending order, with or without time range. index.yaml of the package defines them.
+type AuditQuery struct {
+	ClientID string
+	Subject  string
+	// Since and Until are range of CreatedAt, where Since is inclusive and Until is exclusive.
+	Since time.Time
+	Until time.Time
+	// Limit is max number of events to return. Zero means no limit.
+	Limit int
+}
+
+// AuditStorage is datastore handler for audit events.
+type AuditStorage struct {
+	client datastore.Client
+}
+
+// NewAuditStorage create AuditStorage object which uses given datastore client.
+func NewAuditStorage(client datastore.Client) *AuditStorage {
+	return &AuditStorage{client: client}
+}
+
+// Record stores events, and sets ID allocated by Datastore to each of them.
+func (a *AuditStorage) Record(ctx context.Context, events ...*AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	keys := make([]datastore.Key, len(events))
+	for i := range events {
+		keys[i] = a.client.IncompleteKey(KindAuditEvent, nil)
+	}
+	stored, err := a.client.PutMulti(ctx, keys, events)
+	if err != nil {
+		return err
+	}
+	for i, key := range stored {
+		events[i].ID = key.ID()
+	}
+	return nil
+}
+
+// Query returns events which match q, newest first.
+func (a *AuditStorage) Query(ctx context.Context, q AuditQuery) ([]*AuditEvent, error) {
+	dq := a.client.NewQuery(KindAuditEvent)
+	if q.ClientID != "" {
+		dq = dq.Filter("ClientID =", q.ClientID)
+	}
+	if q.Subject != "" {
+		dq = dq.Filter("Subject =", q.Subject)
+	}
+	if !q.Since.IsZero() {
+		dq = dq.Filter("CreatedAt >=", q.Since)
+	}
+	if !q.Until.IsZero() {
+		dq = dq.Filter("CreatedAt <", q.Until)
+	}
+	dq = dq.Order("-CreatedAt")
+	if q.Limit > 0 {
+		dq = dq.Limit(q.Limit)
+	}
+
+	var events []*AuditEvent
+	keys, err := a.client.GetAll(ctx, dq, &events)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		events[i].ID = key.ID()
+	}
+	return events, nil
+}
+
+// purgePageSize is max number of events Purge queries at once.
+const purgePageSize = 500
+
+// Purge removes events older than retention, and returns number of removed events.
+// Events are queried in pages of purgePageSize, and deleted in chunks concurrently as DeleteMulti of ClientStorage.
+// On error, Purge returns number of events removed by the preceding pages, and some events of the failed page may have been removed.
+func (a *AuditStorage) Purge(ctx context.Context, retention time.Duration) (int, error) {
+	dq := a.client.NewQuery(KindAuditEvent).Filter("CreatedAt <", timeNow().Add(-retention)).KeysOnly().Limit(purgePageSize)
+	purged := 0
+	for {
+		it := a.client.Run(ctx, dq)
+		var keys []datastore.Key
+		for {
+			key, err := it.Next(nil)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return purged, err
+			}
+			keys = append(keys, key)
+		}
+
+		err := runChunks(len(keys), maxDeleteMultiSize, func(start, end int) error {
+			return a.client.DeleteMulti(ctx, keys[start:end])
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += len(keys)
+		if len(keys) < purgePageSize {
+			return purged, nil
+		}
+
+		cursor, err := it.Cursor()
+		if err != nil {
+			return purged, err
+		}
+		dq = dq.Start(cursor)
+	}
+}
+
+// audit records events by audit handler of d, if it is set.
+func (d *Storage) audit(ctx context.Context, op Operation, events ...*AuditEvent) error {
+	if d.auditHandler == nil {
+		return nil
+	}
+	if err := d.auditHandler.Record(ctx, events...); err != nil {
+		return newError(op, KindAuditEvent, "", err)
+	}
+	return nil
+}
+
+// auditInTransaction reports whether audit events of tokens are written in the transaction of outbox,
+// which is possible only for AuditStorage set by WithAudit, since it shares the datastore client.
+func (d *Storage) auditInTransaction() bool {
+	return d.outbox && d.auditWithClient
+}
+
+// putAuditEvents writes events in tx, used to record them in the transaction of outbox.
+func (d *Storage) putAuditEvents(tx datastore.Transaction, events []*AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	keys := make([]datastore.Key, len(events))
+	for i := range events {
+		keys[i] = d.client.IncompleteKey(KindAuditEvent, nil)
+	}
+	_, err := tx.PutMulti(keys, events)
+	return err
+}
+
+// AuditStorage returns AuditStorage used by the storage to record audit events.
+// If audit is not enabled, or the audit handler is not AuditStorage, AuditStorage returns nil.
+func (d *Storage) AuditStorage() *AuditStorage {
+	a, _ := d.auditHandler.(*AuditStorage)
+	return a
+}
@@ -0,0 +1,353 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/api/iterator"
+)
+
+func TestAuditStorage_Record(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		incomplete = &mockKey{kind: KindAuditEvent}
+		mockDS     = NewMockClient(ctrl)
+		events     = []*AuditEvent{{Type: AuditTokenIssued}, {Type: AuditCodeExchanged}}
+	)
+	mockDS.EXPECT().IncompleteKey(KindAuditEvent, gomock.Nil()).Return(incomplete).Times(2)
+	mockDS.EXPECT().PutMulti(gomock.Any(), []datastore.Key{incomplete, incomplete}, events).Return([]datastore.Key{
+		&mockKey{kind: KindAuditEvent, id: 1},
+		&mockKey{kind: KindAuditEvent, id: 2},
+	}, nil)
+
+	if err := NewAuditStorage(mockDS).Record(context.Background(), events...); err != nil {
+		t.Fatal(err)
+	}
+	if events[0].ID != 1 || events[1].ID != 2 {
+		t.Errorf("ids\nwant: 1, 2\n got: %v, %v", events[0].ID, events[1].ID)
+	}
+}
+
+func TestAuditStorage_Query(t *testing.T) {
+	now := time.Now()
+
+	type (
+		filter struct {
+			filter string
+			value  interface{}
+		}
+
+		out struct {
+			filters []filter
+			limit   int
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       AuditQuery
+		out      out
+	}{
+		{
+			testName: "all events",
+		},
+		{
+			testName: "by client and time range",
+			in:       AuditQuery{ClientID: "client", Since: now.Add(-time.Hour), Until: now, Limit: 10},
+			out: out{
+				filters: []filter{
+					{filter: "ClientID =", value: "client"},
+					{filter: "CreatedAt >=", value: now.Add(-time.Hour)},
+					{filter: "CreatedAt <", value: now},
+				},
+				limit: 10,
+			},
+		},
+		{
+			testName: "by subject",
+			in:       AuditQuery{Subject: "user"},
+			out: out{
+				filters: []filter{{filter: "Subject =", value: "user"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				mockDS = NewMockClient(ctrl)
+				mockQ  = NewMockQuery(ctrl)
+				calls  []*gomock.Call
+			)
+			mockDS.EXPECT().NewQuery(KindAuditEvent).Return(mockQ)
+			for _, f := range tt.out.filters {
+				calls = append(calls, mockQ.EXPECT().Filter(f.filter, f.value).Return(mockQ))
+			}
+			calls = append(calls, mockQ.EXPECT().Order("-CreatedAt").Return(mockQ))
+			if tt.out.limit > 0 {
+				calls = append(calls, mockQ.EXPECT().Limit(tt.out.limit).Return(mockQ))
+			}
+			gomock.InOrder(calls...)
+			mockDS.EXPECT().GetAll(gomock.Any(), mockQ, gomock.Any()).DoAndReturn(func(_ context.Context, _ datastore.Query, dst interface{}) ([]datastore.Key, error) {
+				*dst.(*[]*AuditEvent) = []*AuditEvent{{Type: AuditTokenRevoked}}
+				return []datastore.Key{&mockKey{kind: KindAuditEvent, id: 1}}, nil
+			})
+
+			got, err := NewAuditStorage(mockDS).Query(context.Background(), tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []*AuditEvent{{ID: 1, Type: AuditTokenRevoked}}; !reflect.DeepEqual(want, got) {
+				t.Errorf("\nwant: %#v\n got: %#v", want, got)
+			}
+		})
+	}
+}
+
+func TestAuditStorage_Purge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	var (
+		mockDS     = NewMockClient(ctrl)
+		mockQ      = NewMockQuery(ctrl)
+		mockNextQ  = NewMockQuery(ctrl)
+		mockIt     = NewMockIterator(ctrl)
+		mockNextIt = NewMockIterator(ctrl)
+		mockCursor = NewMockCursor(ctrl)
+		page       = make([]datastore.Key, purgePageSize)
+		last       = []datastore.Key{&mockKey{kind: KindAuditEvent, id: purgePageSize + 1}}
+	)
+	for i := range page {
+		page[i] = &mockKey{kind: KindAuditEvent, id: int64(i + 1)}
+	}
+	expectKeys := func(it *MockIterator, keys []datastore.Key) {
+		calls := make([]*gomock.Call, 0, len(keys)+1)
+		for _, key := range keys {
+			calls = append(calls, it.EXPECT().Next(nil).Return(key, nil))
+		}
+		calls = append(calls, it.EXPECT().Next(nil).Return(nil, iterator.Done))
+		gomock.InOrder(calls...)
+	}
+
+	mockDS.EXPECT().NewQuery(KindAuditEvent).Return(mockQ)
+	mockQ.EXPECT().Filter("CreatedAt <", now.Add(-24*time.Hour)).Return(mockQ)
+	mockQ.EXPECT().KeysOnly().Return(mockQ)
+	mockQ.EXPECT().Limit(purgePageSize).Return(mockQ)
+	gomock.InOrder(
+		mockDS.EXPECT().Run(gomock.Any(), mockQ).Return(mockIt),
+		mockDS.EXPECT().DeleteMulti(gomock.Any(), page).Return(nil),
+		mockDS.EXPECT().Run(gomock.Any(), mockNextQ).Return(mockNextIt),
+		mockDS.EXPECT().DeleteMulti(gomock.Any(), last).Return(nil),
+	)
+	expectKeys(mockIt, page)
+	mockIt.EXPECT().Cursor().Return(mockCursor, nil)
+	mockQ.EXPECT().Start(mockCursor).Return(mockNextQ)
+	expectKeys(mockNextIt, last)
+
+	n, err := NewAuditStorage(mockDS).Purge(context.Background(), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := purgePageSize + 1; n != want {
+		t.Errorf("purged\nwant: %v\n got: %v", want, n)
+	}
+}
+
+func TestAuditStorage_Purge_Error(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mockDS = NewMockClient(ctrl)
+		mockQ  = NewMockQuery(ctrl)
+		mockIt = NewMockIterator(ctrl)
+		errRPC = errors.New("rpc error")
+	)
+	mockDS.EXPECT().NewQuery(KindAuditEvent).Return(mockQ)
+	mockQ.EXPECT().Filter("CreatedAt <", gomock.Any()).Return(mockQ)
+	mockQ.EXPECT().KeysOnly().Return(mockQ)
+	mockQ.EXPECT().Limit(purgePageSize).Return(mockQ)
+	mockDS.EXPECT().Run(gomock.Any(), mockQ).Return(mockIt)
+	mockIt.EXPECT().Next(nil).Return(nil, errRPC)
+
+	n, err := NewAuditStorage(mockDS).Purge(context.Background(), 24*time.Hour)
+	if err != errRPC || n != 0 {
+		t.Errorf("\nwant: 0, %#v\n got: %v, %#v", errRPC, n, err)
+	}
+}
+
+func TestStorage_Audit(t *testing.T) {
+	now := time.Now()
+	client := &Client{ID: "client"}
+
+	tests := []struct {
+		testName string
+		setup    func(mauh *MockAuthorizeDataHandler, mach *MockAccessDataHandler, mrh *MockRefreshHandler)
+		call     func(s *Storage) error
+		out      []*AuditEvent
+	}{
+		{
+			testName: "code issued",
+			setup: func(mauh *MockAuthorizeDataHandler, _ *MockAccessDataHandler, _ *MockRefreshHandler) {
+				mauh.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			call: func(s *Storage) error {
+				return s.SaveAuthorize(&osin.AuthorizeData{Client: client, Code: "code", Scope: "read", UserData: "user"})
+			},
+			out: []*AuditEvent{
+				{Type: AuditCodeIssued, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("code"), CreatedAt: now},
+			},
+		},
+		{
+			testName: "code exchanged",
+			setup: func(_ *MockAuthorizeDataHandler, mach *MockAccessDataHandler, _ *MockRefreshHandler) {
+				mach.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			call: func(s *Storage) error {
+				return s.SaveAccess(&osin.AccessData{
+					Client:        client,
+					AuthorizeData: &osin.AuthorizeData{Client: client, Code: "code"},
+					AccessToken:   "token",
+					Scope:         "read",
+					UserData:      "user",
+				})
+			},
+			out: []*AuditEvent{
+				{Type: AuditCodeExchanged, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("code"), CreatedAt: now},
+				{Type: AuditTokenIssued, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+			},
+		},
+		{
+			testName: "token refreshed",
+			setup: func(_ *MockAuthorizeDataHandler, mach *MockAccessDataHandler, mrh *MockRefreshHandler) {
+				mach.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+				mrh.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			call: func(s *Storage) error {
+				return s.SaveAccess(&osin.AccessData{
+					Client:       client,
+					AccessData:   &osin.AccessData{AccessToken: "old"},
+					AccessToken:  "token",
+					RefreshToken: "refresh",
+					Scope:        "read",
+					UserData:     "user",
+				})
+			},
+			out: []*AuditEvent{
+				{Type: AuditTokenRefreshed, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+			},
+		},
+		{
+			testName: "token revoked",
+			setup: func(_ *MockAuthorizeDataHandler, mach *MockAccessDataHandler, _ *MockRefreshHandler) {
+				mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{AccessToken: "token", ClientKey: "client", UserData: "user", Scope: []string{"read"}}, nil)
+				mach.EXPECT().Delete(gomock.Any(), "token").Return(nil)
+			},
+			call: func(s *Storage) error {
+				return s.RemoveAccess("token")
+			},
+			out: []*AuditEvent{
+				{Type: AuditTokenRevoked, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+			},
+		},
+		{
+			testName: "token rotated",
+			setup: func(_ *MockAuthorizeDataHandler, mach *MockAccessDataHandler, _ *MockRefreshHandler) {
+				mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{AccessToken: "token", ClientKey: "client", UserData: "user", Scope: []string{"read"}}, nil)
+				mach.EXPECT().Delete(gomock.Any(), "token").Return(nil)
+			},
+			call: func(s *Storage) error {
+				v := s.WithContext(context.Background())
+				v.rotated.add("token")
+				return v.RemoveAccess("token")
+			},
+			out: []*AuditEvent{
+				{Type: AuditTokenRotated, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+			},
+		},
+		{
+			testName: "missing token is not revoked",
+			setup: func(_ *MockAuthorizeDataHandler, mach *MockAccessDataHandler, _ *MockRefreshHandler) {
+				mach.EXPECT().Get(gomock.Any(), "token").Return(nil, datastore.ErrNoSuchEntity)
+				mach.EXPECT().Delete(gomock.Any(), "token").Return(nil)
+			},
+			call: func(s *Storage) error {
+				return s.RemoveAccess("token")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			defer func(f func() time.Time) { timeNow = f }(timeNow)
+			timeNow = func() time.Time { return now }
+
+			var (
+				mauh = NewMockAuthorizeDataHandler(ctrl)
+				mach = NewMockAccessDataHandler(ctrl)
+				mrh  = NewMockRefreshHandler(ctrl)
+				mah  = NewMockAuditHandler(ctrl)
+			)
+			tt.setup(mauh, mach, mrh)
+			if tt.out != nil {
+				mah.EXPECT().Record(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, events ...*AuditEvent) error {
+					if !reflect.DeepEqual(tt.out, events) {
+						t.Errorf("\nwant: %#v\n got: %#v", tt.out, events)
+					}
+					return nil
+				})
+			}
+
+			storage := NewStorageWithHandlers(context.Background(), Handlers{
+				AuthorizeData: mauh,
+				AccessData:    mach,
+				Refresh:       mrh,
+				Audit:         mah,
+			})
+			if err := tt.call(storage); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStorage_Audit_Error(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mauh   = NewMockAuthorizeDataHandler(ctrl)
+		mah    = NewMockAuditHandler(ctrl)
+		errRPC = errors.New("rpc error")
+	)
+	mauh.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+	mah.EXPECT().Record(gomock.Any(), gomock.Any()).Return(errRPC)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{AuthorizeData: mauh, Audit: mah})
+	err := storage.SaveAuthorize(&osin.AuthorizeData{Client: &Client{ID: "client"}, Code: "code"})
+
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != KindAuditEvent || !errors.Is(err, errRPC) {
+		t.Errorf("return error\nwant: error of %s caused by %#v\n got: %#v", KindAuditEvent, errRPC, err)
+	}
+}
@@ -12,7 +12,9 @@ import (
 // KindAuthorizeData is datastore kind name of OAuth2 authorize data stored
 const KindAuthorizeData = "authorize_data"
 
-type authorizeData struct {
+// AuthorizeData is datastore entity of OAuth2 authorize data.
+// Code is used as Datastore's key.
+type AuthorizeData struct {
 	Code                string `datastore:"-"`
 	ClientKey           string
 	ExpiresIn           int64     `datastore:",noindex"`
@@ -25,7 +27,7 @@ type authorizeData struct {
 	CodeChallengeMethod string    `datastore:",noindex"`
 }
 
-func newAuthorizeDataFrom(a *osin.AuthorizeData) (*authorizeData, error) {
+func newAuthorizeDataFrom(a *osin.AuthorizeData) (*AuthorizeData, error) {
 	var userData string
 	if a.UserData != nil {
 		ud, ok := a.UserData.(string)
@@ -35,7 +37,7 @@ func newAuthorizeDataFrom(a *osin.AuthorizeData) (*authorizeData, error) {
 		userData = ud
 	}
 
-	return &authorizeData{
+	return &AuthorizeData{
 		Code:                a.Code,
 		ClientKey:           a.Client.GetId(),
 		ExpiresIn:           int64(a.ExpiresIn),
@@ -49,23 +51,47 @@ func newAuthorizeDataFrom(a *osin.AuthorizeData) (*authorizeData, error) {
 	}, nil
 }
 
-type authorizeDataStorage struct {
+// expired reports whether the authorize code has expired. Code without lifetime is regarded as unexpired.
+func (a *AuthorizeData) expired() bool {
+	return a.ExpiresIn > 0 && a.CreatedAt.Add(time.Duration(a.ExpiresIn)*time.Second).Before(timeNow())
+}
+
+func (a *AuthorizeData) toOsin(client osin.Client) *osin.AuthorizeData {
+	return &osin.AuthorizeData{
+		Code:                a.Code,
+		Client:              client,
+		ExpiresIn:           int32(a.ExpiresIn),
+		Scope:               strings.Join(a.Scope, " "),
+		RedirectUri:         a.RedirectURI,
+		State:               a.State,
+		CreatedAt:           a.CreatedAt,
+		CodeChallenge:       a.CodeChallenge,
+		CodeChallengeMethod: a.CodeChallengeMethod,
+		UserData:            a.UserData,
+	}
+}
+
+// AuthorizeDataStorage is datastore handler for authorize data.
+type AuthorizeDataStorage struct {
 	client datastore.Client
 }
 
-func newAuthorizeDataStorage(client datastore.Client) *authorizeDataStorage {
-	return &authorizeDataStorage{client: client}
+// NewAuthorizeDataStorage create AuthorizeDataStorage object which uses given datastore client.
+func NewAuthorizeDataStorage(client datastore.Client) *AuthorizeDataStorage {
+	return &AuthorizeDataStorage{client: client}
 }
 
-func (a *authorizeDataStorage) put(ctx context.Context, auth *authorizeData) error {
+// Put create or update authorize data entity.
+func (a *AuthorizeDataStorage) Put(ctx context.Context, auth *AuthorizeData) error {
 	key := a.client.NameKey(KindAuthorizeData, auth.Code, nil)
 	_, err := a.client.Put(ctx, key, auth)
 	return err
 }
 
-func (a *authorizeDataStorage) get(ctx context.Context, code string) (*authorizeData, error) {
+// Get search authorize data for given code.
+func (a *AuthorizeDataStorage) Get(ctx context.Context, code string) (*AuthorizeData, error) {
 	key := a.client.NameKey(KindAuthorizeData, code, nil)
-	auth := new(authorizeData)
+	auth := new(AuthorizeData)
 	if err := a.client.Get(ctx, key, auth); err != nil {
 		return nil, err
 	}
@@ -73,7 +99,8 @@ func (a *authorizeDataStorage) get(ctx context.Context, code string) (*authorize
 	return auth, nil
 }
 
-func (a *authorizeDataStorage) delete(ctx context.Context, code string) error {
+// Delete removes authorize data entity for code from Datastore.
+func (a *AuthorizeDataStorage) Delete(ctx context.Context, code string) error {
 	key := a.client.NameKey(KindAuthorizeData, code, nil)
 	return a.client.Delete(ctx, key)
 }
@@ -14,7 +14,7 @@ import (
 func TestAuthorizeDataStorage_Put(t *testing.T) {
 	type (
 		in struct {
-			authorize *authorizeData
+			authorize *AuthorizeData
 		}
 
 		returns struct {
@@ -31,7 +31,7 @@ func TestAuthorizeDataStorage_Put(t *testing.T) {
 		{
 			testName: "test1",
 			in: in{
-				authorize: &authorizeData{
+				authorize: &AuthorizeData{
 					Code:                "code",
 					ClientKey:           "clientKey",
 					ExpiresIn:           123,
@@ -59,8 +59,8 @@ func TestAuthorizeDataStorage_Put(t *testing.T) {
 			mockDSClient.EXPECT().NameKey(KindAuthorizeData, tt.in.authorize.Code, gomock.Nil()).Return(tt.returns.key)
 			mockDSClient.EXPECT().Put(gomock.Any(), tt.returns.key, tt.in.authorize).Return(tt.returns.key, nil)
 
-			storage := &authorizeDataStorage{client: mockDSClient}
-			err := storage.put(context.Background(), tt.in.authorize)
+			storage := &AuthorizeDataStorage{client: mockDSClient}
+			err := storage.Put(context.Background(), tt.in.authorize)
 			if err != nil {
 				t.Error(err)
 			}
@@ -75,12 +75,12 @@ func TestAuthorizeDataStorage_Get(t *testing.T) {
 		}
 
 		out struct {
-			authorize *authorizeData
+			authorize *AuthorizeData
 		}
 
 		returns struct {
 			key       datastore.Key
-			authorize *authorizeData
+			authorize *AuthorizeData
 		}
 	)
 
@@ -97,7 +97,7 @@ func TestAuthorizeDataStorage_Get(t *testing.T) {
 				code: "code",
 			},
 			out: out{
-				authorize: &authorizeData{
+				authorize: &AuthorizeData{
 					Code:                "code",
 					ClientKey:           "clientKey",
 					ExpiresIn:           123,
@@ -112,7 +112,7 @@ func TestAuthorizeDataStorage_Get(t *testing.T) {
 			},
 			returns: returns{
 				key: &mockKey{kind: "kind", name: "code"},
-				authorize: &authorizeData{
+				authorize: &AuthorizeData{
 					ClientKey:           "clientKey",
 					ExpiresIn:           123,
 					Scope:               []string{"scope", "scope2"},
@@ -141,8 +141,8 @@ func TestAuthorizeDataStorage_Get(t *testing.T) {
 				return nil
 			})
 
-			storage := &authorizeDataStorage{client: mockDatastoreClient}
-			got, err := storage.get(context.Background(), tt.in.code)
+			storage := &AuthorizeDataStorage{client: mockDatastoreClient}
+			got, err := storage.Get(context.Background(), tt.in.code)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -189,8 +189,8 @@ func TestAuthorizeDataStorage_Delete(t *testing.T) {
 			mockDatastoreClient.EXPECT().NameKey(KindAuthorizeData, tt.in.code, gomock.Nil()).Return(tt.returns.key)
 			mockDatastoreClient.EXPECT().Delete(gomock.Any(), tt.returns.key).Return(nil)
 
-			storage := &authorizeDataStorage{client: mockDatastoreClient}
-			if err := storage.delete(context.Background(), tt.in.code); err != nil {
+			storage := &AuthorizeDataStorage{client: mockDatastoreClient}
+			if err := storage.Delete(context.Background(), tt.in.code); err != nil {
 				t.Error(err)
 			}
 		})
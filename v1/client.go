@@ -2,8 +2,10 @@ package datastore
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	"github.com/RangelReale/osin"
 	"go.mercari.io/datastore"
 	"go.mercari.io/datastore/aedatastore"
 	"go.mercari.io/datastore/clouddatastore"
@@ -12,12 +14,50 @@ import (
 // KindClient is datastore kind name of OAuth2 client stored
 const KindClient = "client"
 
+// ClientType is OAuth2 client type defined in RFC 6749 section 2.1.
+type ClientType string
+
+// Client types
+const (
+	// ClientTypeConfidential is client which can keep its secret. Empty ClientType is treated as confidential.
+	ClientTypeConfidential ClientType = "confidential"
+	// ClientTypePublic is client which cannot keep secret, such as SPA and mobile app.
+	ClientTypePublic ClientType = "public"
+)
+
 // Client is struct of OAuth2 client.
+// Formatting and JSON encoding of Client never output its secrets, while JSON decoding reads them.
+//
+// Storage enforces the policy of the client (scopes, grant types, response types and PKCE) when the grant is saved,
+// but it can report violations only as server_error, and it cannot tell implicit, password, client_credentials and assertion grants.
+// Clients restricting grant types or response types, and public clients, therefore do not work unless every authorize and token
+// handler calls CheckAuthorizeRequest and CheckAccessRequest with a view of Storage: such grants are rejected even if they are allowed.
 type Client struct {
 	ID          string `json:"id,omitempty" datastore:"-"`
 	Secret      string `json:"secret,omitempty" datastore:",noindex"`
 	RedirectUri string `json:"redirect_uri,omitempty" datastore:",noindex"`
 	UserData    string `json:"user_data,omitempty" datastore:",noindex"`
+
+	// Type is client type. Empty means confidential client.
+	Type ClientType `json:"type,omitempty" datastore:",noindex"`
+	// AllowedScopes is list of scopes the client can request. Empty means any scope is allowed.
+	AllowedScopes []string `json:"allowed_scopes,omitempty" datastore:",noindex"`
+	// AllowedGrantTypes is list of grant types (e.g. "authorization_code", "refresh_token") the client can use at token endpoint.
+	// Empty means any grant type is allowed.
+	AllowedGrantTypes []string `json:"allowed_grant_types,omitempty" datastore:",noindex"`
+	// AllowedResponseTypes is list of response types ("code", "token") the client can use at authorize endpoint.
+	// Empty means any response type is allowed.
+	AllowedResponseTypes []string `json:"allowed_response_types,omitempty" datastore:",noindex"`
+	// RequirePKCE makes authorization code requests of the client carry S256 code challenge.
+	RequirePKCE bool `json:"require_pkce,omitempty" datastore:",noindex"`
+	// Secrets are additional secrets of the client used to rotate secret without downtime.
+	// Any unexpired secret of Secrets authenticates the client as well as Secret.
+	Secrets []ClientSecret `json:"secrets,omitempty" datastore:",noindex"`
+	// Suspended disables the client without deleting it.
+	// Storage treats suspended client as not found, so any tokens of the client are rejected while suspended.
+	Suspended bool `json:"suspended,omitempty" datastore:",noindex"`
+	// Version is incremented on every write of the client.
+	Version int64 `json:"version,omitempty" datastore:",noindex"`
 }
 
 // GetId return client id.
@@ -26,10 +66,72 @@ func (c *Client) GetId() string {
 }
 
 // GetSecret return client secret.
+// Public client has no secret, so GetSecret of public client always returns empty string.
 func (c *Client) GetSecret() string {
+	if c.IsPublic() {
+		return ""
+	}
 	return c.Secret
 }
 
+// clone returns deep copy of c, which shares no slices with c.
+func (c *Client) clone() *Client {
+	copied := *c
+	copied.AllowedScopes = cloneStrings(c.AllowedScopes)
+	copied.AllowedGrantTypes = cloneStrings(c.AllowedGrantTypes)
+	copied.AllowedResponseTypes = cloneStrings(c.AllowedResponseTypes)
+	if c.Secrets != nil {
+		copied.Secrets = append(make([]ClientSecret, 0, len(c.Secrets)), c.Secrets...)
+	}
+	return &copied
+}
+
+func cloneStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// IsPublic reports whether the client is public client.
+func (c *Client) IsPublic() bool {
+	return c.Type == ClientTypePublic
+}
+
+// ClientSecretMatches implements osin.ClientSecretMatcher.
+// Confidential client never matches empty secret, even if its Secret field is empty.
+// Public client never matches any secret. It matches only when no secret is presented,
+// which is how osin identifies public client, so authorization code flow of public client is protected by PKCE instead.
+// Storage rejects client_credentials grant of public client, and any grant it cannot tell unless CheckAccessRequest or CheckAuthorizeRequest allows it.
+func (c *Client) ClientSecretMatches(secret string) bool {
+	if c.IsPublic() {
+		return secret == ""
+	}
+	if secret == "" {
+		return false
+	}
+	if secretEquals(c.Secret, secret) {
+		return true
+	}
+	now := timeNow()
+	for _, s := range c.Secrets {
+		if !s.expiredAt(now) && secretEquals(s.Value, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) validate() error {
+	if c.GetId() == "" {
+		return ErrEmptyClientID
+	}
+	if c.IsPublic() && (c.Secret != "" || len(c.Secrets) > 0) {
+		return ErrPublicClientSecret
+	}
+	return nil
+}
+
 // GetRedirectUri return redirect uri
 func (c *Client) GetRedirectUri() string {
 	return c.RedirectUri
@@ -40,22 +142,49 @@ func (c *Client) GetUserData() interface{} {
 	return c.UserData
 }
 
+// redactedSecret is printed instead of secrets.
+const redactedSecret = "[REDACTED]"
+
 func (c Client) String() string {
+	secret := ""
+	if c.Secret != "" {
+		secret = redactedSecret
+	}
 	return fmt.Sprintf(
 		"ID: %q, Secret: %q, RedirectURI: %q, UserData: %q",
-		c.ID, c.Secret, c.RedirectUri, c.UserData,
+		c.ID, secret, c.RedirectUri, c.UserData,
 	)
 }
 
+// GoString is used by %#v, and hides secrets as well as String.
+func (c Client) GoString() string {
+	return "datastore.Client{" + c.String() + "}"
+}
+
+// MarshalJSON encodes c without Secret and values of Secrets.
+func (c Client) MarshalJSON() ([]byte, error) {
+	type client Client
+	c.Secret = ""
+	return json.Marshal(client(c))
+}
+
 // ClientStorage is datastore handler for client.
 type ClientStorage struct {
-	client datastore.Client
+	client   datastore.Client
+	cache    *clientCacheState
+	observer Observer
 }
 
 func newClientStorage(client datastore.Client) *ClientStorage {
 	return &ClientStorage{client: client}
 }
 
+// NewClientStorageWithClient create ClientStorage object which uses existing datastore client.
+// Use Storage.ClientStorage to share the client of Storage.
+func NewClientStorageWithClient(client datastore.Client) *ClientStorage {
+	return newClientStorage(client)
+}
+
 // NewClientStorage create ClientStorage object.
 // The object created by this constructor uses Google Cloud Client Library for Go.
 // If you want to use on Google App Engine Standard Edition, it should be recommanded to create object by NewClientStorageForGAE rather than use this.
@@ -64,47 +193,206 @@ func NewClientStorage(ctx context.Context, opt ...datastore.ClientOption) (*Clie
 	if err != nil {
 		return nil, err
 	}
-	return &ClientStorage{client: client}, nil
+	return newClientStorage(client), nil
 }
 
 // NewClientStorageForGAE create ClientStorage object.
 // The object created by this constructor uses Google App Engine SDK for Go.
 // If you want to use on other of Google App Engine Standard Edition, you must create object by NewClientStorage rather than use this.
+// Create it for each request with App Engine context of the request, because aedatastore builds keys with ctx.
 func NewClientStorageForGAE(ctx context.Context, opt ...datastore.ClientOption) (*ClientStorage, error) {
 	client, err := aedatastore.FromContext(ctx, opt...)
 	if err != nil {
 		return nil, err
 	}
-	return &ClientStorage{client: client}, nil
+	return newClientStorage(client), nil
 }
 
 // Put create or update client entity.
 // The ID field of Client uses as Datastore's key.
-func (cl *ClientStorage) Put(ctx context.Context, c *Client) error {
-	if c.GetId() == "" {
-		return ErrEmptyClientID
+// Put overwrites the entity regardless of its Version, so use Insert or Update to avoid losing concurrent changes.
+// Put still increments the stored Version in a transaction, so that Update of the client read before can tell the change,
+// and sets the stored Version to c if it succeeds.
+func (cl *ClientStorage) Put(ctx context.Context, c *Client) (err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientPut, c.GetId())
+	defer func() { o.end(err) }()
+
+	if err := c.validate(); err != nil {
+		return err
 	}
 	key := cl.client.NameKey(KindClient, c.GetId(), nil)
-	_, err := cl.client.Put(ctx, key, c)
-	return err
+	if err := cl.putVersioned(ctx, []datastore.Key{key}, []*Client{c}); err != nil {
+		return errConflictOrDefault(err)
+	}
+	cl.invalidate(ctx, c.GetId())
+	return nil
 }
 
 // PutMulti create or update multiple client entities.
 // The ID field of Client uses as Datastore's key.
-func (cl *ClientStorage) PutMulti(ctx context.Context, cs []*Client) error {
+// Versions are incremented as well as Put, in a transaction for each chunk of clients.
+// Clients more than a transaction can write are put in chunks concurrently,
+// and errors of the chunks are returned as datastore.MultiError for each client.
+func (cl *ClientStorage) PutMulti(ctx context.Context, cs []*Client) (err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientPutMulti, "")
+	defer func() { o.end(err) }()
+
 	keys := make([]datastore.Key, len(cs))
+	ids := make([]string, len(cs))
 	for i, c := range cs {
-		if c.GetId() == "" {
-			return ErrEmptyClientID
+		if err := c.validate(); err != nil {
+			return err
 		}
 		keys[i] = cl.client.NameKey(KindClient, c.GetId(), nil)
+		ids[i] = c.GetId()
+	}
+	err = runChunks(len(keys), maxTransactionSize, func(start, end int) error {
+		return errConflictOrDefault(cl.putVersioned(ctx, keys[start:end], cs[start:end]))
+	})
+	// Some chunks may have been stored even if others failed.
+	cl.invalidate(ctx, ids...)
+	return err
+}
+
+// putVersioned stores copies of cs whose Version is incremented from the stored entities in a transaction.
+// Version of cs is set to the stored one only if the transaction is committed.
+func (cl *ClientStorage) putVersioned(ctx context.Context, keys []datastore.Key, cs []*Client) error {
+	puts := make([]*Client, len(cs))
+	_, err := cl.client.RunInTransaction(ctx, func(tx datastore.Transaction) error {
+		stored := make([]*Client, len(keys))
+		for i := range stored {
+			stored[i] = new(Client)
+		}
+		err := tx.GetMulti(keys, stored)
+		merr, ok := err.(datastore.MultiError)
+		if err != nil && !ok {
+			return err
+		}
+		for i, c := range cs {
+			version := stored[i].Version
+			if ok && merr[i] != nil {
+				if merr[i] != datastore.ErrNoSuchEntity {
+					return merr[i]
+				}
+				version = 0
+			}
+			put := *c
+			put.Version = version + 1
+			puts[i] = &put
+		}
+		_, err = tx.PutMulti(keys, puts)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for i, c := range cs {
+		c.Version = puts[i].Version
+	}
+	return nil
+}
+
+// Insert creates client entity.
+// If the client for the ID already exists, Insert returns ErrClientAlreadyExists instead of overwriting it.
+// Version of c is set to 1 if Insert succeeds.
+func (cl *ClientStorage) Insert(ctx context.Context, c *Client) (err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientInsert, c.GetId())
+	defer func() { o.end(err) }()
+
+	if err := c.validate(); err != nil {
+		return err
+	}
+	key := cl.client.NameKey(KindClient, c.GetId(), nil)
+	insert := *c
+	insert.Version = 1
+	_, err = cl.client.RunInTransaction(ctx, func(tx datastore.Transaction) error {
+		err := tx.Get(key, new(Client))
+		if err == nil {
+			return ErrClientAlreadyExists
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		_, err = tx.Put(key, &insert)
+		return err
+	})
+	if err != nil {
+		return errConflictOrDefault(err)
+	}
+	c.Version = insert.Version
+	cl.invalidate(ctx, c.GetId())
+	return nil
+}
+
+// Update loads the client for id, applies f to it and stores it in a transaction.
+// f receives the current entity, so it can compare Version with the one read before
+// and return ErrConflict to abort the update if the client has been changed since then.
+// If another write conflicts with the transaction, Update returns ErrConflict.
+// The error returned by f is returned as is, and nothing is stored in that case.
+// f may be called more than once, since the datastore client retries the transaction on contention,
+// so f must only modify c and must not have other side effects.
+func (cl *ClientStorage) Update(ctx context.Context, id string, f func(c *Client) error) (err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientUpdate, id)
+	defer func() { o.end(err) }()
+
+	key := cl.client.NameKey(KindClient, id, nil)
+	_, err = cl.client.RunInTransaction(ctx, func(tx datastore.Transaction) error {
+		c := new(Client)
+		if err := tx.Get(key, c); err != nil {
+			return err
+		}
+		c.ID = id
+		version := c.Version
+		if err := f(c); err != nil {
+			return err
+		}
+		if err := c.validate(); err != nil {
+			return err
+		}
+		c.ID = id
+		c.Version = version + 1
+		_, err := tx.Put(key, c)
+		return err
+	})
+	if err != nil {
+		return errConflictOrDefault(err)
+	}
+	cl.invalidate(ctx, id)
+	return nil
+}
+
+func errConflictOrDefault(err error) error {
+	if err == datastore.ErrConcurrentTransaction {
+		return ErrConflict
 	}
-	_, err := cl.client.PutMulti(ctx, keys, cs)
 	return err
 }
 
 // Get search client for given id.
-func (cl *ClientStorage) Get(ctx context.Context, id string) (*Client, error) {
+// If ClientStorage is created by WithCache, Get returns cached client, and caches the loaded client or its absence.
+func (cl *ClientStorage) Get(ctx context.Context, id string) (_ *Client, err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientGet, id)
+	defer func() { o.end(err) }()
+
+	if cl.cache == nil || !cl.checkStamp(ctx) {
+		return cl.get(ctx, id)
+	}
+
+	if c, ok := cl.cache.cache.Get(id); ok {
+		if c == nil {
+			return nil, datastore.ErrNoSuchEntity
+		}
+		return c, nil
+	}
+	c, err := cl.get(ctx, id)
+	switch err {
+	case nil, datastore.ErrNoSuchEntity:
+		cl.cache.cache.Add(id, c)
+	}
+	return c, err
+}
+
+func (cl *ClientStorage) get(ctx context.Context, id string) (*Client, error) {
 	key := cl.client.NameKey(KindClient, id, nil)
 	dst := new(Client)
 	if err := cl.client.Get(ctx, key, dst); err != nil {
@@ -115,33 +403,116 @@ func (cl *ClientStorage) Get(ctx context.Context, id string) (*Client, error) {
 }
 
 // GetMulti search multiple client for given ids.
-func (cl *ClientStorage) GetMulti(ctx context.Context, ids []string) ([]*Client, error) {
+// If any of clients is not found, GetMulti returns error and no clients. Use GetMultiPartial to get found ones.
+// Ids more than Datastore allows in a single call are loaded in chunks concurrently.
+func (cl *ClientStorage) GetMulti(ctx context.Context, ids []string) (_ []*Client, err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientGetMulti, "")
+	defer func() { o.end(err) }()
+
+	clients, err := cl.getMulti(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// GetMultiPartial search multiple client for given ids, and returns every client found.
+// Returned clients have the same length as ids, and the client for an id which could not be loaded is nil.
+// In that case, GetMultiPartial returns datastore.MultiError of the same length,
+// whose element is osin.ErrNotFound for missing client, other error for failed one, and nil for found one.
+// Other errors (e.g. failure of the whole RPC) are returned as is with no clients,
+// unless ids are loaded in chunks, where failure of a chunk is reported for each client in the chunk.
+func (cl *ClientStorage) GetMultiPartial(ctx context.Context, ids []string) (_ []*Client, err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientGetMultiPartial, "")
+	defer func() { o.end(err) }()
+
+	clients, err := cl.getMulti(ctx, ids)
+	merr, ok := err.(datastore.MultiError)
+	if !ok {
+		return clients, err
+	}
+
+	errs := make(datastore.MultiError, len(merr))
+	for i, e := range merr {
+		if e == datastore.ErrNoSuchEntity {
+			e = osin.ErrNotFound
+		}
+		errs[i] = e
+	}
+	return clients, errs
+}
+
+// getMulti loads clients for ids. Clients for which datastore.MultiError reports error are set to nil.
+func (cl *ClientStorage) getMulti(ctx context.Context, ids []string) ([]*Client, error) {
 	keys := make([]datastore.Key, len(ids))
 	for i, id := range ids {
 		keys[i] = cl.client.NameKey(KindClient, id, nil)
 	}
 
 	clients := make([]*Client, len(keys))
-	if err := cl.client.GetMulti(ctx, keys, clients); err != nil {
+	err := runChunks(len(keys), maxGetMultiSize, func(start, end int) error {
+		return cl.client.GetMulti(ctx, keys[start:end], clients[start:end])
+	})
+	merr, ok := err.(datastore.MultiError)
+	if err != nil && !ok {
 		return nil, err
 	}
 	for i, id := range ids {
-		clients[i].ID = id
+		if ok && merr[i] != nil {
+			clients[i] = nil
+			continue
+		}
+		if clients[i] != nil {
+			clients[i].ID = id
+		}
 	}
-	return clients, nil
+	return clients, err
 }
 
 // Delete removes client entitye for id from Datastore.
-func (cl *ClientStorage) Delete(ctx context.Context, id string) error {
+func (cl *ClientStorage) Delete(ctx context.Context, id string) (err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientDelete, id)
+	defer func() { o.end(err) }()
+
 	key := cl.client.NameKey(KindClient, id, nil)
-	return cl.client.Delete(ctx, key)
+	if err := cl.client.Delete(ctx, key); err != nil {
+		return err
+	}
+	cl.invalidate(ctx, id)
+	return nil
+}
+
+// Suspend disables the client for id until Resume is called.
+func (cl *ClientStorage) Suspend(ctx context.Context, id string) error {
+	return cl.Update(ctx, id, func(c *Client) error {
+		c.Suspended = true
+		return nil
+	})
+}
+
+// Resume enables the client for id suspended by Suspend.
+func (cl *ClientStorage) Resume(ctx context.Context, id string) error {
+	return cl.Update(ctx, id, func(c *Client) error {
+		c.Suspended = false
+		return nil
+	})
 }
 
 // DeleteMulti removes multiple clients entitye for ids from Datastore.
-func (cl *ClientStorage) DeleteMulti(ctx context.Context, ids []string) error {
+// Ids more than Datastore allows in a single call are deleted in chunks concurrently,
+// and errors of the chunks are returned as datastore.MultiError for each id.
+func (cl *ClientStorage) DeleteMulti(ctx context.Context, ids []string) (err error) {
+	ctx, o := cl.startOperation(ctx, OperationClientDeleteMulti, "")
+	defer func() { o.end(err) }()
+
 	keys := make([]datastore.Key, len(ids))
 	for i, id := range ids {
 		keys[i] = cl.client.NameKey(KindClient, id, nil)
 	}
-	return cl.client.DeleteMulti(ctx, keys)
+	err = runChunks(len(keys), maxDeleteMultiSize, func(start, end int) error {
+		return cl.client.DeleteMulti(ctx, keys[start:end])
+	})
+	// Some chunks may have been deleted even if others failed.
+	cl.invalidate(ctx, ids...)
+	return err
 }
@@ -0,0 +1,212 @@
+package datastore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mercari.io/datastore"
+)
+
+// KindClientVersion is kind of the version stamp entity of clients.
+// ClientStorage with cache rewrites the stamp on every write of clients,
+// so that other instances can notice the changes and purge their cache.
+// ClientStorage without cache does not write it, so clients must be written only through ClientStorage with cache
+// while any instance caches them.
+const KindClientVersion = "client_version"
+
+// clientVersionKeyName is key name of the single version stamp entity.
+const clientVersionKeyName = "clients"
+
+// ClientCache caches clients loaded by ClientStorage.
+// nil Client is cached for id whose client does not exist.
+// Implementations must be safe for concurrent use.
+type ClientCache interface {
+	// Get returns cached client for id. ok is false if the client is not cached.
+	Get(id string) (c *Client, ok bool)
+	// Add caches c for id. c may be nil to cache that the client does not exist.
+	Add(id string, c *Client)
+	// Remove removes cached client for id.
+	Remove(id string)
+	// Purge removes all cached clients.
+	Purge()
+}
+
+type clientVersion struct {
+	Stamp int64 `datastore:",noindex"`
+}
+
+// LRUClientCache is in-memory ClientCache which evicts least recently used clients.
+type LRUClientCache struct {
+	lru *lru
+	ttl time.Duration
+}
+
+// NewLRUClientCache create LRUClientCache which holds up to size clients for ttl.
+// Zero ttl means cached clients never expire, and they are removed only by eviction or writes.
+func NewLRUClientCache(size int, ttl time.Duration) *LRUClientCache {
+	return &LRUClientCache{
+		lru: newLRU(size),
+		ttl: ttl,
+	}
+}
+
+// Get returns cached client for id.
+// The returned client is a deep copy including its slices, so it can be modified by the caller.
+func (l *LRUClientCache) Get(id string) (*Client, bool) {
+	v, ok := l.lru.get(id)
+	if !ok {
+		return nil, false
+	}
+	c := v.(*Client)
+	if c == nil {
+		return nil, true
+	}
+	return c.clone(), true
+}
+
+// Add caches deep copy of c for id, and evicts least recently used client if the cache is full.
+func (l *LRUClientCache) Add(id string, c *Client) {
+	if c != nil {
+		c = c.clone()
+	}
+	var expiresAt time.Time
+	if l.ttl > 0 {
+		expiresAt = timeNow().Add(l.ttl)
+	}
+	l.lru.add(id, c, expiresAt)
+}
+
+// Remove removes cached client for id.
+func (l *LRUClientCache) Remove(id string) {
+	l.lru.remove(id)
+}
+
+// Purge removes all cached clients.
+func (l *LRUClientCache) Purge() {
+	l.lru.purge()
+}
+
+// Len returns number of cached clients including expired ones not removed yet.
+func (l *LRUClientCache) Len() int {
+	return l.lru.len()
+}
+
+// clientCacheState is cache shared between copies of ClientStorage with the version stamp known by this instance.
+type clientCacheState struct {
+	cache         ClientCache
+	stampInterval time.Duration
+
+	mu        sync.Mutex
+	stamp     int64
+	checkedAt time.Time
+}
+
+// WithCache returns ClientStorage which shares datastore client with cl and caches clients in cache.
+// Writes through the returned ClientStorage remove the clients from cache and rewrite the version stamp entity on a best-effort basis.
+// The stamp is read at most once in stampInterval, and cache is purged when other instance has changed it.
+// Zero stampInterval disables reading the stamp, so changes by other instances are noticed only after cached clients expire.
+//
+// Only ClientStorage with cache writes the stamp. While any instance caches clients, every write of clients, including
+// writes by admin tools, must go through ClientStorage created by WithCache. Writes through ClientStorage without cache
+// (e.g. Storage.ClientStorage or NewClientStorageWithClient) are not noticed by other instances until their cached clients
+// expire, so they keep serving stale clients, such as suspended clients and retired secrets, for up to the TTL of the cache.
+func (cl *ClientStorage) WithCache(cache ClientCache, stampInterval time.Duration) *ClientStorage {
+	c := *cl
+	c.cache = &clientCacheState{
+		cache:         cache,
+		stampInterval: stampInterval,
+	}
+	return &c
+}
+
+func (cl *ClientStorage) versionKey() datastore.Key {
+	return cl.client.NameKey(KindClientVersion, clientVersionKeyName, nil)
+}
+
+// checkStamp purges cache if the version stamp has been changed by other instance.
+// checkStamp returns false if the stamp could not be read, then cache should not be used.
+func (cl *ClientStorage) checkStamp(ctx context.Context) bool {
+	s := cl.cache
+	if s.stampInterval <= 0 {
+		return true
+	}
+
+	now := timeNow()
+	s.mu.Lock()
+	due := s.checkedAt.IsZero() || now.Sub(s.checkedAt) >= s.stampInterval
+	s.mu.Unlock()
+	if !due {
+		return true
+	}
+
+	v := new(clientVersion)
+	if err := cl.client.Get(ctx, cl.versionKey(), v); err != nil && err != datastore.ErrNoSuchEntity {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v.Stamp != s.stamp {
+		s.cache.Purge()
+		s.stamp = v.Stamp
+	}
+	s.checkedAt = now
+	return true
+}
+
+// invalidate removes clients for ids from cache and rewrites the version stamp.
+// The clients have been written already, so failure to write the stamp (e.g. contention of concurrent writes
+// to the single stamp entity) is reported to observer as OperationClientStamp and ignored.
+// Other instances notice the change after their cached clients expire in that case.
+func (cl *ClientStorage) invalidate(ctx context.Context, ids ...string) {
+	s := cl.cache
+	if s == nil {
+		return
+	}
+	for _, id := range ids {
+		s.cache.Remove(id)
+	}
+	cl.writeStamp(ctx)
+}
+
+func (cl *ClientStorage) writeStamp(ctx context.Context) (err error) {
+	ctx, o := startOperation(ctx, noCancel, cl.observer, OperationClientStamp, KindClientVersion, clientVersionKeyName)
+	defer func() { o.end(err) }()
+
+	stamp := timeNow().UnixNano()
+	if _, err := cl.client.Put(ctx, cl.versionKey(), &clientVersion{Stamp: stamp}); err != nil {
+		return err
+	}
+	s := cl.cache
+	s.mu.Lock()
+	s.stamp = stamp
+	s.mu.Unlock()
+	return nil
+}
+
+// requestClients memoizes clients loaded through a view of Storage, so that a request loads each client once.
+// nil Client is memoized for id whose client does not exist.
+type requestClients struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+func newRequestClients() *requestClients {
+	return &requestClients{clients: make(map[string]*Client)}
+}
+
+func (r *requestClients) get(id string) (*Client, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, ok := r.clients[id]
+	return c, ok
+}
+
+func (r *requestClients) add(id string, c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clients[id] = c
+}
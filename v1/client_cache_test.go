@@ -0,0 +1,257 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+)
+
+func TestLRUClientCache(t *testing.T) {
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	cache := NewLRUClientCache(2, time.Minute)
+	cache.Add("client1", &Client{ID: "client1"})
+	cache.Add("client2", nil)
+
+	if got, ok := cache.Get("client1"); !ok || !reflect.DeepEqual(&Client{ID: "client1"}, got) {
+		t.Errorf("cached client\nwant: %#v\n got: %#v, %v", &Client{ID: "client1"}, got, ok)
+	}
+	if got, ok := cache.Get("client2"); !ok || got != nil {
+		t.Errorf("negative cache\nwant: nil, true\n got: %#v, %v", got, ok)
+	}
+
+	// client1 is used more recently than client2, so client2 is evicted.
+	cache.Get("client1")
+	cache.Add("client3", &Client{ID: "client3"})
+	if _, ok := cache.Get("client2"); ok {
+		t.Error("least recently used client must be evicted")
+	}
+	if cache.Len() != 2 {
+		t.Errorf("len\nwant: %v\n got: %v", 2, cache.Len())
+	}
+
+	cache.Remove("client3")
+	if _, ok := cache.Get("client3"); ok {
+		t.Error("removed client must not be cached")
+	}
+
+	timeNow = func() time.Time { return now.Add(time.Minute) }
+	if _, ok := cache.Get("client1"); ok {
+		t.Error("expired client must not be cached")
+	}
+
+	cache.Add("client1", &Client{ID: "client1"})
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Errorf("len after purge\nwant: %v\n got: %v", 0, cache.Len())
+	}
+}
+
+func TestLRUClientCache_GetReturnsCopy(t *testing.T) {
+	cache := NewLRUClientCache(1, 0)
+	cache.Add("client", &Client{ID: "client"})
+
+	got, _ := cache.Get("client")
+	got.Suspended = true
+	if got, _ := cache.Get("client"); got.Suspended {
+		t.Error("modifying returned client must not change cached client")
+	}
+
+	added := &Client{
+		ID:                   "client",
+		AllowedScopes:        []string{"read"},
+		AllowedGrantTypes:    []string{"authorization_code"},
+		AllowedResponseTypes: []string{"code"},
+		Secrets:              []ClientSecret{{ID: "s1", Value: "secret"}},
+	}
+	cache.Add("client", added)
+	added.AllowedScopes[0] = "added"
+	got, _ = cache.Get("client")
+	got.AllowedScopes[0] = "got"
+	got.AllowedGrantTypes[0] = "got"
+	got.AllowedResponseTypes[0] = "got"
+	got.Secrets[0].Value = "got"
+	want := &Client{
+		ID:                   "client",
+		AllowedScopes:        []string{"read"},
+		AllowedGrantTypes:    []string{"authorization_code"},
+		AllowedResponseTypes: []string{"code"},
+		Secrets:              []ClientSecret{{ID: "s1", Value: "secret"}},
+	}
+	if got, _ := cache.Get("client"); !reflect.DeepEqual(want, got) {
+		t.Errorf("modifying slices of added or returned client must not change cached client\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+func TestClientStorage_Get_Cache(t *testing.T) {
+	type (
+		in struct {
+			id string
+		}
+
+		returns struct {
+			client *Client
+			err    error
+		}
+
+		out struct {
+			client *Client
+			err    error
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		returns  returns
+		out      out
+	}{
+		{
+			testName: "found",
+			in:       in{id: "client"},
+			returns:  returns{client: &Client{Secret: "secret"}},
+			out:      out{client: &Client{ID: "client", Secret: "secret"}},
+		},
+		{
+			testName: "not found",
+			in:       in{id: "client"},
+			returns:  returns{err: datastore.ErrNoSuchEntity},
+			out:      out{err: datastore.ErrNoSuchEntity},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			key := &mockKey{kind: KindClient, name: tt.in.id}
+			mockDS := NewMockClient(ctrl)
+			// Datastore is read only for the first Get.
+			mockDS.EXPECT().NameKey(KindClient, tt.in.id, gomock.Nil()).Return(key)
+			mockDS.EXPECT().Get(gomock.Any(), key, gomock.Any()).DoAndReturn(func(_ context.Context, _ datastore.Key, dst interface{}) error {
+				if tt.returns.client != nil {
+					*dst.(*Client) = *tt.returns.client
+				}
+				return tt.returns.err
+			})
+
+			cr := (&ClientStorage{client: mockDS}).WithCache(NewLRUClientCache(10, time.Minute), 0)
+			for i := 0; i < 2; i++ {
+				got, err := cr.Get(context.Background(), tt.in.id)
+				if err != tt.out.err {
+					t.Errorf("return error\nwant: %#v\n got: %#v", tt.out.err, err)
+				}
+				if !reflect.DeepEqual(tt.out.client, got) {
+					t.Errorf("\nwant: %#v\n got: %#v", tt.out.client, got)
+				}
+			}
+		})
+	}
+}
+
+func TestClientStorage_Get_StampChanged(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		versionKey = &mockKey{kind: KindClientVersion, name: clientVersionKeyName}
+		key        = &mockKey{kind: KindClient, name: "client"}
+		mockDS     = NewMockClient(ctrl)
+		cache      = NewLRUClientCache(10, 0)
+	)
+	cache.Add("client", &Client{ID: "client", Secret: "old"})
+
+	mockDS.EXPECT().NameKey(KindClientVersion, clientVersionKeyName, gomock.Nil()).Return(versionKey)
+	mockDS.EXPECT().Get(gomock.Any(), versionKey, gomock.Any()).DoAndReturn(func(_ context.Context, _ datastore.Key, dst interface{}) error {
+		dst.(*clientVersion).Stamp = 2
+		return nil
+	})
+	mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+	mockDS.EXPECT().Get(gomock.Any(), key, gomock.Any()).DoAndReturn(func(_ context.Context, _ datastore.Key, dst interface{}) error {
+		dst.(*Client).Secret = "new"
+		return nil
+	})
+
+	cr := (&ClientStorage{client: mockDS}).WithCache(cache, time.Minute)
+	cr.cache.stamp = 1
+
+	got, err := cr.Get(context.Background(), "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&Client{ID: "client", Secret: "new"}); !reflect.DeepEqual(want, got) {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+func TestClientStorage_Put_InvalidatesCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		versionKey = &mockKey{kind: KindClientVersion, name: clientVersionKeyName}
+		key        = &mockKey{kind: KindClient, name: "client"}
+		mockDS     = NewMockClient(ctrl)
+		cache      = NewLRUClientCache(10, 0)
+		client     = &Client{ID: "client", Secret: "new"}
+	)
+	cache.Add("client", &Client{ID: "client", Secret: "old"})
+
+	mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+	expectPutVersioned(ctrl, mockDS, []datastore.Key{key}, []int64{0}, []*Client{{ID: "client", Secret: "new", Version: 1}})
+	mockDS.EXPECT().NameKey(KindClientVersion, clientVersionKeyName, gomock.Nil()).Return(versionKey)
+	mockDS.EXPECT().Put(gomock.Any(), versionKey, gomock.Any()).Return(versionKey, nil)
+
+	cr := (&ClientStorage{client: mockDS}).WithCache(cache, 0)
+	if err := cr.Put(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("client"); ok {
+		t.Error("written client must be removed from cache")
+	}
+	if cr.cache.stamp == 0 {
+		t.Error("stamp must be updated by write")
+	}
+}
+
+func TestClientStorage_Put_StampFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		versionKey = &mockKey{kind: KindClientVersion, name: clientVersionKeyName}
+		key        = &mockKey{kind: KindClient, name: "client"}
+		mockDS     = NewMockClient(ctrl)
+		cache      = NewLRUClientCache(10, 0)
+		stampErr   = errors.New("too much contention")
+		events     []Event
+	)
+	cache.Add("client", &Client{ID: "client", Secret: "old"})
+
+	mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+	expectPutVersioned(ctrl, mockDS, []datastore.Key{key}, []int64{0}, []*Client{{ID: "client", Secret: "new", Version: 1}})
+	mockDS.EXPECT().NameKey(KindClientVersion, clientVersionKeyName, gomock.Nil()).Return(versionKey)
+	mockDS.EXPECT().Put(gomock.Any(), versionKey, gomock.Any()).Return(nil, stampErr)
+
+	cr := (&ClientStorage{client: mockDS}).WithCache(cache, 0).WithObserver(ObserverFunc(func(_ context.Context, ev Event) {
+		events = append(events, ev)
+	}))
+	if err := cr.Put(context.Background(), &Client{ID: "client", Secret: "new"}); err != nil {
+		t.Fatalf("failure of stamp must not fail the write: %v", err)
+	}
+	if _, ok := cache.Get("client"); ok {
+		t.Error("written client must be removed from cache")
+	}
+	if len(events) != 2 || events[0].Op != OperationClientStamp || events[0].Err != stampErr || events[1].Op != OperationClientPut || events[1].Err != nil {
+		t.Errorf("unexpected events: %#v", events)
+	}
+}
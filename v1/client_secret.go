@@ -0,0 +1,143 @@
+package datastore
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+var timeNow = time.Now
+
+// ClientSecret is one of secrets of a client.
+// A client can hold several secrets while rotating them, each with its own creation and expiry time.
+type ClientSecret struct {
+	ID        string    `json:"id,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	// ExpiresAt is the time when the secret stops to authenticate the client. Zero value means never expires.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+func (s ClientSecret) String() string {
+	value := ""
+	if s.Value != "" {
+		value = redactedSecret
+	}
+	return fmt.Sprintf("ID: %q, Value: %q, CreatedAt: %v, ExpiresAt: %v", s.ID, value, s.CreatedAt, s.ExpiresAt)
+}
+
+// GoString is used by %#v, and hides Value as well as String.
+func (s ClientSecret) GoString() string {
+	return "datastore.ClientSecret{" + s.String() + "}"
+}
+
+// MarshalJSON encodes s without Value.
+func (s ClientSecret) MarshalJSON() ([]byte, error) {
+	type clientSecret ClientSecret
+	s.Value = ""
+	return json.Marshal(clientSecret(s))
+}
+
+func (s *ClientSecret) expiredAt(t time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !t.Before(s.ExpiresAt)
+}
+
+// PrimarySecretID is ID of Secret field of Client, listed by ListSecrets and retired by RetireSecret as well as Secrets.
+// IDs of secrets added by AddSecret never collide with it.
+const PrimarySecretID = "primary"
+
+func secretEquals(want, got string) bool {
+	if want == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
+}
+
+func newClientSecretID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AddSecret adds secret to the client for id, which is valid until expiresAt.
+// Zero expiresAt means the secret never expires.
+// AddSecret returns metadata of the added secret, whose Value is empty.
+func (cl *ClientStorage) AddSecret(ctx context.Context, id, secret string, expiresAt time.Time) (*ClientSecret, error) {
+	if secret == "" {
+		return nil, ErrEmptyClientSecret
+	}
+	secretID, err := newClientSecretID()
+	if err != nil {
+		return nil, err
+	}
+
+	added := ClientSecret{
+		ID:        secretID,
+		Value:     secret,
+		CreatedAt: timeNow(),
+		ExpiresAt: expiresAt,
+	}
+	err = cl.Update(ctx, id, func(c *Client) error {
+		if c.IsPublic() {
+			return ErrPublicClientSecret
+		}
+		c.Secrets = append(c.Secrets, added)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	added.Value = ""
+	return &added, nil
+}
+
+// ListSecrets returns metadata of secrets of the client for id.
+// Secret field of the client comes first with PrimarySecretID if it is set, which has neither creation nor expiry time.
+// Value of returned secrets is always empty.
+func (cl *ClientStorage) ListSecrets(ctx context.Context, id string) ([]*ClientSecret, error) {
+	c, err := cl.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := make([]*ClientSecret, 0, len(c.Secrets)+1)
+	if c.Secret != "" {
+		secrets = append(secrets, &ClientSecret{ID: PrimarySecretID})
+	}
+	for i := range c.Secrets {
+		s := c.Secrets[i]
+		s.Value = ""
+		secrets = append(secrets, &s)
+	}
+	return secrets, nil
+}
+
+// RetireSecret removes secret for secretID from the client for id.
+// PrimarySecretID retires Secret field of the client, so rotating away from it needs AddSecret and RetireSecret only.
+// The secret stops to authenticate the client immediately.
+// If the client does not have the secret, RetireSecret returns ErrClientSecretNotFound.
+func (cl *ClientStorage) RetireSecret(ctx context.Context, id, secretID string) error {
+	return cl.Update(ctx, id, func(c *Client) error {
+		if secretID == PrimarySecretID {
+			if c.Secret == "" {
+				return ErrClientSecretNotFound
+			}
+			c.Secret = ""
+			return nil
+		}
+		for i, s := range c.Secrets {
+			if s.ID == secretID {
+				c.Secrets = append(c.Secrets[:i], c.Secrets[i+1:]...)
+				return nil
+			}
+		}
+		return ErrClientSecretNotFound
+	})
+}
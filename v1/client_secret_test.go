@@ -0,0 +1,216 @@
+package datastore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+)
+
+func TestClient_ClientSecretMatches_Rotation(t *testing.T) {
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	client := &Client{
+		ID:     "client",
+		Secret: "primary",
+		Secrets: []ClientSecret{
+			{ID: "1", Value: "expired", ExpiresAt: now.Add(-time.Second)},
+			{ID: "2", Value: "valid", ExpiresAt: now.Add(time.Hour)},
+			{ID: "3", Value: "forever"},
+		},
+	}
+
+	tests := []struct {
+		testName string
+		secret   string
+		matches  bool
+	}{
+		{testName: "primary secret", secret: "primary", matches: true},
+		{testName: "expired secret", secret: "expired", matches: false},
+		{testName: "unexpired secret", secret: "valid", matches: true},
+		{testName: "secret without expiry", secret: "forever", matches: true},
+		{testName: "unknown secret", secret: "unknown", matches: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := client.ClientSecretMatches(tt.secret); got != tt.matches {
+				t.Errorf("want: %v, got: %v", tt.matches, got)
+			}
+		})
+	}
+}
+
+func TestClientStorage_AddSecret(t *testing.T) {
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		key       = &mockKey{kind: KindClient, name: "client"}
+		expiresAt = now.Add(time.Hour)
+		mockTx    = NewMockTransaction(ctrl)
+		mockDS    = NewMockClient(ctrl)
+		stored    *Client
+	)
+	mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+	mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, f func(datastore.Transaction) error) (datastore.Commit, error) {
+		return nil, f(mockTx)
+	})
+	mockTx.EXPECT().Get(key, gomock.Any()).DoAndReturn(func(_ datastore.Key, dst interface{}) error {
+		dst.(*Client).Secret = "primary"
+		return nil
+	})
+	mockTx.EXPECT().Put(key, gomock.Any()).DoAndReturn(func(_ datastore.Key, src interface{}) (datastore.PendingKey, error) {
+		stored = src.(*Client)
+		return nil, nil
+	})
+
+	cr := &ClientStorage{client: mockDS}
+	got, err := cr.AddSecret(context.Background(), "client", "new_secret", expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &ClientSecret{ID: got.ID, CreatedAt: now, ExpiresAt: expiresAt}
+	if got.ID == "" || !reflect.DeepEqual(want, got) {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got)
+	}
+	wantStored := &Client{
+		ID:      "client",
+		Secret:  "primary",
+		Secrets: []ClientSecret{{ID: got.ID, Value: "new_secret", CreatedAt: now, ExpiresAt: expiresAt}},
+		Version: 1,
+	}
+	if !reflect.DeepEqual(wantStored, stored) {
+		t.Errorf("stored\nwant: %#v\n got: %#v", wantStored, stored)
+	}
+}
+
+func TestClientStorage_ListSecrets(t *testing.T) {
+	createdAt := time.Now()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	key := &mockKey{kind: KindClient, name: "client"}
+	mockDS := NewMockClient(ctrl)
+	mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+	mockDS.EXPECT().Get(gomock.Any(), key, gomock.Any()).DoAndReturn(func(_ context.Context, _ datastore.Key, dst interface{}) error {
+		dst.(*Client).Secret = "secret0"
+		dst.(*Client).Secrets = []ClientSecret{
+			{ID: "1", Value: "secret1", CreatedAt: createdAt},
+			{ID: "2", Value: "secret2", CreatedAt: createdAt},
+		}
+		return nil
+	})
+
+	cr := &ClientStorage{client: mockDS}
+	got, err := cr.ListSecrets(context.Background(), "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*ClientSecret{
+		{ID: PrimarySecretID},
+		{ID: "1", CreatedAt: createdAt},
+		{ID: "2", CreatedAt: createdAt},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+func TestClientStorage_RetireSecret(t *testing.T) {
+	type (
+		in struct {
+			secretID string
+		}
+
+		out struct {
+			secret  string
+			secrets []ClientSecret
+			err     error
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "retire",
+			in:       in{secretID: "1"},
+			out: out{
+				secret:  "secret0",
+				secrets: []ClientSecret{{ID: "2", Value: "secret2"}},
+			},
+		},
+		{
+			testName: "retire primary",
+			in:       in{secretID: PrimarySecretID},
+			out: out{
+				secret:  "",
+				secrets: []ClientSecret{{ID: "1", Value: "secret1"}, {ID: "2", Value: "secret2"}},
+			},
+		},
+		{
+			testName: "not found",
+			in:       in{secretID: "3"},
+			out: out{
+				err: ErrClientSecretNotFound,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				key    = &mockKey{kind: KindClient, name: "client"}
+				mockTx = NewMockTransaction(ctrl)
+				mockDS = NewMockClient(ctrl)
+			)
+			mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+			mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, f func(datastore.Transaction) error) (datastore.Commit, error) {
+				return nil, f(mockTx)
+			})
+			mockTx.EXPECT().Get(key, gomock.Any()).DoAndReturn(func(_ datastore.Key, dst interface{}) error {
+				dst.(*Client).Secret = "secret0"
+				dst.(*Client).Secrets = []ClientSecret{
+					{ID: "1", Value: "secret1"},
+					{ID: "2", Value: "secret2"},
+				}
+				return nil
+			})
+			if tt.out.err == nil {
+				mockTx.EXPECT().Put(key, gomock.Any()).DoAndReturn(func(_ datastore.Key, src interface{}) (datastore.PendingKey, error) {
+					if got := src.(*Client).Secrets; !reflect.DeepEqual(tt.out.secrets, got) {
+						t.Errorf("secrets\nwant: %#v\n got: %#v", tt.out.secrets, got)
+					}
+					if got := src.(*Client).Secret; got != tt.out.secret {
+						t.Errorf("secret\nwant: %q\n got: %q", tt.out.secret, got)
+					}
+					return nil, nil
+				})
+			}
+
+			cr := &ClientStorage{client: mockDS}
+			if err := cr.RetireSecret(context.Background(), "client", tt.in.secretID); err != tt.out.err {
+				t.Errorf("return error\nwant: %#v\n got: %#v", tt.out.err, err)
+			}
+		})
+	}
+}
@@ -2,14 +2,46 @@ package datastore
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/RangelReale/osin"
 	"github.com/golang/mock/gomock"
 
 	"go.mercari.io/datastore"
 )
 
+// expectPutVersioned expects transaction of putVersioned, which loads entities for keys with stored versions
+// (0 means no entity) and puts want.
+func expectPutVersioned(ctrl *gomock.Controller, mockDS *MockClient, keys []datastore.Key, stored []int64, want []*Client) {
+	mockTx := NewMockTransaction(ctrl)
+	mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, f func(datastore.Transaction) error) (datastore.Commit, error) {
+		return nil, f(mockTx)
+	})
+	mockTx.EXPECT().GetMulti(keys, gomock.Any()).DoAndReturn(func(_ []datastore.Key, dst interface{}) error {
+		var merr datastore.MultiError
+		for i, v := range stored {
+			if v == 0 {
+				if merr == nil {
+					merr = make(datastore.MultiError, len(stored))
+				}
+				merr[i] = datastore.ErrNoSuchEntity
+				continue
+			}
+			dst.([]*Client)[i].Version = v
+		}
+		if merr == nil {
+			return nil
+		}
+		return merr
+	})
+	mockTx.EXPECT().PutMulti(keys, want).Return(nil, nil)
+}
+
 func TestClientStorage_Put(t *testing.T) {
 	type (
 		in struct {
@@ -17,7 +49,12 @@ func TestClientStorage_Put(t *testing.T) {
 		}
 
 		returns struct {
-			key datastore.Key
+			key     datastore.Key
+			version int64
+		}
+
+		out struct {
+			put *Client
 		}
 	)
 
@@ -25,15 +62,32 @@ func TestClientStorage_Put(t *testing.T) {
 		testName string
 		in       in
 		returns  returns
+		out      out
 	}{
 		{
-			testName: "test1",
+			testName: "new client",
 			in: in{
 				client: &Client{ID: "sample", Secret: "secret", RedirectUri: "redirect"},
 			},
 			returns: returns{
 				key: &mockKey{kind: "test", name: "sample"},
 			},
+			out: out{
+				put: &Client{ID: "sample", Secret: "secret", RedirectUri: "redirect", Version: 1},
+			},
+		},
+		{
+			testName: "existing client",
+			in: in{
+				client: &Client{ID: "sample", Secret: "secret", Version: 1},
+			},
+			returns: returns{
+				key:     &mockKey{kind: "test", name: "sample"},
+				version: 3,
+			},
+			out: out{
+				put: &Client{ID: "sample", Secret: "secret", Version: 4},
+			},
 		},
 	}
 
@@ -44,13 +98,17 @@ func TestClientStorage_Put(t *testing.T) {
 
 			mockDSClient := NewMockClient(ctrl)
 			mockDSClient.EXPECT().NameKey(KindClient, tt.in.client.ID, gomock.Nil()).Return(tt.returns.key)
-			mockDSClient.EXPECT().Put(gomock.Any(), tt.returns.key, tt.in.client).Return(tt.returns.key, nil)
+			keys := []datastore.Key{tt.returns.key}
+			expectPutVersioned(ctrl, mockDSClient, keys, []int64{tt.returns.version}, []*Client{tt.out.put})
 
 			cr := &ClientStorage{client: mockDSClient}
 			err := cr.Put(context.Background(), tt.in.client)
 			if err != nil {
 				t.Error(err)
 			}
+			if tt.in.client.Version != tt.out.put.Version {
+				t.Errorf("version\nwant: %v\n got: %v", tt.out.put.Version, tt.in.client.Version)
+			}
 		})
 	}
 }
@@ -95,6 +153,123 @@ func TestClientStorage_Put_InValidClient(t *testing.T) {
 	}
 }
 
+func TestClientStorage_Put_PublicClientWithSecret(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	cr := &ClientStorage{client: NewMockClient(ctrl)}
+	err := cr.Put(context.Background(), &Client{ID: "sample", Type: ClientTypePublic, Secret: "secret"})
+	if err != ErrPublicClientSecret {
+		t.Errorf("return error\nwant: %#v\n got: %#v", ErrPublicClientSecret, err)
+	}
+}
+
+func TestClient_ClientSecretMatches(t *testing.T) {
+	type (
+		in struct {
+			client *Client
+			secret string
+		}
+
+		out struct {
+			matches bool
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "confidential client with valid secret",
+			in: in{
+				client: &Client{ID: "client", Secret: "secret"},
+				secret: "secret",
+			},
+			out: out{matches: true},
+		},
+		{
+			testName: "confidential client with invalid secret",
+			in: in{
+				client: &Client{ID: "client", Secret: "secret"},
+				secret: "invalid",
+			},
+			out: out{matches: false},
+		},
+		{
+			testName: "confidential client without secret",
+			in: in{
+				client: &Client{ID: "client", Type: ClientTypeConfidential},
+				secret: "",
+			},
+			out: out{matches: false},
+		},
+		{
+			testName: "public client presents no secret",
+			in: in{
+				client: &Client{ID: "client", Type: ClientTypePublic},
+				secret: "",
+			},
+			out: out{matches: true},
+		},
+		{
+			testName: "public client presents secret",
+			in: in{
+				client: &Client{ID: "client", Type: ClientTypePublic, Secret: "secret"},
+				secret: "secret",
+			},
+			out: out{matches: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := tt.in.client.ClientSecretMatches(tt.in.secret); got != tt.out.matches {
+				t.Errorf("want: %v, got: %v", tt.out.matches, got)
+			}
+		})
+	}
+}
+
+func TestClient_HidesSecrets(t *testing.T) {
+	client := &Client{
+		ID:      "client",
+		Secret:  "client-secret",
+		Secrets: []ClientSecret{{ID: "secret-id", Value: "rotated-secret"}},
+	}
+
+	encoded, err := json.Marshal(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := map[string]string{
+		"%v":   fmt.Sprintf("%v", client),
+		"%+v":  fmt.Sprintf("%+v", *client),
+		"%#v":  fmt.Sprintf("%#v", client),
+		"%s":   fmt.Sprintf("%s", client.Secrets),
+		"json": string(encoded),
+	}
+	for format, out := range outputs {
+		for _, secret := range []string{"client-secret", "rotated-secret"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s must not contain %q: %s", format, secret, out)
+			}
+		}
+	}
+	if !strings.Contains(outputs["json"], "secret-id") {
+		t.Errorf("json must contain metadata of secrets: %s", outputs["json"])
+	}
+
+	decoded := new(Client)
+	if err := json.Unmarshal([]byte(`{"id":"client","secret":"client-secret"}`), decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Secret != "client-secret" {
+		t.Errorf("json decoding must read secret, got: %q", decoded.Secret)
+	}
+}
+
 func TestClientStorage_PutMulti(t *testing.T) {
 	type (
 		in struct {
@@ -150,10 +325,16 @@ func TestClientStorage_PutMulti(t *testing.T) {
 			defer ctrl.Finish()
 
 			mockDSClient := NewMockClient(ctrl)
+			stored := make([]int64, len(tt.in.clients))
+			want := make([]*Client, len(tt.in.clients))
 			for i, client := range tt.in.clients {
 				mockDSClient.EXPECT().NameKey(KindClient, client.ID, gomock.Nil()).Return(tt.returns.keys[i])
+				stored[i] = int64(i)
+				put := *client
+				put.Version = int64(i) + 1
+				want[i] = &put
 			}
-			mockDSClient.EXPECT().PutMulti(gomock.Any(), tt.returns.keys, tt.in.clients).Return(nil, nil)
+			expectPutVersioned(ctrl, mockDSClient, tt.returns.keys, stored, want)
 
 			cr := &ClientStorage{client: mockDSClient}
 			err := cr.PutMulti(context.Background(), tt.in.clients)
@@ -424,3 +605,271 @@ func TestClientStorage_DeleteMulti(t *testing.T) {
 		})
 	}
 }
+
+func TestClientStorage_Suspend(t *testing.T) {
+	tests := []struct {
+		testName  string
+		suspend   bool
+		suspended bool
+	}{
+		{testName: "suspend", suspend: true, suspended: true},
+		{testName: "resume", suspend: false, suspended: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				key    = &mockKey{kind: KindClient, name: "client"}
+				mockTx = NewMockTransaction(ctrl)
+				mockDS = NewMockClient(ctrl)
+			)
+			mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+			mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, f func(datastore.Transaction) error) (datastore.Commit, error) {
+				return nil, f(mockTx)
+			})
+			mockTx.EXPECT().Get(key, gomock.Any()).DoAndReturn(func(_ datastore.Key, dst interface{}) error {
+				dst.(*Client).Suspended = !tt.suspended
+				return nil
+			})
+			mockTx.EXPECT().Put(key, &Client{ID: "client", Suspended: tt.suspended, Version: 1}).Return(nil, nil)
+
+			cr := &ClientStorage{client: mockDS}
+			var err error
+			if tt.suspend {
+				err = cr.Suspend(context.Background(), "client")
+			} else {
+				err = cr.Resume(context.Background(), "client")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestClientStorage_Insert(t *testing.T) {
+	type (
+		returns struct {
+			getErr error
+		}
+
+		out struct {
+			version int64
+			err     error
+		}
+	)
+
+	tests := []struct {
+		testName string
+		returns  returns
+		out      out
+	}{
+		{
+			testName: "insert",
+			returns:  returns{getErr: datastore.ErrNoSuchEntity},
+			out:      out{version: 1, err: nil},
+		},
+		{
+			testName: "already exists",
+			returns:  returns{getErr: nil},
+			out:      out{err: ErrClientAlreadyExists},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				key    = &mockKey{kind: KindClient, name: "client"}
+				mockTx = NewMockTransaction(ctrl)
+				mockDS = NewMockClient(ctrl)
+			)
+			mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+			mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, f func(datastore.Transaction) error) (datastore.Commit, error) {
+				return nil, f(mockTx)
+			})
+			mockTx.EXPECT().Get(key, gomock.Any()).Return(tt.returns.getErr)
+			if tt.out.err == nil {
+				mockTx.EXPECT().Put(key, &Client{ID: "client", Secret: "secret", Version: 1}).Return(nil, nil)
+			}
+
+			cr := &ClientStorage{client: mockDS}
+			client := &Client{ID: "client", Secret: "secret"}
+			if err := cr.Insert(context.Background(), client); err != tt.out.err {
+				t.Errorf("return error\nwant: %#v\n got: %#v", tt.out.err, err)
+			}
+			if client.Version != tt.out.version {
+				t.Errorf("version\nwant: %v\n got: %v", tt.out.version, client.Version)
+			}
+		})
+	}
+}
+
+func TestClientStorage_Update(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		key    = &mockKey{kind: KindClient, name: "client"}
+		mockTx = NewMockTransaction(ctrl)
+		mockDS = NewMockClient(ctrl)
+	)
+	mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(key)
+	mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, f func(datastore.Transaction) error) (datastore.Commit, error) {
+		return nil, f(mockTx)
+	})
+	mockTx.EXPECT().Get(key, gomock.Any()).DoAndReturn(func(_ datastore.Key, dst interface{}) error {
+		*dst.(*Client) = Client{Secret: "secret", RedirectUri: "redirect", Version: 3}
+		return nil
+	})
+	mockTx.EXPECT().Put(key, &Client{ID: "client", Secret: "secret", RedirectUri: "new_redirect", Version: 4}).Return(nil, nil)
+
+	cr := &ClientStorage{client: mockDS}
+	err := cr.Update(context.Background(), "client", func(c *Client) error {
+		c.ID = "changed"
+		c.RedirectUri = "new_redirect"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientStorage_Update_Conflict(t *testing.T) {
+	type (
+		returns struct {
+			txErr error
+		}
+
+		out struct {
+			err error
+		}
+	)
+
+	tests := []struct {
+		testName string
+		returns  returns
+		out      out
+	}{
+		{
+			testName: "concurrent transaction",
+			returns:  returns{txErr: datastore.ErrConcurrentTransaction},
+			out:      out{err: ErrConflict},
+		},
+		{
+			testName: "not found",
+			returns:  returns{txErr: datastore.ErrNoSuchEntity},
+			out:      out{err: datastore.ErrNoSuchEntity},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockDS := NewMockClient(ctrl)
+			mockDS.EXPECT().NameKey(KindClient, "client", gomock.Nil()).Return(&mockKey{kind: KindClient, name: "client"})
+			mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).Return(nil, tt.returns.txErr)
+
+			cr := &ClientStorage{client: mockDS}
+			err := cr.Update(context.Background(), "client", func(c *Client) error { return nil })
+			if err != tt.out.err {
+				t.Errorf("return error\nwant: %#v\n got: %#v", tt.out.err, err)
+			}
+		})
+	}
+}
+
+func TestClientStorage_GetMultiPartial(t *testing.T) {
+	type (
+		in struct {
+			ids []string
+		}
+
+		returns struct {
+			clients []*Client
+			err     error
+		}
+
+		out struct {
+			clients []*Client
+			err     error
+		}
+	)
+
+	errRPC := errors.New("rpc error")
+
+	tests := []struct {
+		testName string
+		in       in
+		returns  returns
+		out      out
+	}{
+		{
+			testName: "all found",
+			in:       in{ids: []string{"id1", "id2"}},
+			returns: returns{
+				clients: []*Client{{Secret: "secret1"}, {Secret: "secret2"}},
+			},
+			out: out{
+				clients: []*Client{{ID: "id1", Secret: "secret1"}, {ID: "id2", Secret: "secret2"}},
+			},
+		},
+		{
+			testName: "partially found",
+			in:       in{ids: []string{"id1", "id2", "id3"}},
+			returns: returns{
+				clients: []*Client{{Secret: "secret1"}, {}, nil},
+				err:     datastore.MultiError{nil, datastore.ErrNoSuchEntity, errRPC},
+			},
+			out: out{
+				clients: []*Client{{ID: "id1", Secret: "secret1"}, nil, nil},
+				err:     datastore.MultiError{nil, osin.ErrNotFound, errRPC},
+			},
+		},
+		{
+			testName: "rpc error",
+			in:       in{ids: []string{"id1"}},
+			returns: returns{
+				err: errRPC,
+			},
+			out: out{
+				err: errRPC,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockDatastoreClient := NewMockClient(ctrl)
+			for _, id := range tt.in.ids {
+				mockDatastoreClient.EXPECT().NameKey(KindClient, id, gomock.Nil()).Return(&mockKey{kind: KindClient, name: id})
+			}
+			mockDatastoreClient.EXPECT().GetMulti(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ []datastore.Key, dst interface{}) error {
+				dval := reflect.ValueOf(dst)
+				for i := range tt.returns.clients {
+					dval.Index(i).Set(reflect.ValueOf(tt.returns.clients[i]))
+				}
+				return tt.returns.err
+			})
+
+			cr := &ClientStorage{client: mockDatastoreClient}
+			gots, err := cr.GetMultiPartial(context.Background(), tt.in.ids)
+			if !reflect.DeepEqual(tt.out.err, err) {
+				t.Errorf("return error\nwant: %#v\n got: %#v", tt.out.err, err)
+			}
+			if !reflect.DeepEqual(tt.out.clients, gots) {
+				t.Errorf("client\nwant %+v\n got %+v", tt.out.clients, gots)
+			}
+		})
+	}
+}
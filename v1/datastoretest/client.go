@@ -0,0 +1,404 @@
+package datastoretest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// errUnsupported is returned by features which the fake client does not support.
+var errUnsupported = errors.New("datastoretest: unsupported feature")
+
+// entry is an entity stored in Client.
+type entry struct {
+	key   *key
+	props []datastore.Property
+}
+
+// Client is in-memory datastore.Client for tests.
+// Entities are saved as properties with datastore.SaveStruct or datastore.PropertyLoadSaver, and loaded the same way,
+// so that struct tags and noindex options work as Datastore does.
+// Queries filter and order entities by indexed properties, and transactions fail with datastore.ErrConcurrentTransaction on conflict.
+// Queries are strongly consistent. Batch and middlewares are not supported, and their methods panic
+// rather than silently skipping the operations.
+// Client is safe for concurrent use.
+type Client struct {
+	mu       sync.Mutex
+	entities map[string]*entry
+	// written holds seq when each key was written or deleted last, which transactions use to detect conflicts.
+	written map[string]int64
+	seq     int64
+	nextID  int64
+	ctx     context.Context
+}
+
+var _ datastore.Client = (*Client)(nil)
+
+// NewClient creates empty Client.
+func NewClient() *Client {
+	return &Client{
+		entities: make(map[string]*entry),
+		written:  make(map[string]int64),
+		ctx:      context.Background(),
+	}
+}
+
+// allocateID returns complete key of k with new ID if k is incomplete.
+func (c *Client) allocateID(k *key) *key {
+	if !k.Incomplete() {
+		return k
+	}
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.mu.Unlock()
+
+	allocated := *k
+	allocated.id = id
+	return &allocated
+}
+
+// lookup returns entity of k or nil. It must be called with c.mu locked.
+func (c *Client) lookup(k *key) *entry {
+	return c.entities[k.mapKey()]
+}
+
+// write stores or deletes (for nil props) entity of k. It must be called with c.mu locked.
+func (c *Client) write(k *key, props []datastore.Property) {
+	mk := k.mapKey()
+	if props == nil {
+		delete(c.entities, mk)
+	} else {
+		c.entities[mk] = &entry{key: k, props: props}
+	}
+	c.written[mk] = c.seq
+}
+
+func (c *Client) get(ctx context.Context, k datastore.Key, dst interface{}) error {
+	kk := toKey(k)
+	if kk == nil || !kk.valid() {
+		return datastore.ErrInvalidKey
+	}
+	c.mu.Lock()
+	e := c.lookup(kk)
+	c.mu.Unlock()
+	if e == nil {
+		return datastore.ErrNoSuchEntity
+	}
+	return loadEntity(ctx, dst, e.key, e.props)
+}
+
+// Get loads entity of key to dst.
+func (c *Client) Get(ctx context.Context, key datastore.Key, dst interface{}) error {
+	return c.get(ctx, key, dst)
+}
+
+// GetMulti loads entities of keys to dst, which must be slice of the same length as keys.
+// Errors of each key are returned as datastore.MultiError.
+func (c *Client) GetMulti(ctx context.Context, keys []datastore.Key, dst interface{}) error {
+	return multi(keys, dst, true, func(i int, elem interface{}) error {
+		return c.get(ctx, keys[i], elem)
+	})
+}
+
+// putEntity converts src to properties, and returns the key to store them with.
+func (c *Client) putEntity(ctx context.Context, k datastore.Key, src interface{}) (*key, []datastore.Property, error) {
+	kk := toKey(k)
+	if kk == nil || kk.kind == "" || (kk.parent != nil && !kk.parent.valid()) {
+		return nil, nil, datastore.ErrInvalidKey
+	}
+	props, err := saveEntity(ctx, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	if props == nil {
+		props = []datastore.Property{}
+	}
+	return c.allocateID(kk), props, nil
+}
+
+// Put stores src with key. ID is allocated for incomplete key, and the complete key is returned.
+func (c *Client) Put(ctx context.Context, key datastore.Key, src interface{}) (datastore.Key, error) {
+	k, props, err := c.putEntity(ctx, key, src)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	c.write(k, props)
+	return k, nil
+}
+
+// PutMulti stores src, which must be slice of the same length as keys.
+// Either all or none of entities are stored, and errors of each key are returned as datastore.MultiError.
+func (c *Client) PutMulti(ctx context.Context, keys []datastore.Key, src interface{}) ([]datastore.Key, error) {
+	stored := make([]*key, len(keys))
+	props := make([][]datastore.Property, len(keys))
+	err := multi(keys, src, false, func(i int, elem interface{}) error {
+		var err error
+		stored[i], props[i], err = c.putEntity(ctx, keys[i], elem)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	result := make([]datastore.Key, len(keys))
+	for i, k := range stored {
+		c.write(k, props[i])
+		result[i] = k
+	}
+	return result, nil
+}
+
+// Delete removes entity of key. Deleting entity which does not exist is not an error.
+func (c *Client) Delete(ctx context.Context, key datastore.Key) error {
+	return c.DeleteMulti(ctx, []datastore.Key{key})
+}
+
+// DeleteMulti removes entities of keys.
+func (c *Client) DeleteMulti(ctx context.Context, keys []datastore.Key) error {
+	deleted := make([]*key, len(keys))
+	for i, k := range keys {
+		deleted[i] = toKey(k)
+		if deleted[i] == nil || !deleted[i].valid() {
+			return datastore.ErrInvalidKey
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seq++
+	for _, k := range deleted {
+		c.write(k, nil)
+	}
+	return nil
+}
+
+// NewTransaction starts a transaction.
+func (c *Client) NewTransaction(ctx context.Context) (datastore.Transaction, error) {
+	return c.newTransaction(ctx), nil
+}
+
+// RunInTransaction runs f in a transaction, and retries it up to 3 times on conflict as Datastore client does.
+// If f returns error, the transaction is rolled back and the error is returned.
+func (c *Client) RunInTransaction(ctx context.Context, f func(tx datastore.Transaction) error) (datastore.Commit, error) {
+	for i := 0; i < 3; i++ {
+		tx := c.newTransaction(ctx)
+		if err := f(tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		commit, err := tx.Commit()
+		if err == datastore.ErrConcurrentTransaction {
+			continue
+		}
+		return commit, err
+	}
+	return nil, datastore.ErrConcurrentTransaction
+}
+
+// Run runs q, which must be created by NewQuery of Client.
+func (c *Client) Run(ctx context.Context, q datastore.Query) datastore.Iterator {
+	qq, ok := q.(*query)
+	if !ok {
+		return &queryIterator{err: fmt.Errorf("datastoretest: query %T is not created by Client", q)}
+	}
+	return c.run(ctx, qq)
+}
+
+// AllocateIDs returns complete keys of keys, where IDs are allocated for incomplete ones.
+func (c *Client) AllocateIDs(ctx context.Context, keys []datastore.Key) ([]datastore.Key, error) {
+	allocated := make([]datastore.Key, len(keys))
+	for i, k := range keys {
+		kk := toKey(k)
+		if kk == nil || kk.kind == "" {
+			return nil, datastore.ErrInvalidKey
+		}
+		allocated[i] = c.allocateID(kk)
+	}
+	return allocated, nil
+}
+
+// Count returns number of entities which q matches.
+func (c *Client) Count(ctx context.Context, q datastore.Query) (int, error) {
+	it := c.Run(ctx, q.KeysOnly())
+	n := 0
+	for {
+		_, err := it.Next(nil)
+		if err == iterator.Done {
+			return n, nil
+		} else if err != nil {
+			return 0, err
+		}
+		n++
+	}
+}
+
+// GetAll runs q, and appends entities to dst which must be pointer to slice.
+// dst is ignored for keys only query, and may be nil.
+func (c *Client) GetAll(ctx context.Context, q datastore.Query, dst interface{}) ([]datastore.Key, error) {
+	qq, ok := q.(*query)
+	if !ok {
+		return nil, fmt.Errorf("datastoretest: query %T is not created by Client", q)
+	}
+
+	var sv reflect.Value
+	if !qq.keysOnly {
+		sv = reflect.ValueOf(dst)
+		if sv.Kind() != reflect.Ptr || sv.Elem().Kind() != reflect.Slice {
+			return nil, datastore.ErrInvalidEntityType
+		}
+		sv = sv.Elem()
+	}
+
+	var keys []datastore.Key
+	it := c.run(ctx, qq)
+	for {
+		if qq.keysOnly {
+			k, err := it.Next(nil)
+			if err == iterator.Done {
+				return keys, nil
+			} else if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+			continue
+		}
+
+		dst, appendTo, err := newElem(sv.Type())
+		if err != nil {
+			return nil, err
+		}
+		k, err := it.Next(dst)
+		if err == iterator.Done {
+			return keys, nil
+		} else if err != nil {
+			return nil, err
+		}
+		sv.Set(appendTo(sv))
+		keys = append(keys, k)
+	}
+}
+
+// IncompleteKey creates incomplete key, which namespace is inherited from parent.
+func (c *Client) IncompleteKey(kind string, parent datastore.Key) datastore.Key {
+	return newKey(kind, 0, "", parent)
+}
+
+// NameKey creates key with name, which namespace is inherited from parent.
+func (c *Client) NameKey(kind, name string, parent datastore.Key) datastore.Key {
+	return newKey(kind, 0, name, parent)
+}
+
+// IDKey creates key with ID, which namespace is inherited from parent.
+func (c *Client) IDKey(kind string, id int64, parent datastore.Key) datastore.Key {
+	return newKey(kind, id, "", parent)
+}
+
+func newKey(kind string, id int64, name string, parent datastore.Key) *key {
+	k := &key{kind: kind, id: id, name: name, parent: toKey(parent)}
+	if k.parent != nil {
+		k.namespace = k.parent.namespace
+	}
+	return k
+}
+
+// NewQuery creates query of kind.
+func (c *Client) NewQuery(kind string) datastore.Query {
+	return &query{kind: kind, limit: -1, start: -1, end: -1}
+}
+
+// Close does nothing. Entities are kept, so that Client can be used after it is closed.
+func (c *Client) Close() error {
+	return nil
+}
+
+// DecodeKey decodes key encoded by Encode of the key of Client.
+func (c *Client) DecodeKey(encoded string) (datastore.Key, error) {
+	k, err := decodeKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// DecodeCursor decodes cursor returned by Cursor of the iterator of Client.
+func (c *Client) DecodeCursor(s string) (datastore.Cursor, error) {
+	pos, err := strconv.Atoi(s)
+	if err != nil || pos < 0 {
+		return nil, fmt.Errorf("datastoretest: invalid cursor %q", s)
+	}
+	return cursor(pos), nil
+}
+
+// Batch is not supported, and panics.
+// datastore.Batch executes operations with unexported fields, so the fake client cannot return a batch which fails on Exec.
+func (c *Client) Batch() *datastore.Batch {
+	panic(fmt.Errorf("%w: Batch of Client", errUnsupported))
+}
+
+// AppendMiddleware is not supported, and panics.
+func (c *Client) AppendMiddleware(middleware datastore.Middleware) {
+	panic(fmt.Errorf("%w: middleware of Client", errUnsupported))
+}
+
+// RemoveMiddleware always returns false, since middleware can not be appended.
+func (c *Client) RemoveMiddleware(middleware datastore.Middleware) bool {
+	return false
+}
+
+// Context returns context set by SetContext.
+func (c *Client) Context() context.Context {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ctx
+}
+
+// SetContext sets context returned by Context.
+func (c *Client) SetContext(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+}
+
+// multi calls f for each element of slice, which must be the same length as keys, and aggregates errors into datastore.MultiError.
+// Nil pointer elements are allocated if alloc is true, and reset to nil on error.
+func multi(keys []datastore.Key, slice interface{}, alloc bool, f func(i int, elem interface{}) error) error {
+	sv := reflect.ValueOf(slice)
+	if sv.Kind() != reflect.Slice || sv.Len() != len(keys) {
+		return datastore.ErrInvalidEntityType
+	}
+
+	var errs datastore.MultiError
+	for i := range keys {
+		wasNil := sv.Index(i).Kind() == reflect.Ptr && sv.Index(i).IsNil()
+		elem, err := sliceElem(sv, i, alloc)
+		if err == nil {
+			err = f(i, elem)
+		}
+		if err != nil {
+			if wasNil {
+				sv.Index(i).Set(reflect.Zero(sv.Index(i).Type()))
+			}
+			if errs == nil {
+				errs = make(datastore.MultiError, len(keys))
+			}
+			errs[i] = err
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package datastoretest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mercari.io/datastore"
+)
+
+type testEntity struct {
+	Name    string
+	Count   int
+	Tags    []string
+	Secret  string `datastore:",noindex"`
+	Created time.Time
+	Ignored string `datastore:"-"`
+}
+
+func TestClient_PutGet(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, 1, 2, 3, 4, 5, 123456789, time.UTC)
+
+	tests := []struct {
+		testName string
+		in       *testEntity
+		out      *testEntity
+	}{
+		{
+			testName: "truncate time to microseconds",
+			in:       &testEntity{Name: "name", Count: 1, Tags: []string{"a", "b"}, Secret: "secret", Created: now, Ignored: "ignored"},
+			out:      &testEntity{Name: "name", Count: 1, Tags: []string{"a", "b"}, Secret: "secret", Created: now.Truncate(time.Microsecond)},
+		},
+		{
+			testName: "zero value",
+			in:       &testEntity{},
+			out:      &testEntity{Created: time.Unix(time.Time{}.Unix(), 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			client := NewClient()
+			k, err := client.Put(ctx, client.NameKey("Test", "name", nil), tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := new(testEntity)
+			if err := client.Get(ctx, k, got); err != nil {
+				t.Fatal(err)
+			}
+			if !got.Created.Equal(tt.out.Created) {
+				t.Errorf("Created\nwant: %v\n got: %v", tt.out.Created, got.Created)
+			}
+			got.Created, tt.out.Created = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(tt.out, got) {
+				t.Errorf("\nwant: %#v\n got: %#v", tt.out, got)
+			}
+		})
+	}
+}
+
+func TestClient_Put_IncompleteKey(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+
+	k1, err := client.Put(ctx, client.IncompleteKey("Test", nil), &testEntity{Name: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := client.Put(ctx, client.IncompleteKey("Test", nil), &testEntity{Name: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1.Incomplete() || k2.Incomplete() || k1.Equal(k2) {
+		t.Errorf("keys must be complete and unique: %v, %v", k1, k2)
+	}
+
+	got := new(testEntity)
+	if err := client.Get(ctx, client.IDKey("Test", k2.ID(), nil), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "2" {
+		t.Errorf("\nwant: %#v\n got: %#v", "2", got.Name)
+	}
+}
+
+func TestClient_Get_Errors(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	if _, err := client.Put(ctx, client.NameKey("Test", "name", nil), &testEntity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		testName string
+		in       datastore.Key
+		out      error
+	}{
+		{testName: "not found", in: client.NameKey("Test", "other", nil), out: datastore.ErrNoSuchEntity},
+		{testName: "other kind", in: client.NameKey("Other", "name", nil), out: datastore.ErrNoSuchEntity},
+		{testName: "incomplete key", in: client.IncompleteKey("Test", nil), out: datastore.ErrInvalidKey},
+		{testName: "incomplete parent", in: client.NameKey("Test", "name", client.IncompleteKey("Parent", nil)), out: datastore.ErrInvalidKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if err := client.Get(ctx, tt.in, new(testEntity)); err != tt.out {
+				t.Errorf("\nwant: %#v\n got: %#v", tt.out, err)
+			}
+		})
+	}
+}
+
+func TestClient_Namespace(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+
+	k := client.NameKey("Test", "name", nil)
+	k.SetNamespace("tenant")
+	if _, err := client.Put(ctx, k, &testEntity{Name: "tenant"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Get(ctx, client.NameKey("Test", "name", nil), new(testEntity)); err != datastore.ErrNoSuchEntity {
+		t.Errorf("entity in default namespace\nwant: %#v\n got: %#v", datastore.ErrNoSuchEntity, err)
+	}
+	child := client.NameKey("Child", "name", k)
+	if child.Namespace() != "tenant" {
+		t.Errorf("namespace of child key\nwant: %#v\n got: %#v", "tenant", child.Namespace())
+	}
+	got := new(testEntity)
+	if err := client.Get(ctx, k, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "tenant" {
+		t.Errorf("\nwant: %#v\n got: %#v", "tenant", got.Name)
+	}
+}
+
+func TestClient_GetMulti(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	keys := []datastore.Key{
+		client.NameKey("Test", "1", nil),
+		client.NameKey("Test", "2", nil),
+		client.NameKey("Test", "3", nil),
+	}
+	if _, err := client.PutMulti(ctx, []datastore.Key{keys[0], keys[2]}, []*testEntity{{Name: "1"}, {Name: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make([]*testEntity, len(keys))
+	err := client.GetMulti(ctx, keys, got)
+	want := datastore.MultiError{nil, datastore.ErrNoSuchEntity, nil}
+	if !reflect.DeepEqual(want, err) {
+		t.Errorf("return error\nwant: %#v\n got: %#v", want, err)
+	}
+	if got[0] == nil || got[0].Name != "1" || got[1] != nil || got[2] == nil || got[2].Name != "3" {
+		t.Errorf("unexpected entities: %#v", got)
+	}
+}
+
+func TestClient_Delete(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	k := client.NameKey("Test", "name", nil)
+	if _, err := client.Put(ctx, k, &testEntity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Delete(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(ctx, k, new(testEntity)); err != datastore.ErrNoSuchEntity {
+		t.Errorf("\nwant: %#v\n got: %#v", datastore.ErrNoSuchEntity, err)
+	}
+	if err := client.Delete(ctx, k); err != nil {
+		t.Errorf("delete of missing entity: %v", err)
+	}
+}
+
+func TestClient_StoredEntityIsCopied(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	k := client.NameKey("Test", "name", nil)
+	in := &testEntity{Tags: []string{"a"}}
+	if _, err := client.Put(ctx, k, in); err != nil {
+		t.Fatal(err)
+	}
+	in.Tags[0] = "changed"
+
+	got := new(testEntity)
+	if err := client.Get(ctx, k, got); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(want, got.Tags) {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got.Tags)
+	}
+}
+
+func TestClient_DecodeKey(t *testing.T) {
+	client := NewClient()
+	k := client.IDKey("Child", 1, client.NameKey("Parent", "name", nil))
+	k.SetNamespace("tenant")
+
+	got, err := client.DecodeKey(k.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k.Equal(got) {
+		t.Errorf("\nwant: %v\n got: %v", k, got)
+	}
+	if want := "/Parent,name/Child,1"; got.String() != want {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got.String())
+	}
+}
+
+func TestClient_Unsupported(t *testing.T) {
+	client := NewClient()
+
+	tests := []struct {
+		testName string
+		call     func()
+	}{
+		{testName: "batch", call: func() { client.Batch() }},
+		{testName: "middleware", call: func() { client.AppendMiddleware(nil) }},
+		{
+			testName: "transaction batch",
+			call: func() {
+				tx, err := client.NewTransaction(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer tx.Rollback()
+				tx.Batch()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, errUnsupported) {
+					t.Errorf("panic\nwant: %#v\n got: %#v", errUnsupported, err)
+				}
+			}()
+			tt.call()
+		})
+	}
+}
@@ -0,0 +1,24 @@
+// Package datastoretest provides in-memory fake of Datastore client to test code using osin-datastore without Datastore or its emulator.
+//
+// Client implements datastore.Client of go.mercari.io/datastore with keys, namespaces, queries on indexed properties and transactions.
+// NewStorage and NewClientStorage create storages of osin-datastore backed by a new Client.
+//
+//	storage := datastoretest.NewStorage(ctx, osindatastore.WithDenormalizedTokens())
+//	server := osin.NewServer(osin.NewServerConfig(), storage)
+package datastoretest
+
+import (
+	"context"
+
+	osindatastore "github.com/ryutah/osin-datastore/v1"
+)
+
+// NewStorage creates Storage backed by a new Client.
+func NewStorage(ctx context.Context, opts ...osindatastore.StorageOption) *osindatastore.Storage {
+	return osindatastore.NewStorageWithClient(ctx, NewClient(), opts...)
+}
+
+// NewClientStorage creates ClientStorage backed by a new Client.
+func NewClientStorage() *osindatastore.ClientStorage {
+	return osindatastore.NewClientStorageWithClient(NewClient())
+}
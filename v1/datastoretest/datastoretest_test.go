@@ -0,0 +1,98 @@
+package datastoretest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+
+	osindatastore "github.com/ryutah/osin-datastore/v1"
+	"go.mercari.io/datastore"
+)
+
+func TestNewStorage(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		testName string
+		in       []osindatastore.StorageOption
+	}{
+		{testName: "default"},
+		{testName: "denormalized", in: []osindatastore.StorageOption{osindatastore.WithDenormalizedTokens()}},
+		{testName: "outbox", in: []osindatastore.StorageOption{osindatastore.WithOutbox()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			storage := NewStorage(ctx, tt.in...)
+			defer storage.Close()
+
+			client := &osindatastore.Client{ID: "client", Secret: "secret", RedirectUri: "http://localhost"}
+			if err := storage.ClientStorage().Put(ctx, client); err != nil {
+				t.Fatal(err)
+			}
+			gotClient, err := storage.GetClient("client")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotClient.GetId() != "client" || gotClient.GetRedirectUri() != client.RedirectUri {
+				t.Errorf("\nwant: %#v\n got: %#v", client, gotClient)
+			}
+
+			access := &osin.AccessData{
+				Client:       client,
+				AccessToken:  "token",
+				RefreshToken: "refresh",
+				ExpiresIn:    3600,
+				Scope:        "read",
+				RedirectUri:  client.RedirectUri,
+				CreatedAt:    time.Now(),
+				UserData:     "user",
+			}
+			if err := storage.SaveAccess(access); err != nil {
+				t.Fatal(err)
+			}
+			gotAccess, err := storage.LoadAccess("token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotAccess.RefreshToken != "refresh" || gotAccess.Client.GetId() != "client" || gotAccess.UserData != "user" {
+				t.Errorf("\nwant: %#v\n got: %#v", access, gotAccess)
+			}
+			gotRefresh, err := storage.LoadRefresh("refresh")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotRefresh.AccessToken != "token" {
+				t.Errorf("access token of refresh\nwant: %#v\n got: %#v", "token", gotRefresh.AccessToken)
+			}
+
+			if err := storage.RemoveAccess("token"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := storage.LoadAccess("token"); err == nil {
+				t.Error("LoadAccess must fail after RemoveAccess")
+			}
+		})
+	}
+}
+
+func TestNewClientStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := NewClientStorage()
+
+	if err := storage.Put(ctx, &osindatastore.Client{ID: "client"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := storage.Get(ctx, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetId() != "client" {
+		t.Errorf("\nwant: %#v\n got: %#v", "client", got.GetId())
+	}
+	if _, err := storage.Get(ctx, "other"); err != datastore.ErrNoSuchEntity {
+		t.Errorf("\nwant: %#v\n got: %#v", datastore.ErrNoSuchEntity, err)
+	}
+}
@@ -0,0 +1,219 @@
+package datastoretest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mercari.io/datastore"
+)
+
+// saveEntity converts src to properties as Datastore client does.
+func saveEntity(ctx context.Context, src interface{}) ([]datastore.Property, error) {
+	var (
+		ps  []datastore.Property
+		err error
+	)
+	if pls, ok := src.(datastore.PropertyLoadSaver); ok {
+		ps, err = pls.Save(ctx)
+	} else {
+		ps, err = datastore.SaveStruct(ctx, src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return copyProperties(ps), nil
+}
+
+// loadEntity sets properties and key to dst as Datastore client does.
+func loadEntity(ctx context.Context, dst interface{}, k *key, ps []datastore.Property) error {
+	ps = copyProperties(ps)
+	var err error
+	if pls, ok := dst.(datastore.PropertyLoadSaver); ok {
+		err = pls.Load(ctx, ps)
+	} else {
+		err = datastore.LoadStruct(ctx, dst, ps)
+	}
+	if err != nil {
+		return err
+	}
+	if kl, ok := dst.(datastore.KeyLoader); ok {
+		return kl.LoadKey(ctx, k)
+	}
+	return nil
+}
+
+// copyProperties deep copies ps, so that stored entities are not shared with callers.
+func copyProperties(ps []datastore.Property) []datastore.Property {
+	copied := make([]datastore.Property, len(ps))
+	for i, p := range ps {
+		copied[i] = p
+		copied[i].Value = copyValue(p.Value)
+	}
+	return copied
+}
+
+// copyValue deep copies v, and truncates time to microseconds as Datastore stores it.
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, e := range v {
+			copied[i] = copyValue(e)
+		}
+		return copied
+	case []byte:
+		return append([]byte(nil), v...)
+	case time.Time:
+		return time.Unix(v.Unix(), int64(v.Nanosecond()/1000*1000))
+	case *datastore.Entity:
+		if v == nil {
+			return v
+		}
+		return &datastore.Entity{Key: v.Key, Properties: copyProperties(v.Properties)}
+	case datastore.Key:
+		return toKey(v)
+	default:
+		return v
+	}
+}
+
+// normalizeValue converts filter value to the type which properties are stored with.
+func normalizeValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	}
+	return copyValue(v)
+}
+
+// typeRank orders values of different types as Datastore does.
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64:
+		return 1
+	case time.Time:
+		return 2
+	case bool:
+		return 3
+	case []byte:
+		return 4
+	case string:
+		return 5
+	case float64:
+		return 6
+	case *key:
+		return 7
+	default:
+		return 8
+	}
+}
+
+// compareValues compares indexed values. Values which cannot be ordered (e.g. embedded entities) compare by type only.
+func compareValues(a, b interface{}) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case int64:
+		return compareOrdered(a < b.(int64), a > b.(int64))
+	case float64:
+		return compareOrdered(a < b.(float64), a > b.(float64))
+	case time.Time:
+		return compareOrdered(a.Before(b.(time.Time)), a.After(b.(time.Time)))
+	case bool:
+		return compareOrdered(!a && b.(bool), a && !b.(bool))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	case string:
+		return strings.Compare(a, b.(string))
+	case *key:
+		return compareKeys(a, b.(*key))
+	default:
+		return 0
+	}
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// indexedValues returns values of property name which are indexed.
+// Each element of multiple valued property is indexed separately.
+func indexedValues(ps []datastore.Property, name string) []interface{} {
+	var values []interface{}
+	for _, p := range ps {
+		if p.Name != name || p.NoIndex {
+			continue
+		}
+		switch v := p.Value.(type) {
+		case []interface{}:
+			values = append(values, v...)
+		case *datastore.Entity:
+			// Embedded entities are not indexed by the fake.
+		default:
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// sliceElem returns element i of slice v as dst of load or src of save.
+// Nil pointer element is allocated if alloc is true, as dst of load.
+func sliceElem(v reflect.Value, i int, alloc bool) (interface{}, error) {
+	e := v.Index(i)
+	switch e.Kind() {
+	case reflect.Ptr:
+		if e.IsNil() && alloc {
+			e.Set(reflect.New(e.Type().Elem()))
+		}
+		if e.IsNil() {
+			return nil, fmt.Errorf("datastoretest: element %d of %v is nil", i, v.Type())
+		}
+		return e.Interface(), nil
+	case reflect.Interface:
+		if e.IsNil() {
+			return nil, fmt.Errorf("datastoretest: element %d of %v is nil", i, v.Type())
+		}
+		return e.Interface(), nil
+	case reflect.Struct:
+		return e.Addr().Interface(), nil
+	}
+	if pls, ok := e.Addr().Interface().(datastore.PropertyLoadSaver); ok {
+		return pls, nil
+	}
+	return nil, datastore.ErrInvalidEntityType
+}
+
+// newElem allocates value for element type of slice type t, and returns it as dst of load
+// with function which appends it to slice value.
+func newElem(t reflect.Type) (interface{}, func(slice reflect.Value) reflect.Value, error) {
+	et := t.Elem()
+	switch {
+	case et.Kind() == reflect.Ptr:
+		v := reflect.New(et.Elem())
+		return v.Interface(), func(slice reflect.Value) reflect.Value { return reflect.Append(slice, v) }, nil
+	case et.Kind() == reflect.Struct || reflect.PtrTo(et).Implements(typeOfPropertyLoadSaver):
+		v := reflect.New(et)
+		return v.Interface(), func(slice reflect.Value) reflect.Value { return reflect.Append(slice, v.Elem()) }, nil
+	default:
+		return nil, nil, datastore.ErrInvalidEntityType
+	}
+}
+
+var typeOfPropertyLoadSaver = reflect.TypeOf((*datastore.PropertyLoadSaver)(nil)).Elem()
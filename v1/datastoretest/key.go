@@ -0,0 +1,216 @@
+package datastoretest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"go.mercari.io/datastore"
+)
+
+// key is datastore.Key of the fake client.
+type key struct {
+	kind      string
+	id        int64
+	name      string
+	parent    *key
+	namespace string
+}
+
+var _ datastore.Key = (*key)(nil)
+
+// toKey converts k of any implementation to key. It returns nil for nil k.
+func toKey(k datastore.Key) *key {
+	if k == nil {
+		return nil
+	}
+	if k, ok := k.(*key); ok {
+		return k
+	}
+	return &key{
+		kind:      k.Kind(),
+		id:        k.ID(),
+		name:      k.Name(),
+		parent:    toKey(k.ParentKey()),
+		namespace: k.Namespace(),
+	}
+}
+
+func (k *key) Kind() string {
+	return k.kind
+}
+
+func (k *key) ID() int64 {
+	return k.id
+}
+
+func (k *key) Name() string {
+	return k.name
+}
+
+func (k *key) ParentKey() datastore.Key {
+	if k.parent == nil {
+		return nil
+	}
+	return k.parent
+}
+
+func (k *key) Namespace() string {
+	return k.namespace
+}
+
+func (k *key) SetNamespace(namespace string) {
+	k.namespace = namespace
+}
+
+// String returns path of the key such as /Parent,1/Child,name.
+func (k *key) String() string {
+	var b strings.Builder
+	for _, e := range k.path() {
+		b.WriteString("/")
+		b.WriteString(e.kind)
+		b.WriteString(",")
+		if e.name != "" {
+			b.WriteString(e.name)
+		} else {
+			b.WriteString(strconv.FormatInt(e.id, 10))
+		}
+	}
+	return b.String()
+}
+
+// path returns keys from the root ancestor to k.
+func (k *key) path() []*key {
+	var path []*key
+	for e := k; e != nil; e = e.parent {
+		path = append([]*key{e}, path...)
+	}
+	return path
+}
+
+// mapKey identifies the entity of k in the fake client.
+func (k *key) mapKey() string {
+	return k.namespace + "\x00" + k.String()
+}
+
+// valid reports whether k is complete key which can be read and written.
+func (k *key) valid() bool {
+	for e := k; e != nil; e = e.parent {
+		if e.kind == "" || (e != k && e.Incomplete()) {
+			return false
+		}
+	}
+	return !k.Incomplete()
+}
+
+func (k *key) Equal(o datastore.Key) bool {
+	ok := toKey(o)
+	if k == nil || ok == nil {
+		return k == nil && ok == nil
+	}
+	return k.kind == ok.kind && k.id == ok.id && k.name == ok.name && k.namespace == ok.namespace && k.parent.Equal(ok.ParentKey())
+}
+
+func (k *key) Incomplete() bool {
+	return k.id == 0 && k.name == ""
+}
+
+// encodedKey is JSON form of key.
+type encodedKey struct {
+	Kind      string      `json:"kind"`
+	ID        int64       `json:"id,omitempty"`
+	Name      string      `json:"name,omitempty"`
+	Parent    *encodedKey `json:"parent,omitempty"`
+	Namespace string      `json:"namespace,omitempty"`
+}
+
+func (k *key) encoded() *encodedKey {
+	if k == nil {
+		return nil
+	}
+	return &encodedKey{Kind: k.kind, ID: k.id, Name: k.name, Parent: k.parent.encoded(), Namespace: k.namespace}
+}
+
+func (e *encodedKey) decoded() *key {
+	if e == nil {
+		return nil
+	}
+	return &key{kind: e.Kind, id: e.ID, name: e.Name, parent: e.Parent.decoded(), namespace: e.Namespace}
+}
+
+func (k *key) MarshalJSON() ([]byte, error) {
+	return json.Marshal(k.encoded())
+}
+
+func (k *key) UnmarshalJSON(buf []byte) error {
+	e := new(encodedKey)
+	if err := json.Unmarshal(buf, e); err != nil {
+		return err
+	}
+	*k = *e.decoded()
+	return nil
+}
+
+func (k *key) GobEncode() ([]byte, error) {
+	return k.MarshalJSON()
+}
+
+func (k *key) GobDecode(buf []byte) error {
+	return k.UnmarshalJSON(buf)
+}
+
+// Encode returns opaque string of the key, which DecodeKey of the fake client decodes.
+func (k *key) Encode() string {
+	buf, _ := k.MarshalJSON()
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeKey(encoded string) (*key, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	k := new(key)
+	if err := k.UnmarshalJSON(buf); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// compareKeys orders keys by their path, where IDs come before names as Datastore does.
+func compareKeys(a, b *key) int {
+	pa, pb := a.path(), b.path()
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		x, y := pa[i], pb[i]
+		if c := strings.Compare(x.kind, y.kind); c != 0 {
+			return c
+		}
+		switch {
+		case x.name == "" && y.name != "":
+			return -1
+		case x.name != "" && y.name == "":
+			return 1
+		case x.name != "":
+			if c := strings.Compare(x.name, y.name); c != 0 {
+				return c
+			}
+		case x.id != y.id:
+			if x.id < y.id {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+// hasAncestor reports whether ancestor is k itself or its ancestor.
+func (k *key) hasAncestor(ancestor *key) bool {
+	for e := k; e != nil; e = e.parent {
+		if e.Equal(ancestor) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,366 @@
+package datastoretest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// keyProperty is the special property name to filter and order by key.
+const keyProperty = "__key__"
+
+// filter is a condition of query, such as "Prop >=".
+type filter struct {
+	property string
+	op       string
+	value    interface{}
+}
+
+// operators of filter. Two characters operators come first to parse them by suffix.
+var operators = []string{"<=", ">=", "=", "<", ">"}
+
+func (f filter) match(v interface{}) bool {
+	if typeRank(v) != typeRank(f.value) {
+		return false
+	}
+	c := compareValues(v, f.value)
+	switch f.op {
+	case "=":
+		return c == 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	default:
+		return c >= 0
+	}
+}
+
+type order struct {
+	property   string
+	descending bool
+}
+
+// query is datastore.Query of Client. Each method returns modified copy of the query.
+// Projection and distinct queries are not supported, and running them returns error.
+type query struct {
+	kind      string
+	ancestor  *key
+	namespace string
+	filters   []filter
+	orders    []order
+	keysOnly  bool
+	limit     int
+	offset    int
+	start     int
+	end       int
+	tx        *transaction
+	err       error
+}
+
+var _ datastore.Query = (*query)(nil)
+
+func (q *query) clone() *query {
+	cloned := *q
+	cloned.filters = append([]filter(nil), q.filters...)
+	cloned.orders = append([]order(nil), q.orders...)
+	return &cloned
+}
+
+func (q *query) withError(err error) *query {
+	cloned := q.clone()
+	if cloned.err == nil {
+		cloned.err = err
+	}
+	return cloned
+}
+
+func (q *query) Ancestor(ancestor datastore.Key) datastore.Query {
+	cloned := q.clone()
+	cloned.ancestor = toKey(ancestor)
+	return cloned
+}
+
+// EventualConsistency does nothing, since queries of the fake client are always strongly consistent.
+func (q *query) EventualConsistency() datastore.Query {
+	return q.clone()
+}
+
+func (q *query) Namespace(ns string) datastore.Query {
+	cloned := q.clone()
+	cloned.namespace = ns
+	return cloned
+}
+
+// Transaction makes the query run in t, so that the transaction conflicts with writes to the entities it returns.
+func (q *query) Transaction(t datastore.Transaction) datastore.Query {
+	tx, ok := t.(*transaction)
+	if !ok {
+		return q.withError(fmt.Errorf("datastoretest: transaction %T is not created by Client", t))
+	}
+	cloned := q.clone()
+	cloned.tx = tx
+	return cloned
+}
+
+func (q *query) Filter(filterStr string, value interface{}) datastore.Query {
+	filterStr = strings.TrimSpace(filterStr)
+	for _, op := range operators {
+		if !strings.HasSuffix(filterStr, op) {
+			continue
+		}
+		property := strings.TrimSpace(strings.TrimSuffix(filterStr, op))
+		if property == "" || strings.ContainsAny(property, "!<>= ") {
+			break
+		}
+		f := filter{property: property, op: op, value: normalizeValue(value)}
+		if property == keyProperty {
+			k, ok := value.(datastore.Key)
+			if !ok {
+				return q.withError(fmt.Errorf("datastoretest: filter of %s requires key, got %T", keyProperty, value))
+			}
+			f.value = toKey(k)
+		}
+		cloned := q.clone()
+		cloned.filters = append(cloned.filters, f)
+		return cloned
+	}
+	return q.withError(fmt.Errorf("datastoretest: invalid filter %q", filterStr))
+}
+
+// Order appends order by fieldName, which is descending if it is prefixed with "-".
+func (q *query) Order(fieldName string) datastore.Query {
+	fieldName = strings.TrimSpace(fieldName)
+	o := order{property: fieldName}
+	if strings.HasPrefix(fieldName, "-") {
+		o = order{property: strings.TrimSpace(fieldName[1:]), descending: true}
+	}
+	if o.property == "" {
+		return q.withError(fmt.Errorf("datastoretest: invalid order %q", fieldName))
+	}
+	cloned := q.clone()
+	cloned.orders = append(cloned.orders, o)
+	return cloned
+}
+
+// Project is not supported.
+func (q *query) Project(fieldNames ...string) datastore.Query {
+	return q.withError(errUnsupported)
+}
+
+// Distinct is not supported.
+func (q *query) Distinct() datastore.Query {
+	return q.withError(errUnsupported)
+}
+
+// DistinctOn is not supported.
+func (q *query) DistinctOn(fieldNames ...string) datastore.Query {
+	return q.withError(errUnsupported)
+}
+
+func (q *query) KeysOnly() datastore.Query {
+	cloned := q.clone()
+	cloned.keysOnly = true
+	return cloned
+}
+
+// Limit limits number of results. Negative limit means unlimited.
+func (q *query) Limit(limit int) datastore.Query {
+	cloned := q.clone()
+	cloned.limit = limit
+	return cloned
+}
+
+func (q *query) Offset(offset int) datastore.Query {
+	if offset < 0 {
+		return q.withError(fmt.Errorf("datastoretest: negative offset %d", offset))
+	}
+	cloned := q.clone()
+	cloned.offset = offset
+	return cloned
+}
+
+func (q *query) Start(c datastore.Cursor) datastore.Query {
+	pos, ok := c.(cursor)
+	if !ok {
+		return q.withError(fmt.Errorf("datastoretest: cursor %T is not created by Client", c))
+	}
+	cloned := q.clone()
+	cloned.start = int(pos)
+	return cloned
+}
+
+func (q *query) End(c datastore.Cursor) datastore.Query {
+	pos, ok := c.(cursor)
+	if !ok {
+		return q.withError(fmt.Errorf("datastoretest: cursor %T is not created by Client", c))
+	}
+	cloned := q.clone()
+	cloned.end = int(pos)
+	return cloned
+}
+
+// Dump is not supported, and returns nil.
+func (q *query) Dump() *datastore.QueryDump {
+	return nil
+}
+
+// values returns indexed values of property of e used by the query.
+func (q *query) values(e *entry, property string) []interface{} {
+	if property == keyProperty {
+		return []interface{}{e.key}
+	}
+	return indexedValues(e.props, property)
+}
+
+// match reports whether e satisfies every filter, and has every property to order by.
+func (q *query) match(e *entry) bool {
+	if e.key.kind != q.kind || e.key.namespace != q.namespace {
+		return false
+	}
+	if q.ancestor != nil && !e.key.hasAncestor(q.ancestor) {
+		return false
+	}
+	for _, f := range q.filters {
+		matched := false
+		for _, v := range q.values(e, f.property) {
+			if f.match(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, o := range q.orders {
+		if len(q.values(e, o.property)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sortValue returns value of multiple valued property to order by,
+// which is the smallest one for ascending order and the largest one for descending order.
+func (q *query) sortValue(e *entry, o order) interface{} {
+	var sv interface{}
+	for i, v := range q.values(e, o.property) {
+		c := compareValues(v, sv)
+		if i == 0 || (!o.descending && c < 0) || (o.descending && c > 0) {
+			sv = v
+		}
+	}
+	return sv
+}
+
+func (q *query) less(a, b *entry) bool {
+	for _, o := range q.orders {
+		c := compareValues(q.sortValue(a, o), q.sortValue(b, o))
+		if o.descending {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return compareKeys(a.key, b.key) < 0
+}
+
+// run returns iterator of entities which q matches at the moment.
+func (c *Client) run(ctx context.Context, q *query) *queryIterator {
+	if q.err != nil {
+		return &queryIterator{err: q.err}
+	}
+	if q.tx != nil && q.tx.finished {
+		return &queryIterator{err: errTransactionFinished}
+	}
+
+	c.mu.Lock()
+	var results []*entry
+	for _, e := range c.entities {
+		if q.match(e) {
+			results = append(results, e)
+		}
+	}
+	c.mu.Unlock()
+	sort.Slice(results, func(i, j int) bool { return q.less(results[i], results[j]) })
+
+	begin, end := 0, len(results)
+	if q.start >= 0 && q.start < end {
+		begin = q.start
+	} else if q.start >= end {
+		begin = end
+	}
+	if q.end >= 0 && q.end < end {
+		end = q.end
+	}
+	begin += q.offset
+	if begin > end {
+		begin = end
+	}
+	if q.limit >= 0 && begin+q.limit < end {
+		end = begin + q.limit
+	}
+
+	if q.tx != nil {
+		for _, e := range results[begin:end] {
+			q.tx.touch(e.key)
+		}
+	}
+	return &queryIterator{ctx: ctx, results: results, pos: begin, end: end, keysOnly: q.keysOnly}
+}
+
+// cursor is position of query results.
+type cursor int
+
+func (c cursor) String() string {
+	return strconv.Itoa(int(c))
+}
+
+// queryIterator is datastore.Iterator of Client.
+// Results are fixed when query runs, so that writes during iteration do not affect them.
+type queryIterator struct {
+	ctx      context.Context
+	results  []*entry
+	pos      int
+	end      int
+	keysOnly bool
+	err      error
+}
+
+var _ datastore.Iterator = (*queryIterator)(nil)
+
+// Next loads the next entity to dst and returns its key, or returns iterator.Done if there are no more results.
+// dst is ignored for keys only query.
+func (it *queryIterator) Next(dst interface{}) (datastore.Key, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if it.pos >= it.end {
+		return nil, iterator.Done
+	}
+	e := it.results[it.pos]
+	it.pos++
+	if !it.keysOnly && dst != nil {
+		if err := loadEntity(it.ctx, dst, e.key, e.props); err != nil {
+			return nil, err
+		}
+	}
+	return e.key, nil
+}
+
+// Cursor returns cursor of the position after the last result returned by Next.
+func (it *queryIterator) Cursor() (datastore.Cursor, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	return cursor(it.pos), nil
+}
@@ -0,0 +1,156 @@
+package datastoretest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/api/iterator"
+)
+
+func TestClient_GetAll(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	now := time.Now()
+
+	parent := client.NameKey("Parent", "p", nil)
+	entities := map[datastore.Key]*testEntity{
+		client.NameKey("Test", "a", nil):    {Name: "a", Count: 3, Tags: []string{"x", "y"}, Secret: "s", Created: now},
+		client.NameKey("Test", "b", nil):    {Name: "b", Count: 1, Tags: []string{"y"}, Created: now.Add(time.Hour)},
+		client.NameKey("Test", "c", parent): {Name: "c", Count: 2, Tags: []string{"z"}, Created: now.Add(2 * time.Hour)},
+		client.NameKey("Other", "d", nil):   {Name: "d", Count: 2},
+	}
+	for k, e := range entities {
+		if _, err := client.Put(ctx, k, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		testName string
+		in       datastore.Query
+		out      []string
+	}{
+		// Key of c comes first, since keys are ordered by path from the root ancestor.
+		{testName: "all of kind ordered by key", in: client.NewQuery("Test"), out: []string{"c", "a", "b"}},
+		{testName: "equality", in: client.NewQuery("Test").Filter("Count =", 1), out: []string{"b"}},
+		{testName: "inequality", in: client.NewQuery("Test").Filter("Count >=", 2), out: []string{"c", "a"}},
+		{testName: "time range", in: client.NewQuery("Test").Filter("Created >", now).Filter("Created <=", now.Add(time.Hour)), out: []string{"b"}},
+		{testName: "multiple valued property", in: client.NewQuery("Test").Filter("Tags =", "y"), out: []string{"a", "b"}},
+		{testName: "noindex property", in: client.NewQuery("Test").Filter("Secret =", "s"), out: nil},
+		{testName: "different type", in: client.NewQuery("Test").Filter("Count >", "0"), out: nil},
+		{testName: "ascending order", in: client.NewQuery("Test").Order("Count"), out: []string{"b", "c", "a"}},
+		{testName: "descending order", in: client.NewQuery("Test").Order("-Created"), out: []string{"c", "b", "a"}},
+		{testName: "order excludes missing property", in: client.NewQuery("Test").Order("Missing"), out: nil},
+		{testName: "ancestor", in: client.NewQuery("Test").Ancestor(parent), out: []string{"c"}},
+		{testName: "key filter", in: client.NewQuery("Test").Filter("__key__ >", client.NameKey("Test", "a", nil)), out: []string{"b"}},
+		{testName: "limit and offset", in: client.NewQuery("Test").Order("Count").Offset(1).Limit(1), out: []string{"c"}},
+		{testName: "other namespace", in: client.NewQuery("Test").Namespace("tenant"), out: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var got []*testEntity
+			keys, err := client.GetAll(ctx, tt.in, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for i, e := range got {
+				names = append(names, e.Name)
+				if keys[i].Name() != e.Name {
+					t.Errorf("key of %v\nwant: %#v\n got: %#v", i, e.Name, keys[i].Name())
+				}
+			}
+			if !reflect.DeepEqual(tt.out, names) {
+				t.Errorf("\nwant: %#v\n got: %#v", tt.out, names)
+			}
+		})
+	}
+}
+
+func TestClient_GetAll_KeysOnly(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	k := client.NameKey("Test", "a", nil)
+	if _, err := client.Put(ctx, k, &testEntity{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := client.GetAll(ctx, client.NewQuery("Test").KeysOnly(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].Equal(k) {
+		t.Errorf("\nwant: %v\n got: %v", []datastore.Key{k}, keys)
+	}
+
+	n, err := client.Count(ctx, client.NewQuery("Test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("count\nwant: %v\n got: %v", 1, n)
+	}
+}
+
+func TestClient_Run_Cursor(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := client.Put(ctx, client.NameKey("Test", name, nil), &testEntity{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it := client.Run(ctx, client.NewQuery("Test").Limit(2))
+	for i := 0; i < 2; i++ {
+		if _, err := it.Next(new(testEntity)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := it.Next(new(testEntity)); err != iterator.Done {
+		t.Errorf("\nwant: %#v\n got: %#v", iterator.Done, err)
+	}
+	c, err := it.Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = client.DecodeCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(testEntity)
+	k, err := client.Run(ctx, client.NewQuery("Test").Start(c)).Next(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Name() != "c" || got.Name != "c" {
+		t.Errorf("\nwant: %#v\n got: %#v", "c", got.Name)
+	}
+}
+
+func TestClient_Run_Unsupported(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+
+	tests := []struct {
+		testName string
+		in       datastore.Query
+	}{
+		{testName: "projection", in: client.NewQuery("Test").Project("Name")},
+		{testName: "distinct", in: client.NewQuery("Test").Distinct()},
+		{testName: "invalid filter", in: client.NewQuery("Test").Filter("Name !=", "a")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if _, err := client.GetAll(ctx, tt.in, &[]*testEntity{}); err == nil {
+				t.Error("GetAll must return error")
+			}
+		})
+	}
+}
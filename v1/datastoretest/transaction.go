@@ -0,0 +1,192 @@
+package datastoretest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mercari.io/datastore"
+)
+
+// errTransactionFinished is returned by operations of transaction which has been committed or rolled back.
+var errTransactionFinished = errors.New("datastoretest: transaction has already been committed or rolled back")
+
+// mutation is a write of transaction applied on commit. Nil props means deletion.
+type mutation struct {
+	key   *key
+	props []datastore.Property
+}
+
+// transaction is datastore.Transaction of Client with optimistic concurrency control.
+// Reads and writes of the transaction are not visible to others until commit,
+// and commit fails with datastore.ErrConcurrentTransaction if any key the transaction touched has been written since it started.
+type transaction struct {
+	client    *Client
+	ctx       context.Context
+	start     int64
+	touched   map[string]bool
+	mutations []mutation
+	finished  bool
+}
+
+var _ datastore.Transaction = (*transaction)(nil)
+
+func (c *Client) newTransaction(ctx context.Context) *transaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &transaction{
+		client:  c,
+		ctx:     ctx,
+		start:   c.seq,
+		touched: make(map[string]bool),
+	}
+}
+
+func (tx *transaction) touch(k *key) {
+	tx.touched[k.mapKey()] = true
+}
+
+func (tx *transaction) get(k datastore.Key, dst interface{}) error {
+	if tx.finished {
+		return errTransactionFinished
+	}
+	kk := toKey(k)
+	if kk == nil || !kk.valid() {
+		return datastore.ErrInvalidKey
+	}
+	tx.touch(kk)
+	return tx.client.get(tx.ctx, kk, dst)
+}
+
+// Get loads entity of key to dst. It reads committed entity, and does not see writes of the transaction.
+func (tx *transaction) Get(key datastore.Key, dst interface{}) error {
+	return tx.get(key, dst)
+}
+
+// GetMulti loads entities of keys to dst as Get.
+func (tx *transaction) GetMulti(keys []datastore.Key, dst interface{}) error {
+	return multi(keys, dst, true, func(i int, elem interface{}) error {
+		return tx.get(keys[i], elem)
+	})
+}
+
+func (tx *transaction) put(k datastore.Key, src interface{}) (*pendingKey, error) {
+	if tx.finished {
+		return nil, errTransactionFinished
+	}
+	kk, props, err := tx.client.putEntity(tx.ctx, k, src)
+	if err != nil {
+		return nil, err
+	}
+	tx.touch(kk)
+	tx.mutations = append(tx.mutations, mutation{key: kk, props: props})
+	return &pendingKey{key: kk, ctx: tx.ctx}, nil
+}
+
+// Put stores src with key on commit. ID of incomplete key is allocated immediately, and Key of Commit resolves it.
+func (tx *transaction) Put(key datastore.Key, src interface{}) (datastore.PendingKey, error) {
+	pk, err := tx.put(key, src)
+	if err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
+
+// PutMulti stores src with keys on commit as Put.
+func (tx *transaction) PutMulti(keys []datastore.Key, src interface{}) ([]datastore.PendingKey, error) {
+	pks := make([]datastore.PendingKey, len(keys))
+	err := multi(keys, src, false, func(i int, elem interface{}) error {
+		pk, err := tx.put(keys[i], elem)
+		if err != nil {
+			return err
+		}
+		pks[i] = pk
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pks, nil
+}
+
+// Delete removes entity of key on commit.
+func (tx *transaction) Delete(key datastore.Key) error {
+	return tx.DeleteMulti([]datastore.Key{key})
+}
+
+// DeleteMulti removes entities of keys on commit.
+func (tx *transaction) DeleteMulti(keys []datastore.Key) error {
+	if tx.finished {
+		return errTransactionFinished
+	}
+	deleted := make([]*key, len(keys))
+	for i, k := range keys {
+		deleted[i] = toKey(k)
+		if deleted[i] == nil || !deleted[i].valid() {
+			return datastore.ErrInvalidKey
+		}
+	}
+	for _, k := range deleted {
+		tx.touch(k)
+		tx.mutations = append(tx.mutations, mutation{key: k})
+	}
+	return nil
+}
+
+// Commit applies writes of the transaction atomically.
+// It returns datastore.ErrConcurrentTransaction without any writes, if entities the transaction touched have been changed.
+func (tx *transaction) Commit() (datastore.Commit, error) {
+	if tx.finished {
+		return nil, errTransactionFinished
+	}
+	tx.finished = true
+
+	c := tx.client
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for mk := range tx.touched {
+		if c.written[mk] > tx.start {
+			return nil, datastore.ErrConcurrentTransaction
+		}
+	}
+	c.seq++
+	for _, m := range tx.mutations {
+		c.write(m.key, m.props)
+	}
+	return commit{}, nil
+}
+
+// Rollback discards writes of the transaction.
+func (tx *transaction) Rollback() error {
+	if tx.finished {
+		return errTransactionFinished
+	}
+	tx.finished = true
+	return nil
+}
+
+// Batch is not supported, and panics as Batch of Client.
+func (tx *transaction) Batch() *datastore.TransactionBatch {
+	panic(fmt.Errorf("%w: Batch of transaction", errUnsupported))
+}
+
+// pendingKey is key of entity put in transaction, which is resolved by commit.
+type pendingKey struct {
+	key *key
+	ctx context.Context
+}
+
+func (p *pendingKey) StoredContext() context.Context {
+	return p.ctx
+}
+
+type commit struct{}
+
+// Key returns complete key of p, or nil if p is not returned by the fake client.
+func (commit) Key(p datastore.PendingKey) datastore.Key {
+	pk, ok := p.(*pendingKey)
+	if !ok {
+		return nil
+	}
+	return pk.key
+}
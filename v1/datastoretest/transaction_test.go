@@ -0,0 +1,162 @@
+package datastoretest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.mercari.io/datastore"
+)
+
+func TestTransaction_Commit(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	k := client.NameKey("Test", "name", nil)
+
+	tx, err := client.NewTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Put(k, &testEntity{Name: "put"}); err != nil {
+		t.Fatal(err)
+	}
+	pk, err := tx.Put(client.IncompleteKey("Test", nil), &testEntity{Name: "incomplete"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Get(ctx, k, new(testEntity)); err != datastore.ErrNoSuchEntity {
+		t.Errorf("entity before commit\nwant: %#v\n got: %#v", datastore.ErrNoSuchEntity, err)
+	}
+
+	commit, err := tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(testEntity)
+	if err := client.Get(ctx, commit.Key(pk), got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "incomplete" {
+		t.Errorf("\nwant: %#v\n got: %#v", "incomplete", got.Name)
+	}
+	if err := client.Get(ctx, k, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Commit(); err != errTransactionFinished {
+		t.Errorf("second commit\nwant: %#v\n got: %#v", errTransactionFinished, err)
+	}
+}
+
+func TestTransaction_Conflict(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		testName string
+		in       func(client *Client, tx datastore.Transaction, k datastore.Key) error
+		out      error
+	}{
+		{
+			testName: "read entity written by other",
+			in: func(client *Client, tx datastore.Transaction, k datastore.Key) error {
+				return tx.Get(k, new(testEntity))
+			},
+			out: datastore.ErrConcurrentTransaction,
+		},
+		{
+			testName: "entity found by query is deleted by other",
+			in: func(client *Client, tx datastore.Transaction, k datastore.Key) error {
+				_, err := client.GetAll(ctx, client.NewQuery("Test").Transaction(tx), &[]*testEntity{})
+				return err
+			},
+			out: datastore.ErrConcurrentTransaction,
+		},
+		{
+			testName: "other entity",
+			in: func(client *Client, tx datastore.Transaction, k datastore.Key) error {
+				err := tx.Get(client.NameKey("Test", "other", nil), new(testEntity))
+				if err == datastore.ErrNoSuchEntity {
+					return nil
+				}
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			client := NewClient()
+			k := client.NameKey("Test", "name", nil)
+			if _, err := client.Put(ctx, k, &testEntity{}); err != nil {
+				t.Fatal(err)
+			}
+
+			tx, err := client.NewTransaction(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.in(client, tx, k); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.Delete(ctx, k); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tx.Commit(); err != tt.out {
+				t.Errorf("\nwant: %#v\n got: %#v", tt.out, err)
+			}
+		})
+	}
+}
+
+func TestClient_RunInTransaction(t *testing.T) {
+	ctx := context.Background()
+	client := NewClient()
+	k := client.NameKey("Test", "name", nil)
+	if _, err := client.Put(ctx, k, &testEntity{Count: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	attempts := 0
+	_, err := client.RunInTransaction(ctx, func(tx datastore.Transaction) error {
+		attempts++
+		e := new(testEntity)
+		if err := tx.Get(k, e); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// Concurrent write makes the first attempt conflict.
+			if _, err := client.Put(ctx, k, &testEntity{Count: 10}); err != nil {
+				return err
+			}
+		}
+		e.Count++
+		_, err := tx.Put(k, e)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts\nwant: %v\n got: %v", 2, attempts)
+	}
+	got := new(testEntity)
+	if err := client.Get(ctx, k, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Count != 11 {
+		t.Errorf("\nwant: %v\n got: %v", 11, got.Count)
+	}
+
+	errAbort := errors.New("abort")
+	_, err = client.RunInTransaction(ctx, func(tx datastore.Transaction) error {
+		if err := tx.Delete(k); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Errorf("return error\nwant: %#v\n got: %#v", errAbort, err)
+	}
+	if err := client.Get(ctx, k, got); err != nil {
+		t.Errorf("entity must not be deleted by rolled back transaction: %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+//go:build integration
+// +build integration
+
+package datastore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+
+	osindatastore "github.com/ryutah/osin-datastore/v1"
+	"github.com/ryutah/osin-datastore/v1/storagetest"
+	"go.mercari.io/datastore"
+	"go.mercari.io/datastore/clouddatastore"
+)
+
+// Tests in this file run Storage and ClientStorage against Datastore emulator, and are built with integration tag:
+//
+//	go test -tags integration ./v1/...
+//
+// If DATASTORE_EMULATOR_HOST is set, the tests use the emulator running there, and reset its data for each test.
+// Otherwise they start the emulator with gcloud, and are skipped if gcloud or the emulator component is not installed.
+
+const defaultEmulatorProjectID = "osin-datastore-test"
+
+var (
+	emulatorHost      string
+	emulatorProjectID string
+	// emulatorErr tells why the emulator is not available, which makes the tests skipped.
+	emulatorErr error
+)
+
+func TestMain(m *testing.M) {
+	stop := startEmulator()
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
+
+// startEmulator starts the emulator unless DATASTORE_EMULATOR_HOST is set, and returns function to stop it.
+func startEmulator() (stop func()) {
+	emulatorProjectID = os.Getenv("DATASTORE_PROJECT_ID")
+	if emulatorProjectID == "" {
+		emulatorProjectID = defaultEmulatorProjectID
+	}
+	if emulatorHost = os.Getenv("DATASTORE_EMULATOR_HOST"); emulatorHost != "" {
+		return func() {}
+	}
+
+	gcloud, err := exec.LookPath("gcloud")
+	if err != nil {
+		emulatorErr = err
+		return func() {}
+	}
+	host, err := freeHost()
+	if err != nil {
+		emulatorErr = err
+		return func() {}
+	}
+
+	var output bytes.Buffer
+	cmd := exec.Command(gcloud, "beta", "emulators", "datastore", "start",
+		"--project="+emulatorProjectID,
+		"--host-port="+host,
+		"--no-store-on-disk",
+		"--consistency=1.0",
+	)
+	// gcloud must fail instead of asking to install the emulator component.
+	cmd.Env = append(os.Environ(), "CLOUDSDK_CORE_DISABLE_PROMPTS=1")
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		emulatorErr = err
+		return func() {}
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	if err := waitEmulator(host, exited, time.Minute); err != nil {
+		cmd.Process.Kill()
+		emulatorErr = fmt.Errorf("%v: %s", err, strings.TrimSpace(output.String()))
+		return func() {}
+	}
+	emulatorHost = host
+	os.Setenv("DATASTORE_EMULATOR_HOST", host)
+
+	return func() {
+		if _, err := http.Post("http://"+host+"/shutdown", "", nil); err != nil {
+			cmd.Process.Kill()
+		}
+		select {
+		case <-exited:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+		}
+	}
+}
+
+func freeHost() (string, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// waitEmulator waits until the emulator at host responds, or gcloud exits.
+func waitEmulator(host string, exited <-chan error, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		resp, err := http.Get("http://" + host + "/")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		select {
+		case err := <-exited:
+			return fmt.Errorf("emulator exited: %v", err)
+		case <-deadline:
+			return errors.New("emulator did not start in time")
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// newEmulatorClient resets data of the emulator, and returns datastore client connected to it.
+// The test is skipped if the emulator is not available.
+func newEmulatorClient(t *testing.T) datastore.Client {
+	t.Helper()
+	if emulatorErr != nil {
+		t.Skipf("Datastore emulator is not available: %v", emulatorErr)
+	}
+
+	resp, err := http.Post("http://"+emulatorHost+"/reset", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("reset emulator: %s", resp.Status)
+	}
+
+	client, err := clouddatastore.FromContext(context.Background(), datastore.WithProjectID(emulatorProjectID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// emulatorStorage is Storage which closes its datastore client on Close.
+type emulatorStorage struct {
+	*osindatastore.Storage
+	client datastore.Client
+}
+
+func (s *emulatorStorage) Close() {
+	s.Storage.Close()
+	s.client.Close()
+}
+
+func newEmulatorStorage(opts ...osindatastore.StorageOption) storagetest.Factory {
+	return func(t *testing.T, clients ...osin.Client) osin.Storage {
+		ctx := context.Background()
+		client := newEmulatorClient(t)
+		storage := &emulatorStorage{
+			Storage: osindatastore.NewStorageWithClient(ctx, client, opts...),
+			client:  client,
+		}
+		for _, c := range clients {
+			err := storage.ClientStorage().Put(ctx, &osindatastore.Client{
+				ID:          c.GetId(),
+				Secret:      c.GetSecret(),
+				RedirectUri: c.GetRedirectUri(),
+				UserData:    c.GetUserData().(string),
+			})
+			if err != nil {
+				storage.Close()
+				t.Fatal(err)
+			}
+		}
+		return storage
+	}
+}
+
+func TestEmulator_Conformance(t *testing.T) {
+	tests := []struct {
+		testName string
+		in       []osindatastore.StorageOption
+	}{
+		{testName: "default"},
+		{testName: "denormalized", in: []osindatastore.StorageOption{osindatastore.WithDenormalizedTokens()}},
+		{testName: "outbox and audit", in: []osindatastore.StorageOption{osindatastore.WithDenormalizedTokens(), osindatastore.WithOutbox(), osindatastore.WithAudit()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			storagetest.Run(t, newEmulatorStorage(tt.in...))
+		})
+	}
+}
+
+// TestEmulator_Serialization checks properties which mocks of datastore client do not serialize:
+// time precision, multiple valued scopes, and noindex properties longer than indexed properties can be.
+func TestEmulator_Serialization(t *testing.T) {
+	ctx := context.Background()
+	long := strings.Repeat("x", 2000)
+	createdAt := time.Now().Truncate(time.Second).Add(123456789 * time.Nanosecond)
+
+	storage := newEmulatorStorage(osindatastore.WithDenormalizedTokens())(t).(*emulatorStorage)
+	defer storage.Close()
+
+	client := &osindatastore.Client{
+		ID:            "client",
+		Secret:        "secret",
+		RedirectUri:   "http://localhost/" + long,
+		UserData:      long,
+		AllowedScopes: []string{"read", "write"},
+	}
+	if err := storage.ClientStorage().Put(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	gotClient, err := storage.ClientStorage().Get(ctx, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotClient.RedirectUri != client.RedirectUri || gotClient.UserData != long || len(gotClient.AllowedScopes) != 2 {
+		t.Errorf("\nwant: %#v\n got: %#v", client, gotClient)
+	}
+
+	access := &osin.AccessData{
+		Client:       gotClient,
+		AccessToken:  "token",
+		RefreshToken: "refresh",
+		ExpiresIn:    3600,
+		Scope:        "read write",
+		RedirectUri:  gotClient.RedirectUri,
+		CreatedAt:    createdAt,
+		UserData:     long,
+	}
+	if err := storage.SaveAccess(access); err != nil {
+		t.Fatal(err)
+	}
+	got, err := storage.LoadRefresh("refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Scope != access.Scope {
+		t.Errorf("Scope\nwant: %#v\n got: %#v", access.Scope, got.Scope)
+	}
+	// Datastore keeps time in microseconds.
+	if want := createdAt.Truncate(time.Microsecond); !got.CreatedAt.Equal(want) {
+		t.Errorf("CreatedAt\nwant: %v\n got: %v", want, got.CreatedAt)
+	}
+	if got.UserData != long || got.RedirectUri != access.RedirectUri {
+		t.Errorf("\nwant: %#v\n got: %#v", access, got)
+	}
+}
+
+func TestEmulator_ClientStorage(t *testing.T) {
+	ctx := context.Background()
+	client := newEmulatorClient(t)
+	defer client.Close()
+	storage := osindatastore.NewClientStorageWithClient(client)
+
+	if err := storage.Insert(ctx, &osindatastore.Client{ID: "client", Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Insert(ctx, &osindatastore.Client{ID: "client"}); !errors.Is(err, osindatastore.ErrClientAlreadyExists) {
+		t.Errorf("Insert of existing client\nwant: %#v\n got: %#v", osindatastore.ErrClientAlreadyExists, err)
+	}
+	err := storage.Update(ctx, "client", func(c *osindatastore.Client) error {
+		c.RedirectUri = "http://localhost/updated"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := storage.GetMultiPartial(ctx, []string{"client", "unknown"})
+	if _, ok := err.(datastore.MultiError); !ok {
+		t.Errorf("GetMultiPartial of unknown client\nwant: datastore.MultiError\n got: %#v", err)
+	}
+	if len(got) != 2 || got[0] == nil || got[0].RedirectUri != "http://localhost/updated" || got[1] != nil {
+		t.Errorf("unexpected clients: %#v", got)
+	}
+
+	if err := storage.Delete(ctx, "client"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get(ctx, "client"); err != datastore.ErrNoSuchEntity {
+		t.Errorf("Get of deleted client\nwant: %#v\n got: %#v", datastore.ErrNoSuchEntity, err)
+	}
+}
+
+// TestEmulator_Events checks queries and transactions of audit trail and outbox.
+func TestEmulator_Events(t *testing.T) {
+	ctx := context.Background()
+	storage := newEmulatorStorage(osindatastore.WithAudit(), osindatastore.WithOutbox())(t, &osin.DefaultClient{Id: "client", UserData: ""}).(*emulatorStorage)
+	defer storage.Close()
+
+	client, err := storage.GetClient("client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SaveAccess(&osin.AccessData{Client: client, AccessToken: "token", CreatedAt: time.Now(), UserData: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.RemoveAccess("token"); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := storage.AuditStorage().Query(ctx, osindatastore.AuditQuery{ClientID: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Type != osindatastore.AuditTokenRevoked || events[1].Type != osindatastore.AuditTokenIssued {
+		t.Errorf("unexpected audit events: %#v", events)
+	}
+
+	var published []osindatastore.TokenEventType
+	poller := osindatastore.NewOutboxPoller(storage.client, func(_ context.Context, ev *osindatastore.TokenEvent) error {
+		published = append(published, ev.Type)
+		return nil
+	})
+	if _, err := poller.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []osindatastore.TokenEventType{osindatastore.TokenEventIssued, osindatastore.TokenEventRevoked}
+	if fmt.Sprint(want) != fmt.Sprint(published) {
+		t.Errorf("published events\nwant: %v\n got: %v", want, published)
+	}
+	if n, err := poller.Poll(ctx); err != nil || n != 0 {
+		t.Errorf("events must be removed once published, got %d events: %v", n, err)
+	}
+}
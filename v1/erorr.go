@@ -1,9 +1,84 @@
 package datastore
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/RangelReale/osin"
+	"go.mercari.io/datastore"
+)
 
 // Error definitions
 var (
-	ErrEmptyClientID       = errors.New("ID field of Client is empty")
-	ErrInvalidUserDataType = errors.New("UserData field must be string")
+	ErrEmptyClientID        = errors.New("ID field of Client is empty")
+	ErrInvalidUserDataType  = errors.New("UserData field must be string")
+	ErrPublicClientSecret   = errors.New("public Client must not have Secret")
+	ErrEmptyClientSecret    = errors.New("client secret is empty")
+	ErrClientSecretNotFound = errors.New("client secret is not found")
+	ErrClientAlreadyExists  = errors.New("client already exists")
+	ErrConflict             = errors.New("client has been changed concurrently")
+	ErrExpired              = errors.New("token has expired")
+	ErrRevoked              = errors.New("token has been revoked")
+	ErrClientDisabled       = errors.New("client is disabled")
+	ErrTransient            = errors.New("transient datastore error")
 )
+
+// Error is error of an operation of Storage.
+// Use errors.Is to tell the case, where not found, expired, revoked and client disabled errors
+// are all osin.ErrNotFound as well, since osin treats them in the same way.
+//
+//	errors.Is(err, osin.ErrNotFound)   // entity is missing, or any case below
+//	errors.Is(err, ErrExpired)         // authorize code has expired
+//	errors.Is(err, ErrRevoked)         // access token of the refresh token has been removed
+//	errors.Is(err, ErrClientDisabled)  // client of the token is suspended
+//	errors.Is(err, ErrConflict)        // transaction conflicted
+//	errors.Is(err, ErrTransient)       // retrying may succeed, see IsTransientError
+//
+// Use errors.As to get the original error of Datastore or the handler from Err.
+type Error struct {
+	Op   Operation
+	Kind string
+	// Key is TokenFingerprint of key of the entity, not to leak tokens into logs.
+	Key string
+	Err error
+}
+
+func newError(op Operation, kind, key string, err error) *Error {
+	return &Error{
+		Op:   op,
+		Kind: kind,
+		Key:  redactKey(key),
+		Err:  err,
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("datastore: %s %s %q: %v", e.Op, e.Kind, e.Key, e.Err)
+}
+
+// Unwrap returns the original error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether e is the case of target.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case osin.ErrNotFound:
+		return errors.Is(e.Err, datastore.ErrNoSuchEntity) ||
+			errors.Is(e.Err, ErrExpired) ||
+			errors.Is(e.Err, ErrRevoked) ||
+			errors.Is(e.Err, ErrClientDisabled)
+	case ErrConflict:
+		return errors.Is(e.Err, datastore.ErrConcurrentTransaction)
+	case ErrTransient:
+		return IsTransientError(e.Err)
+	default:
+		return false
+	}
+}
+
+// redactKey returns TokenFingerprint of key, so that errors, events and logs identify keys in the same way without leaking tokens.
+func redactKey(key string) string {
+	return TokenFingerprint(key)
+}
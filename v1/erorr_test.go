@@ -0,0 +1,146 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestError_Is(t *testing.T) {
+	targets := []error{osin.ErrNotFound, ErrExpired, ErrRevoked, ErrClientDisabled, ErrConflict, ErrTransient}
+
+	tests := []struct {
+		testName string
+		cause    error
+		want     []error
+	}{
+		{testName: "no such entity", cause: datastore.ErrNoSuchEntity, want: []error{osin.ErrNotFound}},
+		{testName: "not found of other backend", cause: osin.ErrNotFound, want: []error{osin.ErrNotFound}},
+		{testName: "expired", cause: ErrExpired, want: []error{osin.ErrNotFound, ErrExpired}},
+		{testName: "revoked", cause: ErrRevoked, want: []error{osin.ErrNotFound, ErrRevoked}},
+		{testName: "client disabled", cause: ErrClientDisabled, want: []error{osin.ErrNotFound, ErrClientDisabled}},
+		{testName: "concurrent transaction", cause: datastore.ErrConcurrentTransaction, want: []error{ErrConflict, ErrTransient}},
+		{testName: "unavailable", cause: status.Error(codes.Unavailable, "unavailable"), want: []error{ErrTransient}},
+		{testName: "other", cause: errors.New("error")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			err := newError(OperationLoadAccess, KindAccessData, "token", tt.cause)
+			for _, target := range targets {
+				want := false
+				for _, w := range tt.want {
+					want = want || w == target
+				}
+				if got := errors.Is(err, target); got != want {
+					t.Errorf("errors.Is(err, %q)\nwant: %v\n got: %v", target, want, got)
+				}
+			}
+			if !errors.Is(err, tt.cause) {
+				t.Error("error must keep its cause")
+			}
+		})
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	err := newError(OperationLoadAccess, KindAccessData, "secret_token", datastore.ErrNoSuchEntity)
+	want := `datastore: LoadAccess access_data "` + TokenFingerprint("secret_token") + `": ` + datastore.ErrNoSuchEntity.Error()
+	if got := err.Error(); got != want {
+		t.Errorf("\nwant: %q\n got: %q", want, got)
+	}
+	if strings.Contains(err.Error(), "secr") {
+		t.Errorf("error must not contain the token: %q", err.Error())
+	}
+}
+
+func TestStorage_GetClient_Errors(t *testing.T) {
+	errRPC := errors.New("rpc error")
+
+	tests := []struct {
+		testName string
+		err      error
+		check    func(t *testing.T, err error)
+	}{
+		{
+			testName: "not found",
+			err:      datastore.ErrNoSuchEntity,
+			check: func(t *testing.T, err error) {
+				// osin compares the error with osin.ErrNotFound.
+				if err != osin.ErrNotFound {
+					t.Errorf("return error\nwant: %#v\n got: %#v", osin.ErrNotFound, err)
+				}
+			},
+		},
+		{
+			testName: "other error",
+			err:      errRPC,
+			check: func(t *testing.T, err error) {
+				var e *Error
+				if !errors.As(err, &e) || e.Op != OperationGetClient || e.Kind != KindClient || e.Err != errRPC {
+					t.Errorf("return error\nwant: *Error of %#v\n got: %#v", errRPC, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mch := NewMockClientGetter(ctrl)
+			mch.EXPECT().Get(gomock.Any(), "client").Return(nil, tt.err)
+
+			storage := &Storage{ctx: context.Background(), clientGetter: mch}
+			_, err := storage.GetClient("client")
+			tt.check(t, err)
+		})
+	}
+}
+
+func TestStorage_LoadAuthorize_Expired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mauh := NewMockAuthorizeDataHandler(ctrl)
+	mauh.EXPECT().Get(gomock.Any(), "code").Return(&AuthorizeData{
+		Code:      "code",
+		ClientKey: "client",
+		ExpiresIn: 60,
+		CreatedAt: time.Now().Add(-time.Hour),
+	}, nil)
+
+	storage := &Storage{ctx: context.Background(), authDataHandler: mauh}
+	_, err := storage.LoadAuthorize("code")
+	if !errors.Is(err, ErrExpired) || !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("return error\nwant: %#v\n got: %#v", ErrExpired, err)
+	}
+}
+
+func TestStorage_LoadRefresh_Revoked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mrh  = NewMockRefreshHandler(ctrl)
+		mach = NewMockAccessDataHandler(ctrl)
+	)
+	mrh.EXPECT().Get(gomock.Any(), "refresh").Return(&Refresh{RefreshToken: "refresh", AccessToken: "token"}, nil)
+	mach.EXPECT().Get(gomock.Any(), "token").Return(nil, datastore.ErrNoSuchEntity)
+
+	storage := &Storage{ctx: context.Background(), refreshHandler: mrh, accessDataHandler: mach}
+	_, err := storage.LoadRefresh("refresh")
+	if !errors.Is(err, ErrRevoked) || !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("return error\nwant: %#v\n got: %#v", ErrRevoked, err)
+	}
+}
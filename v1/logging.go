@@ -0,0 +1,201 @@
+package datastore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// LogLevel is level of a log entry written by LoggingStorage.
+type LogLevel int
+
+// Log levels.
+const (
+	// LogLevelDebug is level of successful calls, and not found which is usual for invalid tokens.
+	LogLevelDebug LogLevel = iota
+	// LogLevelError is level of failed calls.
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// LogField is a key and value of structured log entry.
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger is structured logger used by LoggingStorage.
+// Adapters for logging libraries can be implemented outside of this package.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+// LoggerFunc is function which implements Logger.
+type LoggerFunc func(level LogLevel, msg string, fields ...LogField)
+
+// Log calls f.
+func (f LoggerFunc) Log(level LogLevel, msg string, fields ...LogField) {
+	f(level, msg, fields...)
+}
+
+// TokenFingerprint returns fingerprint of token, which identifies the token in logs without revealing it.
+// TokenFingerprint of empty token is empty.
+func TokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:6])
+}
+
+// LoggingStorage is osin.Storage which logs every call to wrapped storage.
+// Tokens and codes are logged only as their fingerprints, and clients only as their IDs.
+// Errors are logged as they are, so errors of the wrapped storage must not contain tokens.
+// Errors of Storage contain keys only as their fingerprints.
+type LoggingStorage struct {
+	storage osin.Storage
+	logger  Logger
+}
+
+var _ osin.Storage = (*LoggingStorage)(nil)
+
+// NewLoggingStorage creates LoggingStorage which wraps storage and logs to logger.
+func NewLoggingStorage(storage osin.Storage, logger Logger) *LoggingStorage {
+	return &LoggingStorage{
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+func (l *LoggingStorage) log(method string, start time.Time, err error, fields ...LogField) {
+	level := LogLevelDebug
+	if err != nil && !errors.Is(err, osin.ErrNotFound) {
+		level = LogLevelError
+	}
+	fields = append(fields, LogField{Key: "duration", Value: timeNow().Sub(start)})
+	if err != nil {
+		fields = append(fields, LogField{Key: "error", Value: err.Error()})
+	}
+	l.logger.Log(level, method, fields...)
+}
+
+func clientIDOf(c osin.Client) string {
+	if c == nil {
+		return ""
+	}
+	return c.GetId()
+}
+
+// Clone returns LoggingStorage which wraps clone of the wrapped storage.
+func (l *LoggingStorage) Clone() osin.Storage {
+	return NewLoggingStorage(l.storage.Clone(), l.logger)
+}
+
+// Close closes the wrapped storage.
+func (l *LoggingStorage) Close() {
+	l.storage.Close()
+}
+
+// GetClient calls GetClient of the wrapped storage and logs it.
+func (l *LoggingStorage) GetClient(id string) (osin.Client, error) {
+	start := timeNow()
+	c, err := l.storage.GetClient(id)
+	l.log("GetClient", start, err, LogField{Key: "client_id", Value: id})
+	return c, err
+}
+
+// SaveAuthorize calls SaveAuthorize of the wrapped storage and logs it.
+func (l *LoggingStorage) SaveAuthorize(auth *osin.AuthorizeData) error {
+	start := timeNow()
+	err := l.storage.SaveAuthorize(auth)
+	l.log("SaveAuthorize", start, err,
+		LogField{Key: "client_id", Value: clientIDOf(auth.Client)},
+		LogField{Key: "code", Value: TokenFingerprint(auth.Code)},
+	)
+	return err
+}
+
+// LoadAuthorize calls LoadAuthorize of the wrapped storage and logs it.
+func (l *LoggingStorage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	start := timeNow()
+	auth, err := l.storage.LoadAuthorize(code)
+	fields := []LogField{{Key: "code", Value: TokenFingerprint(code)}}
+	if auth != nil {
+		fields = append(fields, LogField{Key: "client_id", Value: clientIDOf(auth.Client)})
+	}
+	l.log("LoadAuthorize", start, err, fields...)
+	return auth, err
+}
+
+// RemoveAuthorize calls RemoveAuthorize of the wrapped storage and logs it.
+func (l *LoggingStorage) RemoveAuthorize(code string) error {
+	start := timeNow()
+	err := l.storage.RemoveAuthorize(code)
+	l.log("RemoveAuthorize", start, err, LogField{Key: "code", Value: TokenFingerprint(code)})
+	return err
+}
+
+// SaveAccess calls SaveAccess of the wrapped storage and logs it.
+func (l *LoggingStorage) SaveAccess(a *osin.AccessData) error {
+	start := timeNow()
+	err := l.storage.SaveAccess(a)
+	l.log("SaveAccess", start, err,
+		LogField{Key: "client_id", Value: clientIDOf(a.Client)},
+		LogField{Key: "token", Value: TokenFingerprint(a.AccessToken)},
+		LogField{Key: "refresh_token", Value: TokenFingerprint(a.RefreshToken)},
+	)
+	return err
+}
+
+// LoadAccess calls LoadAccess of the wrapped storage and logs it.
+func (l *LoggingStorage) LoadAccess(token string) (*osin.AccessData, error) {
+	start := timeNow()
+	a, err := l.storage.LoadAccess(token)
+	fields := []LogField{{Key: "token", Value: TokenFingerprint(token)}}
+	if a != nil {
+		fields = append(fields, LogField{Key: "client_id", Value: clientIDOf(a.Client)})
+	}
+	l.log("LoadAccess", start, err, fields...)
+	return a, err
+}
+
+// RemoveAccess calls RemoveAccess of the wrapped storage and logs it.
+func (l *LoggingStorage) RemoveAccess(token string) error {
+	start := timeNow()
+	err := l.storage.RemoveAccess(token)
+	l.log("RemoveAccess", start, err, LogField{Key: "token", Value: TokenFingerprint(token)})
+	return err
+}
+
+// LoadRefresh calls LoadRefresh of the wrapped storage and logs it.
+func (l *LoggingStorage) LoadRefresh(token string) (*osin.AccessData, error) {
+	start := timeNow()
+	a, err := l.storage.LoadRefresh(token)
+	fields := []LogField{{Key: "refresh_token", Value: TokenFingerprint(token)}}
+	if a != nil {
+		fields = append(fields, LogField{Key: "client_id", Value: clientIDOf(a.Client)})
+	}
+	l.log("LoadRefresh", start, err, fields...)
+	return a, err
+}
+
+// RemoveRefresh calls RemoveRefresh of the wrapped storage and logs it.
+func (l *LoggingStorage) RemoveRefresh(token string) error {
+	start := timeNow()
+	err := l.storage.RemoveRefresh(token)
+	l.log("RemoveRefresh", start, err, LogField{Key: "refresh_token", Value: TokenFingerprint(token)})
+	return err
+}
@@ -0,0 +1,234 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+)
+
+// fakeOsinStorage is osin.Storage which returns err for every call. Methods not overridden panic.
+type fakeOsinStorage struct {
+	osin.Storage
+	client osin.Client
+	err    error
+}
+
+func (f *fakeOsinStorage) GetClient(id string) (osin.Client, error) {
+	return f.client, f.err
+}
+
+func (f *fakeOsinStorage) SaveAccess(a *osin.AccessData) error {
+	return f.err
+}
+
+func (f *fakeOsinStorage) LoadAccess(token string) (*osin.AccessData, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &osin.AccessData{AccessToken: token, Client: f.client}, nil
+}
+
+type logEntry struct {
+	level  LogLevel
+	msg    string
+	fields map[string]interface{}
+}
+
+func recordLogs(entries *[]logEntry) Logger {
+	return LoggerFunc(func(level LogLevel, msg string, fields ...LogField) {
+		m := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			m[f.Key] = f.Value
+		}
+		*entries = append(*entries, logEntry{level: level, msg: msg, fields: m})
+	})
+}
+
+func TestLoggingStorage(t *testing.T) {
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	client := &Client{ID: "client", Secret: "client-secret"}
+	errRPC := errors.New("rpc error")
+
+	tests := []struct {
+		testName string
+		storage  *fakeOsinStorage
+		call     func(s osin.Storage) error
+		out      logEntry
+	}{
+		{
+			testName: "save access",
+			storage:  &fakeOsinStorage{},
+			call: func(s osin.Storage) error {
+				return s.SaveAccess(&osin.AccessData{Client: client, AccessToken: "access-token", RefreshToken: "refresh-token"})
+			},
+			out: logEntry{
+				level: LogLevelDebug,
+				msg:   "SaveAccess",
+				fields: map[string]interface{}{
+					"client_id":     "client",
+					"token":         TokenFingerprint("access-token"),
+					"refresh_token": TokenFingerprint("refresh-token"),
+					"duration":      time.Duration(0),
+				},
+			},
+		},
+		{
+			testName: "load access",
+			storage:  &fakeOsinStorage{client: client},
+			call: func(s osin.Storage) error {
+				_, err := s.LoadAccess("access-token")
+				return err
+			},
+			out: logEntry{
+				level: LogLevelDebug,
+				msg:   "LoadAccess",
+				fields: map[string]interface{}{
+					"client_id": "client",
+					"token":     TokenFingerprint("access-token"),
+					"duration":  time.Duration(0),
+				},
+			},
+		},
+		{
+			testName: "not found",
+			storage:  &fakeOsinStorage{err: osin.ErrNotFound},
+			call: func(s osin.Storage) error {
+				_, err := s.LoadAccess("access-token")
+				return err
+			},
+			out: logEntry{
+				level: LogLevelDebug,
+				msg:   "LoadAccess",
+				fields: map[string]interface{}{
+					"token":    TokenFingerprint("access-token"),
+					"duration": time.Duration(0),
+					"error":    osin.ErrNotFound.Error(),
+				},
+			},
+		},
+		{
+			testName: "error",
+			storage:  &fakeOsinStorage{err: errRPC},
+			call: func(s osin.Storage) error {
+				_, err := s.GetClient("client")
+				return err
+			},
+			out: logEntry{
+				level: LogLevelError,
+				msg:   "GetClient",
+				fields: map[string]interface{}{
+					"client_id": "client",
+					"duration":  time.Duration(0),
+					"error":     errRPC.Error(),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var entries []logEntry
+			storage := NewLoggingStorage(tt.storage, recordLogs(&entries))
+
+			if err := tt.call(storage); err != tt.storage.err {
+				t.Errorf("return error\nwant: %#v\n got: %#v", tt.storage.err, err)
+			}
+			if want := []logEntry{tt.out}; !reflect.DeepEqual(want, entries) {
+				t.Errorf("\nwant: %#v\n got: %#v", want, entries)
+			}
+			for _, e := range entries {
+				logged := fmt.Sprint(e.fields)
+				for _, secret := range []string{"access-token", "refresh-token", "client-secret"} {
+					if strings.Contains(logged, secret) {
+						t.Errorf("log must not contain %q: %s", secret, logged)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestTokenFingerprint(t *testing.T) {
+	if TokenFingerprint("") != "" {
+		t.Error("fingerprint of empty token must be empty")
+	}
+	fp := TokenFingerprint("token")
+	if fp == "" || strings.Contains(fp, "token") {
+		t.Errorf("fingerprint must not reveal token, got: %q", fp)
+	}
+	if fp != TokenFingerprint("token") || fp == TokenFingerprint("other") {
+		t.Error("fingerprint must identify the token")
+	}
+}
+
+func TestLoggingStorage_StorageErrorsHideTokens(t *testing.T) {
+	const token = "Zq7xW3vK9mPt"
+	errRPC := errors.New("rpc error")
+
+	tests := []struct {
+		testName string
+		call     func(s osin.Storage) error
+	}{
+		{
+			testName: "load access",
+			call: func(s osin.Storage) error {
+				_, err := s.LoadAccess(token)
+				return err
+			},
+		},
+		{
+			testName: "load refresh not found",
+			call: func(s osin.Storage) error {
+				_, err := s.LoadRefresh(token)
+				return err
+			},
+		},
+		{
+			testName: "remove access",
+			call: func(s osin.Storage) error {
+				return s.RemoveAccess(token)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mad := NewMockAccessDataHandler(ctrl)
+			mad.EXPECT().Get(gomock.Any(), token).Return(nil, errRPC).AnyTimes()
+			mad.EXPECT().Delete(gomock.Any(), token).Return(errRPC).AnyTimes()
+			mrh := NewMockRefreshHandler(ctrl)
+			mrh.EXPECT().Get(gomock.Any(), token).Return(nil, datastore.ErrNoSuchEntity).AnyTimes()
+
+			var entries []logEntry
+			storage := NewStorageWithHandlers(context.Background(), Handlers{AccessData: mad, Refresh: mrh})
+			if err := tt.call(NewLoggingStorage(storage, recordLogs(&entries))); err == nil {
+				t.Fatal("want error")
+			}
+			if len(entries) != 1 || entries[0].fields["error"] == nil {
+				t.Fatalf("error must be logged: %#v", entries)
+			}
+			// Not even a part of the token may be logged.
+			logged := fmt.Sprint(entries[0].fields)
+			for i := 0; i+4 <= len(token); i++ {
+				if part := token[i : i+4]; strings.Contains(logged, part) {
+					t.Errorf("log must not contain %q of the token: %s", part, logged)
+				}
+			}
+		})
+	}
+}
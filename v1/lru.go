@@ -0,0 +1,95 @@
+package datastore
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lru is in-memory cache which evicts least recently used entries, and expires each entry at its own time.
+type lru struct {
+	size int
+
+	mu      sync.Mutex
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// newLRU create lru which holds up to size entries. Zero size means no limit.
+func newLRU(size int) *lru {
+	return &lru{
+		size:    size,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (l *lru) get(key string) (interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && !timeNow().Before(entry.expiresAt) {
+		l.removeElement(e)
+		return nil, false
+	}
+	l.ll.MoveToFront(e)
+	return entry.value, true
+}
+
+// add caches value for key until expiresAt. Zero expiresAt means the entry never expires.
+func (l *lru) add(key string, value interface{}, expiresAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key]; ok {
+		entry := e.Value.(*lruEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		l.ll.MoveToFront(e)
+		return
+	}
+	l.entries[key] = l.ll.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	if l.size > 0 && l.ll.Len() > l.size {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+func (l *lru) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key]; ok {
+		l.removeElement(e)
+	}
+}
+
+func (l *lru) purge() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.ll.Init()
+	l.entries = make(map[string]*list.Element)
+}
+
+func (l *lru) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.ll.Len()
+}
+
+func (l *lru) removeElement(e *list.Element) {
+	l.ll.Remove(e)
+	delete(l.entries, e.Value.(*lruEntry).key)
+}
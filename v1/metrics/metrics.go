@@ -0,0 +1,129 @@
+// Package metrics exposes metrics of osin-datastore in Prometheus format.
+//
+// Collector observes operations of Storage and ClientStorage, and is registered to Prometheus registry:
+//
+//	collector := metrics.NewCollector("oauth2")
+//	prometheus.MustRegister(collector)
+//	storage := datastore.NewStorageWithClient(ctx, client, datastore.WithObserver(collector))
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	datastore "github.com/ryutah/osin-datastore/v1"
+)
+
+// UnknownClient is label value of client for tokens whose client is not known by the operation.
+const UnknownClient = "unknown"
+
+// Collector is prometheus.Collector of metrics of storage operations, and datastore.Observer which records them.
+//
+// Metrics are below, prefixed with namespace given to NewCollector:
+//
+//	operations_total{operation, kind, result}         counter of operations, where result is ok, not_found or error
+//	operation_duration_seconds{operation, kind}       histogram of latency of operations
+//	tokens_issued_total{client}                       counter of access tokens saved by SaveAccess
+//	tokens_revoked_total{client}                      counter of existing access tokens revoked by RemoveAccess, see datastore.Event.Revoked
+//	swept_total{kind}                                 counter of expired entities removed by sweepers, recorded by ObserveSweep
+//
+// Token counters are labeled with client ID, so keep number of clients bounded.
+type Collector struct {
+	operations *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	issued     *prometheus.CounterVec
+	revoked    *prometheus.CounterVec
+	swept      *prometheus.CounterVec
+}
+
+var _ datastore.Observer = (*Collector)(nil)
+
+// NewCollector creates Collector whose metrics are prefixed with namespace.
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Number of storage operations by result.",
+		}, []string{"operation", "kind", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of storage operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "kind"}),
+		issued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tokens_issued_total",
+			Help:      "Number of access tokens issued by client.",
+		}, []string{"client"}),
+		revoked: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tokens_revoked_total",
+			Help:      "Number of access tokens revoked by client.",
+		}, []string{"client"}),
+		swept: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "swept_total",
+			Help:      "Number of expired entities removed by sweepers by kind.",
+		}, []string{"kind"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.operations.Describe(ch)
+	c.duration.Describe(ch)
+	c.issued.Describe(ch)
+	c.revoked.Describe(ch)
+	c.swept.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.operations.Collect(ch)
+	c.duration.Collect(ch)
+	c.issued.Collect(ch)
+	c.revoked.Collect(ch)
+	c.swept.Collect(ch)
+}
+
+// StartOperation implements datastore.Observer, and returns ctx as is.
+func (c *Collector) StartOperation(ctx context.Context, _ datastore.Operation, _, _ string) context.Context {
+	return ctx
+}
+
+// EndOperation implements datastore.Observer, and records ev.
+func (c *Collector) EndOperation(_ context.Context, ev datastore.Event) {
+	op := string(ev.Op)
+	c.operations.WithLabelValues(op, ev.Kind, string(ev.Result)).Inc()
+	c.duration.WithLabelValues(op, ev.Kind).Observe(ev.Duration.Seconds())
+
+	if ev.Result != datastore.ResultOK {
+		return
+	}
+	switch ev.Op {
+	case datastore.OperationSaveAccess:
+		c.issued.WithLabelValues(clientLabel(ev.ClientID)).Inc()
+	case datastore.OperationRemoveAccess:
+		if ev.Revoked {
+			c.revoked.WithLabelValues(clientLabel(ev.ClientID)).Inc()
+		}
+	}
+}
+
+// ObserveSweep records that a sweeper has removed n expired entities of kind, e.g. datastore.KindAuditEvent.
+// Storage does not sweep expired entities by itself, so the process which sweeps them calls this for progress of each run:
+//
+//	n, err := audit.Purge(ctx, retention)
+//	collector.ObserveSweep(datastore.KindAuditEvent, n)
+func (c *Collector) ObserveSweep(kind string, n int) {
+	c.swept.WithLabelValues(kind).Add(float64(n))
+}
+
+func clientLabel(id string) string {
+	if id == "" {
+		return UnknownClient
+	}
+	return id
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	datastore "github.com/ryutah/osin-datastore/v1"
+)
+
+func TestCollector(t *testing.T) {
+	tests := []struct {
+		testName string
+		in       []datastore.Event
+		metric   string
+		out      string
+	}{
+		{
+			testName: "operations by result",
+			in: []datastore.Event{
+				{Op: datastore.OperationLoadAccess, Kind: datastore.KindAccessData, Result: datastore.ResultOK},
+				{Op: datastore.OperationLoadAccess, Kind: datastore.KindAccessData, Result: datastore.ResultOK},
+				{Op: datastore.OperationLoadAccess, Kind: datastore.KindAccessData, Result: datastore.ResultNotFound},
+				{Op: datastore.OperationGetClient, Kind: datastore.KindClient, Result: datastore.ResultError},
+			},
+			metric: "test_operations_total",
+			out: `
+				# HELP test_operations_total Number of storage operations by result.
+				# TYPE test_operations_total counter
+				test_operations_total{kind="access_data",operation="LoadAccess",result="not_found"} 1
+				test_operations_total{kind="access_data",operation="LoadAccess",result="ok"} 2
+				test_operations_total{kind="client",operation="GetClient",result="error"} 1
+			`,
+		},
+		{
+			testName: "tokens issued",
+			in: []datastore.Event{
+				{Op: datastore.OperationSaveAccess, Kind: datastore.KindAccessData, ClientID: "client1", Result: datastore.ResultOK},
+				{Op: datastore.OperationSaveAccess, Kind: datastore.KindAccessData, ClientID: "client1", Result: datastore.ResultOK},
+				{Op: datastore.OperationSaveAccess, Kind: datastore.KindAccessData, ClientID: "client2", Result: datastore.ResultError},
+			},
+			metric: "test_tokens_issued_total",
+			out: `
+				# HELP test_tokens_issued_total Number of access tokens issued by client.
+				# TYPE test_tokens_issued_total counter
+				test_tokens_issued_total{client="client1"} 2
+			`,
+		},
+		{
+			testName: "tokens revoked",
+			in: []datastore.Event{
+				{Op: datastore.OperationRemoveAccess, Kind: datastore.KindAccessData, ClientID: "client1", Revoked: true, Result: datastore.ResultOK},
+				// Missing token and rotation of refreshed token are not revocation.
+				{Op: datastore.OperationRemoveAccess, Kind: datastore.KindAccessData, Result: datastore.ResultOK},
+				{Op: datastore.OperationRemoveAccess, Kind: datastore.KindAccessData, ClientID: "client1", Result: datastore.ResultOK},
+			},
+			metric: "test_tokens_revoked_total",
+			out: `
+				# HELP test_tokens_revoked_total Number of access tokens revoked by client.
+				# TYPE test_tokens_revoked_total counter
+				test_tokens_revoked_total{client="client1"} 1
+			`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			c := NewCollector("test")
+			for _, ev := range tt.in {
+				c.EndOperation(context.Background(), ev)
+			}
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.out), tt.metric); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCollector_Duration(t *testing.T) {
+	c := NewCollector("test")
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.EndOperation(context.Background(), datastore.Event{
+		Op:       datastore.OperationLoadAccess,
+		Kind:     datastore.KindAccessData,
+		Duration: 20 * time.Millisecond,
+		Result:   datastore.ResultOK,
+	})
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "test_operation_duration_seconds" {
+			continue
+		}
+		h := f.GetMetric()[0].GetHistogram()
+		if h.GetSampleCount() != 1 || h.GetSampleSum() != 0.02 {
+			t.Errorf("\nwant: count 1, sum 0.02\n got: count %v, sum %v", h.GetSampleCount(), h.GetSampleSum())
+		}
+		return
+	}
+	t.Error("duration histogram is not gathered")
+}
+
+func TestCollector_ObserveSweep(t *testing.T) {
+	c := NewCollector("test")
+	c.ObserveSweep(datastore.KindAuditEvent, 3)
+	c.ObserveSweep(datastore.KindAuditEvent, 2)
+	c.ObserveSweep(datastore.KindAccessData, 0)
+
+	want := `
+		# HELP test_swept_total Number of expired entities removed by sweepers by kind.
+		# TYPE test_swept_total counter
+		test_swept_total{kind="access_data"} 0
+		test_swept_total{kind="audit_event"} 5
+	`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "test_swept_total"); err != nil {
+		t.Error(err)
+	}
+}
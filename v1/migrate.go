@@ -0,0 +1,133 @@
+package datastore
+
+import (
+	"context"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// migratePageSize is max number of tokens MigrateTokens queries at once.
+const migratePageSize = 500
+
+// MigrateTokens rewrites access tokens and refresh tokens stored in client with legacy schema into denormalized schema,
+// as WithDenormalizedTokens stores them, and returns number of rewritten tokens.
+// Access tokens are rewritten first, so that refresh tokens copy the rewritten access tokens.
+//
+// Each token is rewritten in a transaction, so tokens removed or rewritten concurrently are skipped, and MigrateTokens can be
+// run again after an error or while Storage serves requests. A legacy refresh token whose access token has been removed is kept
+// as it is, so that LoadRefresh still reports it as revoked. Access tokens whose authorize data has been removed are rewritten
+// without authorize data, as they are loaded.
+//
+// Run MigrateTokens only after every instance uses WithDenormalizedTokens: RemoveAccess of Storage without the option does not
+// remove the refresh token, which remains usable once it holds copy of the access token. After the migration,
+// tokens are loaded without reading authorize data and access data, and the authorize data of the tokens is no longer needed.
+func MigrateTokens(ctx context.Context, client datastore.Client) (int, error) {
+	accesses, err := migrateKind(ctx, client, KindAccessData, migrateAccess)
+	if err != nil {
+		return accesses, err
+	}
+	refreshes, err := migrateKind(ctx, client, KindRefresh, migrateRefresh)
+	return accesses + refreshes, err
+}
+
+// migrateKind calls migrate for each key of kind in pages of migratePageSize, and returns number of migrated entities.
+func migrateKind(ctx context.Context, client datastore.Client, kind string, migrate func(context.Context, datastore.Client, datastore.Key) (bool, error)) (int, error) {
+	q := client.NewQuery(kind).KeysOnly().Limit(migratePageSize)
+	migrated := 0
+	for {
+		it := client.Run(ctx, q)
+		n := 0
+		for {
+			key, err := it.Next(nil)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return migrated, err
+			}
+			n++
+			ok, err := migrate(ctx, client, key)
+			if err != nil {
+				return migrated, err
+			}
+			if ok {
+				migrated++
+			}
+		}
+		if n < migratePageSize {
+			return migrated, nil
+		}
+
+		cursor, err := it.Cursor()
+		if err != nil {
+			return migrated, err
+		}
+		q = q.Start(cursor)
+	}
+}
+
+// migrateAccess copies authorize data into the access token of key, and reports whether it is rewritten.
+func migrateAccess(ctx context.Context, client datastore.Client, key datastore.Key) (bool, error) {
+	var migrated bool
+	_, err := client.RunInTransaction(ctx, func(tx datastore.Transaction) error {
+		migrated = false
+		ad := new(AccessData)
+		if err := tx.Get(key, ad); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if ad.Denormalized {
+			return nil
+		}
+
+		var auth *AuthorizeData
+		if ad.AuthorizeCode != "" {
+			auth = new(AuthorizeData)
+			if err := tx.Get(client.NameKey(KindAuthorizeData, ad.AuthorizeCode, nil), auth); err == datastore.ErrNoSuchEntity {
+				auth = nil
+			} else if err != nil {
+				return err
+			}
+		}
+		ad.setAuthorize(auth)
+		if _, err := tx.Put(key, ad); err != nil {
+			return err
+		}
+		migrated = true
+		return nil
+	})
+	return migrated, err
+}
+
+// migrateRefresh copies the access token into the refresh token of key, and reports whether it is rewritten.
+func migrateRefresh(ctx context.Context, client datastore.Client, key datastore.Key) (bool, error) {
+	var migrated bool
+	_, err := client.RunInTransaction(ctx, func(tx datastore.Transaction) error {
+		migrated = false
+		ref := new(Refresh)
+		if err := tx.Get(key, ref); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if ref.Denormalized {
+			return nil
+		}
+
+		ad := new(AccessData)
+		if err := tx.Get(client.NameKey(KindAccessData, ref.AccessToken, nil), ad); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		ref.denormalize(ad)
+		if _, err := tx.Put(key, ref); err != nil {
+			return err
+		}
+		migrated = true
+		return nil
+	})
+	return migrated, err
+}
@@ -0,0 +1,108 @@
+package datastore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+
+	osindatastore "github.com/ryutah/osin-datastore/v1"
+	"github.com/ryutah/osin-datastore/v1/datastoretest"
+)
+
+func TestMigrateTokens(t *testing.T) {
+	ctx := context.Background()
+	client := datastoretest.NewClient()
+	legacy := osindatastore.NewStorageWithClient(ctx, client)
+	oc := &osindatastore.Client{ID: "client", Secret: "secret", RedirectUri: "http://localhost"}
+	if err := legacy.ClientStorage().Put(ctx, oc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens are stored with legacy schema: "code" keeps its authorize data, "exchanged" has had it removed by osin,
+	// and refresh token "revoked" has lost its access token.
+	auth := &osin.AuthorizeData{Client: oc, Code: "code", ExpiresIn: 600, Scope: "read", RedirectUri: oc.RedirectUri, State: "state", CreatedAt: time.Now()}
+	exchanged := &osin.AuthorizeData{Client: oc, Code: "exchanged", ExpiresIn: 600, Scope: "read", RedirectUri: oc.RedirectUri, CreatedAt: time.Now()}
+	accesses := []*osin.AccessData{
+		{Client: oc, AuthorizeData: auth, AccessToken: "token", RefreshToken: "refresh", ExpiresIn: 3600, Scope: "read", CreatedAt: time.Now()},
+		{Client: oc, AuthorizeData: exchanged, AccessToken: "exchanged-token", ExpiresIn: 3600, Scope: "read", CreatedAt: time.Now()},
+		{Client: oc, AccessToken: "removed-token", RefreshToken: "revoked", ExpiresIn: 3600, CreatedAt: time.Now()},
+	}
+	for _, a := range []*osin.AuthorizeData{auth, exchanged} {
+		if err := legacy.SaveAuthorize(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, a := range accesses {
+		if err := legacy.SaveAccess(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, remove := range []func() error{
+		func() error { return legacy.RemoveAuthorize("exchanged") },
+		func() error { return legacy.RemoveAccess("removed-token") },
+	} {
+		if err := remove(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// "token", "exchanged-token" and "refresh" are rewritten.
+	n, err := osindatastore.MigrateTokens(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("migrated\nwant: %v\n got: %v", 3, n)
+	}
+	if n, err := osindatastore.MigrateTokens(ctx, client); err != nil || n != 0 {
+		t.Errorf("second migration\nwant: 0, <nil>\n got: %v, %v", n, err)
+	}
+
+	// Migrated tokens are loaded without authorize data entity.
+	if err := legacy.RemoveAuthorize("code"); err != nil {
+		t.Fatal(err)
+	}
+	var ad osindatastore.AccessData
+	if err := client.Get(ctx, client.NameKey(osindatastore.KindAccessData, "exchanged-token", nil), &ad); err != nil {
+		t.Fatal(err)
+	}
+	if !ad.Denormalized || ad.AuthorizeCode != "" {
+		t.Errorf("access token without authorize data must be denormalized without code, got: %#v", ad)
+	}
+
+	storage := osindatastore.NewStorageWithClient(ctx, client, osindatastore.WithDenormalizedTokens())
+	got, err := storage.LoadAccess("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AuthorizeData == nil || got.AuthorizeData.Code != "code" || got.AuthorizeData.State != "state" || got.RefreshToken != "refresh" {
+		t.Errorf("LoadAccess of migrated token with authorize data, got: %#v", got)
+	}
+	got, err = storage.LoadRefresh("refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AccessToken != "token" || got.AuthorizeData == nil || got.AuthorizeData.Code != "code" {
+		t.Errorf("LoadRefresh of migrated token, got: %#v", got)
+	}
+	got, err = storage.LoadAccess("exchanged-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AuthorizeData != nil {
+		t.Errorf("LoadAccess of migrated token without authorize data, got: %#v", got.AuthorizeData)
+	}
+	if _, err := storage.LoadRefresh("revoked"); !errors.Is(err, osindatastore.ErrRevoked) {
+		t.Errorf("LoadRefresh of refresh token without access token\nwant: %#v\n got: %#v", osindatastore.ErrRevoked, err)
+	}
+	var ref osindatastore.Refresh
+	if err := client.Get(ctx, client.NameKey(osindatastore.KindRefresh, "revoked", nil), &ref); err != nil {
+		t.Fatal(err)
+	}
+	if ref.Denormalized {
+		t.Error("refresh token without access token must be kept with legacy schema")
+	}
+}
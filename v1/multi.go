@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"sync"
+
+	"go.mercari.io/datastore"
+)
+
+// Datastore limits number of entities in a single call.
+// Multi operations larger than the limits are split into chunks.
+var (
+	maxGetMultiSize    = 1000
+	maxDeleteMultiSize = 500
+	// maxTransactionSize is max number of clients written in a transaction.
+	// Every client is a root entity, and a transaction can write up to 25 entity groups.
+	maxTransactionSize = 25
+	// multiConcurrency is max number of chunks processed concurrently in a multi operation.
+	multiConcurrency = 4
+)
+
+// runChunks calls f for each chunk [start, end) of n items with up to size items,
+// running up to multiConcurrency chunks concurrently.
+// If n fits in a single chunk, runChunks returns the error of f as is.
+// Otherwise errors of chunks are aggregated into datastore.MultiError of length n:
+// elements of datastore.MultiError returned by f are placed at the items of the chunk,
+// and other error of f is set to every item of the chunk.
+func runChunks(n, size int, f func(start, end int) error) error {
+	if n <= size {
+		return f(0, n)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, multiConcurrency)
+		mu     sync.Mutex
+		errs   datastore.MultiError
+		failed bool
+	)
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(start, end int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			err := f(start, end)
+			if err == nil {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if errs == nil {
+				errs = make(datastore.MultiError, n)
+			}
+			failed = true
+			if merr, ok := err.(datastore.MultiError); ok && len(merr) == end-start {
+				copy(errs[start:end], merr)
+				return
+			}
+			for i := start; i < end; i++ {
+				errs[i] = err
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	if !failed {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,151 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+)
+
+func TestRunChunks(t *testing.T) {
+	errRPC := errors.New("rpc error")
+
+	type (
+		in struct {
+			n    int
+			size int
+			errs map[int]error
+		}
+
+		out struct {
+			chunks [][2]int
+			err    error
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "single chunk",
+			in:       in{n: 3, size: 3, errs: map[int]error{0: errRPC}},
+			out: out{
+				chunks: [][2]int{{0, 3}},
+				err:    errRPC,
+			},
+		},
+		{
+			testName: "multiple chunks",
+			in:       in{n: 5, size: 2},
+			out: out{
+				chunks: [][2]int{{0, 2}, {2, 4}, {4, 5}},
+			},
+		},
+		{
+			testName: "multiple chunks with errors",
+			in: in{n: 5, size: 2, errs: map[int]error{
+				0: datastore.MultiError{nil, datastore.ErrNoSuchEntity},
+				4: errRPC,
+			}},
+			out: out{
+				chunks: [][2]int{{0, 2}, {2, 4}, {4, 5}},
+				err:    datastore.MultiError{nil, datastore.ErrNoSuchEntity, nil, nil, errRPC},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				chunks [][2]int
+			)
+			err := runChunks(tt.in.n, tt.in.size, func(start, end int) error {
+				mu.Lock()
+				chunks = append(chunks, [2]int{start, end})
+				mu.Unlock()
+				return tt.in.errs[start]
+			})
+			sort.Slice(chunks, func(i, j int) bool { return chunks[i][0] < chunks[j][0] })
+
+			if !reflect.DeepEqual(tt.out.chunks, chunks) {
+				t.Errorf("chunks\nwant: %v\n got: %v", tt.out.chunks, chunks)
+			}
+			if !reflect.DeepEqual(tt.out.err, err) {
+				t.Errorf("return error\nwant: %#v\n got: %#v", tt.out.err, err)
+			}
+		})
+	}
+}
+
+func TestClientStorage_DeleteMulti_Chunked(t *testing.T) {
+	defer func(size int) { maxDeleteMultiSize = size }(maxDeleteMultiSize)
+	maxDeleteMultiSize = 2
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ids := []string{"id1", "id2", "id3"}
+	mockDS := NewMockClient(ctrl)
+	for _, id := range ids {
+		mockDS.EXPECT().NameKey(KindClient, id, gomock.Nil()).Return(&mockKey{kind: KindClient, name: id})
+	}
+	mockDS.EXPECT().DeleteMulti(gomock.Any(), []datastore.Key{
+		&mockKey{kind: KindClient, name: "id1"},
+		&mockKey{kind: KindClient, name: "id2"},
+	}).Return(nil)
+	mockDS.EXPECT().DeleteMulti(gomock.Any(), []datastore.Key{
+		&mockKey{kind: KindClient, name: "id3"},
+	}).Return(nil)
+
+	cr := &ClientStorage{client: mockDS}
+	if err := cr.DeleteMulti(context.Background(), ids); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientStorage_GetMultiPartial_Chunked(t *testing.T) {
+	defer func(size int) { maxGetMultiSize = size }(maxGetMultiSize)
+	maxGetMultiSize = 2
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	errRPC := errors.New("rpc error")
+	ids := []string{"id1", "id2", "id3"}
+	mockDS := NewMockClient(ctrl)
+	for _, id := range ids {
+		mockDS.EXPECT().NameKey(KindClient, id, gomock.Nil()).Return(&mockKey{kind: KindClient, name: id})
+	}
+	mockDS.EXPECT().GetMulti(gomock.Any(), []datastore.Key{
+		&mockKey{kind: KindClient, name: "id1"},
+		&mockKey{kind: KindClient, name: "id2"},
+	}, gomock.Any()).DoAndReturn(func(_ context.Context, _ []datastore.Key, dst interface{}) error {
+		dst.([]*Client)[0] = &Client{Secret: "secret1"}
+		return datastore.MultiError{nil, datastore.ErrNoSuchEntity}
+	})
+	mockDS.EXPECT().GetMulti(gomock.Any(), []datastore.Key{
+		&mockKey{kind: KindClient, name: "id3"},
+	}, gomock.Any()).Return(errRPC)
+
+	cr := &ClientStorage{client: mockDS}
+	got, err := cr.GetMultiPartial(context.Background(), ids)
+
+	wantErr := datastore.MultiError{nil, osin.ErrNotFound, errRPC}
+	if !reflect.DeepEqual(wantErr, err) {
+		t.Errorf("return error\nwant: %#v\n got: %#v", wantErr, err)
+	}
+	want := []*Client{{ID: "id1", Secret: "secret1"}, nil, nil}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("client\nwant %+v\n got %+v", want, got)
+	}
+}
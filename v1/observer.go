@@ -0,0 +1,137 @@
+package datastore
+
+import (
+	"context"
+	"time"
+)
+
+// Observer observes every operation of Storage and ClientStorage, e.g. to record traces and metrics.
+// Adapters for tracing and metrics libraries can be implemented outside of this package.
+// Implementations must be safe for concurrent use.
+type Observer interface {
+	// StartOperation is called before the operation runs, and returns context used by the operation.
+	// Tracing adapters can start a span in the returned context.
+	StartOperation(ctx context.Context, op Operation, kind, key string) context.Context
+	// EndOperation is called with the context returned by StartOperation when the operation ends.
+	EndOperation(ctx context.Context, ev Event)
+}
+
+// ObserverFunc is Observer which only receives ended operations, e.g. to record metrics.
+type ObserverFunc func(ctx context.Context, ev Event)
+
+// StartOperation returns ctx as is.
+func (f ObserverFunc) StartOperation(ctx context.Context, _ Operation, _, _ string) context.Context {
+	return ctx
+}
+
+// EndOperation calls f.
+func (f ObserverFunc) EndOperation(ctx context.Context, ev Event) {
+	f(ctx, ev)
+}
+
+// Result is result of an operation.
+type Result string
+
+// Results of operations.
+const (
+	ResultOK       Result = "ok"
+	ResultNotFound Result = "not_found"
+	ResultError    Result = "error"
+)
+
+func resultOf(err error) Result {
+	switch {
+	case err == nil:
+		return ResultOK
+	case isNotFound(err):
+		return ResultNotFound
+	default:
+		return ResultError
+	}
+}
+
+// Event is an ended operation.
+type Event struct {
+	Op   Operation
+	Kind string
+	// Key is TokenFingerprint of key of the entity, not to leak tokens. It is empty for operations of multiple entities.
+	Key string
+	// ClientID is ID of the client of the entity, or empty if the operation does not know it without extra reads.
+	ClientID string
+	// Revoked reports whether RemoveAccess has removed existing access token by revocation.
+	// Storage with observer reads the token in RemoveAccess to tell it, see WithObserver.
+	// It is false for missing token, and for the old token removed by osin when the token is refreshed through a view of Storage.
+	Revoked  bool
+	Start    time.Time
+	Duration time.Duration
+	Result   Result
+	Err      error
+}
+
+// operation is an operation in progress.
+// It costs nothing besides cancel of the context when observer is not set.
+type operation struct {
+	observer Observer
+	ctx      context.Context
+	cancel   context.CancelFunc
+	ev       Event
+}
+
+func noCancel() {}
+
+func startOperation(ctx context.Context, cancel context.CancelFunc, observer Observer, op Operation, kind, key string) (context.Context, operation) {
+	o := operation{observer: observer, cancel: cancel}
+	if observer == nil {
+		return ctx, o
+	}
+
+	o.ev = Event{
+		Op:    op,
+		Kind:  kind,
+		Start: timeNow(),
+	}
+	if key != "" {
+		o.ev.Key = redactKey(key)
+	}
+	o.ctx = observer.StartOperation(ctx, op, kind, o.ev.Key)
+	return o.ctx, o
+}
+
+// setClient sets ID of the client of the entity to the event.
+func (o *operation) setClient(id string) {
+	o.ev.ClientID = id
+}
+
+// setRevoked marks the event as revocation of existing token.
+func (o *operation) setRevoked() {
+	o.ev.Revoked = true
+}
+
+func (o *operation) end(err error) {
+	o.cancel()
+	if o.observer == nil {
+		return
+	}
+	o.ev.Duration = timeNow().Sub(o.ev.Start)
+	o.ev.Result = resultOf(err)
+	o.ev.Err = err
+	o.observer.EndOperation(o.ctx, o.ev)
+}
+
+// startOperation starts op of Storage with timeout of op, and notifies observer of d if it is set.
+func (d *Storage) startOperation(op Operation, kind, key string) (context.Context, operation) {
+	ctx, cancel := d.operationContext(op)
+	return startOperation(ctx, cancel, d.observer, op, kind, key)
+}
+
+// startOperation starts op of ClientStorage, and notifies observer of cl if it is set.
+func (cl *ClientStorage) startOperation(ctx context.Context, op Operation, key string) (context.Context, operation) {
+	return startOperation(ctx, noCancel, cl.observer, op, KindClient, key)
+}
+
+// WithObserver returns ClientStorage which shares datastore client and cache with cl and notifies observer of every operation.
+func (cl *ClientStorage) WithObserver(observer Observer) *ClientStorage {
+	c := *cl
+	c.observer = observer
+	return &c
+}
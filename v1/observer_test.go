@@ -0,0 +1,244 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+)
+
+type observerContextKey struct{}
+
+// recordingObserver records ended operations, and marks context of each operation with its name.
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recordingObserver) StartOperation(ctx context.Context, op Operation, _, _ string) context.Context {
+	return context.WithValue(ctx, observerContextKey{}, op)
+}
+
+func (r *recordingObserver) EndOperation(ctx context.Context, ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ctx.Value(observerContextKey{}) != ev.Op {
+		panic("EndOperation must receive context returned by StartOperation")
+	}
+	r.events = append(r.events, ev)
+}
+
+func TestStorage_GetClient_Observer(t *testing.T) {
+	errRPC := errors.New("rpc error")
+
+	type (
+		returns struct {
+			client *Client
+			err    error
+		}
+
+		out struct {
+			result Result
+			err    error
+		}
+	)
+
+	tests := []struct {
+		testName string
+		returns  returns
+		out      out
+	}{
+		{
+			testName: "found",
+			returns:  returns{client: &Client{ID: "client-id"}},
+			out:      out{result: ResultOK},
+		},
+		{
+			testName: "not found",
+			returns:  returns{err: datastore.ErrNoSuchEntity},
+			out:      out{result: ResultNotFound},
+		},
+		{
+			testName: "error",
+			returns:  returns{err: errRPC},
+			out:      out{result: ResultError},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			now := time.Now()
+			defer func(f func() time.Time) { timeNow = f }(timeNow)
+			timeNow = func() time.Time { return now }
+
+			mch := NewMockClientGetter(ctrl)
+			mch.EXPECT().Get(gomock.Any(), "client-id").DoAndReturn(func(ctx context.Context, _ string) (*Client, error) {
+				if ctx.Value(observerContextKey{}) != OperationGetClient {
+					t.Error("context returned by StartOperation must reach handlers")
+				}
+				timeNow = func() time.Time { return now.Add(time.Second) }
+				return tt.returns.client, tt.returns.err
+			})
+
+			observer := new(recordingObserver)
+			storage := NewStorageWithHandlers(context.Background(), Handlers{Client: mch}, WithObserver(observer))
+			_, err := storage.GetClient("client-id")
+
+			if len(observer.events) != 1 {
+				t.Fatalf("want 1 event, got: %#v", observer.events)
+			}
+			ev := observer.events[0]
+			want := Event{
+				Op:       OperationGetClient,
+				Kind:     KindClient,
+				Key:      TokenFingerprint("client-id"),
+				ClientID: "client-id",
+				Start:    now,
+				Duration: time.Second,
+				Result:   tt.out.result,
+				Err:      err,
+			}
+			if !reflect.DeepEqual(want, ev) {
+				t.Errorf("\nwant: %#v\n got: %#v", want, ev)
+			}
+		})
+	}
+}
+
+func TestStorage_ClientStorage_Observer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	key := &mockKey{kind: KindClient, name: "client-id"}
+	mockDS := NewMockClient(ctrl)
+	mockDS.EXPECT().NameKey(KindClient, "client-id", gomock.Nil()).Return(key)
+	mockDS.EXPECT().Get(gomock.Any(), key, gomock.Any()).Return(datastore.ErrNoSuchEntity)
+
+	observer := new(recordingObserver)
+	storage := NewStorageWithClient(context.Background(), mockDS, WithObserver(observer))
+	if _, err := storage.ClientStorage().Get(context.Background(), "client-id"); err != datastore.ErrNoSuchEntity {
+		t.Errorf("return error\nwant: %#v\n got: %#v", datastore.ErrNoSuchEntity, err)
+	}
+
+	if len(observer.events) != 1 {
+		t.Fatalf("want 1 event, got: %#v", observer.events)
+	}
+	ev := observer.events[0]
+	if ev.Op != OperationClientGet || ev.Kind != KindClient || ev.Key != TokenFingerprint("client-id") || ev.Result != ResultNotFound {
+		t.Errorf("unexpected event: %#v", ev)
+	}
+}
+
+func TestObserverFunc(t *testing.T) {
+	var got []Operation
+	observer := ObserverFunc(func(_ context.Context, ev Event) {
+		got = append(got, ev.Op)
+	})
+
+	ctx, o := startOperation(context.Background(), noCancel, observer, OperationRemoveAccess, KindAccessData, "token")
+	if ctx != context.Background() {
+		t.Error("ObserverFunc must not change context")
+	}
+	o.end(nil)
+
+	if want := []Operation{OperationRemoveAccess}; !reflect.DeepEqual(want, got) {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+func TestStartOperation_NoObserver(t *testing.T) {
+	ctx := context.Background()
+	allocs := testing.AllocsPerRun(100, func() {
+		_, o := startOperation(ctx, noCancel, nil, OperationLoadAccess, KindAccessData, "token")
+		o.end(nil)
+	})
+	if allocs != 0 {
+		t.Errorf("operation without observer must not allocate, got: %v allocs", allocs)
+	}
+}
+
+func TestStorage_RemoveAccess_ObserverRevoked(t *testing.T) {
+	type (
+		in struct {
+			refreshed bool
+		}
+
+		returns struct {
+			access *AccessData
+			err    error
+		}
+
+		out struct {
+			revoked  bool
+			clientID string
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		returns  returns
+		out      out
+	}{
+		{
+			testName: "existing token",
+			returns:  returns{access: &AccessData{AccessToken: "token", ClientKey: "client"}},
+			out:      out{revoked: true, clientID: "client"},
+		},
+		{
+			testName: "missing token",
+			returns:  returns{err: datastore.ErrNoSuchEntity},
+			out:      out{revoked: false},
+		},
+		{
+			testName: "token refreshed through the view",
+			in:       in{refreshed: true},
+			returns:  returns{access: &AccessData{AccessToken: "token", ClientKey: "client"}},
+			out:      out{revoked: false, clientID: "client"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mad := NewMockAccessDataHandler(ctrl)
+			observer := new(recordingObserver)
+			storage := NewStorageWithHandlers(context.Background(), Handlers{AccessData: mad}, WithObserver(observer)).WithContext(context.Background())
+
+			if tt.in.refreshed {
+				mad.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+				err := storage.SaveAccess(&osin.AccessData{
+					Client:      &Client{ID: "client"},
+					AccessToken: "new_token",
+					AccessData:  &osin.AccessData{AccessToken: "token"},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			mad.EXPECT().Get(gomock.Any(), "token").Return(tt.returns.access, tt.returns.err)
+			mad.EXPECT().Delete(gomock.Any(), "token").Return(nil)
+
+			if err := storage.RemoveAccess("token"); err != nil {
+				t.Fatal(err)
+			}
+			ev := observer.events[len(observer.events)-1]
+			if ev.Op != OperationRemoveAccess || ev.Revoked != tt.out.revoked || ev.ClientID != tt.out.clientID {
+				t.Errorf("\nwant: revoked %v of %q\n got: %#v", tt.out.revoked, tt.out.clientID, ev)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package datastore
+
+import "context"
+
+// Operation is name of osin.Storage method which Storage runs, or method of ClientStorage.
+type Operation string
+
+// Operations of Storage.
+const (
+	OperationGetClient       Operation = "GetClient"
+	OperationSaveAuthorize   Operation = "SaveAuthorize"
+	OperationLoadAuthorize   Operation = "LoadAuthorize"
+	OperationRemoveAuthorize Operation = "RemoveAuthorize"
+	OperationSaveAccess      Operation = "SaveAccess"
+	OperationLoadAccess      Operation = "LoadAccess"
+	OperationRemoveAccess    Operation = "RemoveAccess"
+	OperationLoadRefresh     Operation = "LoadRefresh"
+	OperationRemoveRefresh   Operation = "RemoveRefresh"
+)
+
+// Operations of ClientStorage.
+const (
+	OperationClientPut             Operation = "ClientStorage.Put"
+	OperationClientPutMulti        Operation = "ClientStorage.PutMulti"
+	OperationClientInsert          Operation = "ClientStorage.Insert"
+	OperationClientUpdate          Operation = "ClientStorage.Update"
+	OperationClientGet             Operation = "ClientStorage.Get"
+	OperationClientGetMulti        Operation = "ClientStorage.GetMulti"
+	OperationClientGetMultiPartial Operation = "ClientStorage.GetMultiPartial"
+	OperationClientDelete          Operation = "ClientStorage.Delete"
+	OperationClientDeleteMulti     Operation = "ClientStorage.DeleteMulti"
+	// OperationClientStamp is write of the version stamp after writes of clients through cache.
+	// Its failure does not fail the write of clients, so it is reported only to observer.
+	OperationClientStamp Operation = "ClientStorage.Stamp"
+)
+
+// operationContext returns context for op derived from context of d, with timeout of op if it is set.
+// Every Datastore call of op uses the returned context, so deadline and trace of the request reach all of them.
+// Loads nested in op (e.g. the client loaded by LoadAccess) share the context and timeout of op.
+func (d *Storage) operationContext(op Operation) (context.Context, context.CancelFunc) {
+	timeout, ok := d.timeouts[op]
+	if !ok {
+		timeout = d.timeout
+	}
+	if timeout <= 0 {
+		return d.ctx, func() {}
+	}
+	return context.WithTimeout(d.ctx, timeout)
+}
@@ -0,0 +1,132 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+)
+
+func TestStorage_operationContext(t *testing.T) {
+	type (
+		in struct {
+			opts []StorageOption
+			op   Operation
+		}
+
+		out struct {
+			timeout time.Duration
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "no timeout",
+			in:       in{op: OperationLoadAccess},
+		},
+		{
+			testName: "timeout",
+			in: in{
+				opts: []StorageOption{WithTimeout(time.Second)},
+				op:   OperationLoadAccess,
+			},
+			out: out{timeout: time.Second},
+		},
+		{
+			testName: "operation timeout",
+			in: in{
+				opts: []StorageOption{WithTimeout(time.Second), WithOperationTimeout(OperationLoadAccess, time.Minute)},
+				op:   OperationLoadAccess,
+			},
+			out: out{timeout: time.Minute},
+		},
+		{
+			testName: "timeout of other operation",
+			in: in{
+				opts: []StorageOption{WithTimeout(time.Second), WithOperationTimeout(OperationGetClient, time.Minute)},
+				op:   OperationLoadAccess,
+			},
+			out: out{timeout: time.Second},
+		},
+		{
+			testName: "operation without timeout",
+			in: in{
+				opts: []StorageOption{WithTimeout(time.Second), WithOperationTimeout(OperationLoadAccess, 0)},
+				op:   OperationLoadAccess,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			storage := NewStorageWithHandlers(context.Background(), Handlers{}, tt.in.opts...)
+
+			start := time.Now()
+			ctx, cancel := storage.operationContext(tt.in.op)
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if tt.out.timeout == 0 {
+				if ok {
+					t.Errorf("want no deadline, got: %v", deadline)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("want deadline")
+			}
+			if got := deadline.Sub(start); got < tt.out.timeout || got > tt.out.timeout+time.Second {
+				t.Errorf("timeout\nwant: %v\n got: %v", tt.out.timeout, got)
+			}
+		})
+	}
+}
+
+type operationContextKey struct{}
+
+func TestStorage_LoadAccess_PropagatesContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach = NewMockAccessDataHandler(ctrl)
+		mch  = NewMockClientGetter(ctrl)
+		mauh = NewMockAuthorizeDataHandler(ctrl)
+	)
+	checkContext := func(ctx context.Context) {
+		if ctx.Value(operationContextKey{}) != "request" {
+			t.Error("context of the request must reach handlers")
+		}
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("timeout of the operation must reach handlers")
+		}
+	}
+	mach.EXPECT().Get(gomock.Any(), "token").DoAndReturn(func(ctx context.Context, _ string) (*AccessData, error) {
+		checkContext(ctx)
+		return &AccessData{AccessToken: "token", ClientKey: "client", AuthorizeCode: "auth"}, nil
+	})
+	mauh.EXPECT().Get(gomock.Any(), "auth").DoAndReturn(func(ctx context.Context, _ string) (*AuthorizeData, error) {
+		checkContext(ctx)
+		return &AuthorizeData{Code: "auth", ClientKey: "client"}, nil
+	})
+	mch.EXPECT().Get(gomock.Any(), "client").DoAndReturn(func(ctx context.Context, _ string) (*Client, error) {
+		checkContext(ctx)
+		return &Client{ID: "client"}, nil
+	})
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{
+		Client:        mch,
+		AuthorizeData: mauh,
+		AccessData:    mach,
+	}, WithOperationTimeout(OperationLoadAccess, time.Minute))
+
+	ctx := context.WithValue(context.Background(), operationContextKey{}, "request")
+	if _, err := storage.WithContext(ctx).LoadAccess("token"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+package datastore
+
+import "time"
+
+// StorageOption configures Storage created by NewStorageWithClient or NewStorageWithHandlers.
+type StorageOption func(*Storage)
+
+func (d *Storage) apply(opts []StorageOption) {
+	for _, opt := range opts {
+		opt(d)
+	}
+	// Handlers are wrapped after other options, which may replace them.
+	if d.retryPolicy != nil {
+		d.wrapRetry(*d.retryPolicy)
+	}
+}
+
+// WithDenormalizedTokens makes Storage store copy of authorize data in access token entity,
+// and copy of access data in refresh token entity.
+// Then LoadAccess and LoadRefresh need only one token entity read besides the client, instead of following each entity.
+//
+// Entities stored with legacy schema are still loaded by following each entity,
+// so the option can be enabled on existing data. Legacy entities are replaced as tokens are reissued,
+// and MigrateTokens rewrites the rest once every instance uses the option.
+func WithDenormalizedTokens() StorageOption {
+	return func(d *Storage) {
+		d.denormalized = true
+	}
+}
+
+// WithClientCache makes Storage cache clients in cache, see ClientStorage.WithCache for stampInterval.
+// The option takes effect only if clients are stored by ClientStorage,
+// and writes must go through Storage.ClientStorage to invalidate cache.
+func WithClientCache(cache ClientCache, stampInterval time.Duration) StorageOption {
+	return func(d *Storage) {
+		if cs, ok := d.clientGetter.(*ClientStorage); ok {
+			d.clientGetter = cs.WithCache(cache, stampInterval)
+		}
+	}
+}
+
+// WithAccessCache makes LoadAccess read through cache.
+// Each token is cached until it expires or maxTTL passes, whichever comes first, and RemoveAccess evicts it.
+// With in-process cache, a token removed on other instance can be served until maxTTL passes,
+// so keep maxTTL short or use external cache shared between instances. Zero maxTTL means no limit besides expiry of tokens.
+func WithAccessCache(cache AccessCache, maxTTL time.Duration) StorageOption {
+	return func(d *Storage) {
+		d.accessCache = cache
+		d.accessCacheTTL = maxTTL
+	}
+}
+
+// WithRetryPolicy makes Storage retry Datastore operations failed with transient error according to policy.
+// Only keyed reads, overwrites and deletes done by the handlers are retried, since they are safe to repeat.
+// Transactions of WithOutbox are retried as a whole, since they read the tokens again. If the commit succeeds but reports an error,
+// the retry writes the events again, which publishers must tolerate as any redelivery of the outbox.
+// Transactions of ClientStorage are not retried, and conflict of them is reported as ErrConflict.
+func WithRetryPolicy(policy RetryPolicy) StorageOption {
+	return func(d *Storage) {
+		d.retryPolicy = &policy
+	}
+}
+
+// WithTimeout sets timeout of every operation of Storage.
+// The deadline of the context bound by WithContext still applies if it comes earlier.
+func WithTimeout(timeout time.Duration) StorageOption {
+	return func(d *Storage) {
+		d.timeout = timeout
+	}
+}
+
+// WithOperationTimeout sets timeout of op, which takes precedence over WithTimeout.
+// Zero timeout means op has no timeout of its own even if WithTimeout is set.
+func WithOperationTimeout(op Operation, timeout time.Duration) StorageOption {
+	return func(d *Storage) {
+		timeouts := make(map[Operation]time.Duration, len(d.timeouts)+1)
+		for o, t := range d.timeouts {
+			timeouts[o] = t
+		}
+		timeouts[op] = timeout
+		d.timeouts = timeouts
+	}
+}
+
+// WithObserver makes Storage notify observer of every operation.
+// If clients are stored by ClientStorage, operations of Storage.ClientStorage are observed as well.
+// To report Event.Revoked, RemoveAccess reads the token before deleting it, since delete of Datastore does not tell whether
+// the entity existed. This adds a Get to every RemoveAccess, unless the token is read anyway with WithDenormalizedTokens,
+// WithAudit or WithOutbox.
+func WithObserver(observer Observer) StorageOption {
+	return func(d *Storage) {
+		d.observer = observer
+		if cs, ok := d.clientGetter.(*ClientStorage); ok {
+			d.clientGetter = cs.WithObserver(observer)
+		}
+	}
+}
+
+// WithAudit makes Storage record grant lifecycle events to KindAuditEvent with the datastore client of the storage.
+// Use Storage.AuditStorage to query and purge the events.
+// The option takes effect only if the storage has datastore client. Use Handlers.Audit for storage created by NewStorageWithHandlers.
+// With WithOutbox, events of SaveAccess and RemoveAccess are written in the same transaction as the tokens.
+// Other events are recorded after the change of the grant, so the change is kept without its events if recording them fails.
+func WithAudit() StorageOption {
+	return func(d *Storage) {
+		if d.client != nil {
+			d.auditHandler = NewAuditStorage(d.client)
+			d.auditWithClient = true
+		}
+	}
+}
+
+// WithOutbox makes SaveAccess, RemoveAccess and RemoveRefresh write token lifecycle events to KindOutboxEvent
+// in the same transaction as the change of tokens. Use OutboxPoller to publish the events.
+// The tokens are written with the datastore client of the storage instead of the handlers,
+// so the option takes effect only if the storage has datastore client.
+func WithOutbox() StorageOption {
+	return func(d *Storage) {
+		if d.client != nil {
+			d.outbox = true
+		}
+	}
+}
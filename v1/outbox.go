@@ -0,0 +1,270 @@
+package datastore
+
+import (
+	"context"
+	"time"
+
+	"go.mercari.io/datastore"
+)
+
+// KindOutboxEvent is datastore kind name of token lifecycle events waiting to be published.
+const KindOutboxEvent = "outbox_event"
+
+// Default values of OutboxPoller used for zero fields.
+const (
+	DefaultOutboxBatchSize = 100
+	DefaultOutboxInterval  = time.Second
+)
+
+// TokenEventType is type of token lifecycle event published through outbox.
+type TokenEventType string
+
+// Types of token lifecycle events.
+const (
+	// TokenEventIssued is written by SaveAccess of the token issued by grants other than refresh token.
+	TokenEventIssued TokenEventType = "token_issued"
+	// TokenEventRefreshed is written by SaveAccess of the token issued for refresh token.
+	TokenEventRefreshed TokenEventType = "token_refreshed"
+	// TokenEventRevoked is written by RemoveAccess of existing token.
+	TokenEventRevoked TokenEventType = "token_revoked"
+	// TokenEventRefreshRevoked is written by RemoveRefresh of existing refresh token.
+	TokenEventRefreshRevoked TokenEventType = "refresh_token_revoked"
+	// TokenEventRotated is written instead of TokenEventRevoked by RemoveAccess of the token replaced by refresh,
+	// which osin calls on the view which has saved the refreshed token.
+	TokenEventRotated TokenEventType = "token_rotated"
+	// TokenEventRefreshRotated is written instead of TokenEventRefreshRevoked by RemoveRefresh of the refresh token
+	// of the token replaced by refresh, which osin calls on the view as well.
+	TokenEventRefreshRotated TokenEventType = "refresh_token_rotated"
+)
+
+// TokenEvent is datastore entity of token lifecycle event in outbox.
+// Datastore allocates ID of the entity, which is used as Datastore's key.
+// Token is recorded only as its fingerprint, see TokenFingerprint.
+// ClientID and Subject are empty if they are not stored with the token (e.g. legacy refresh token).
+type TokenEvent struct {
+	ID               int64          `json:"id,omitempty" datastore:"-"`
+	Type             TokenEventType `json:"type,omitempty" datastore:",noindex"`
+	ClientID         string         `json:"client_id,omitempty" datastore:",noindex"`
+	Subject          string         `json:"subject,omitempty" datastore:",noindex"`
+	TokenFingerprint string         `json:"token_fingerprint,omitempty" datastore:",noindex"`
+	CreatedAt        time.Time      `json:"created_at,omitempty"`
+}
+
+func newTokenEvent(typ TokenEventType, clientID, subject, token string) *TokenEvent {
+	return &TokenEvent{
+		Type:             typ,
+		ClientID:         clientID,
+		Subject:          subject,
+		TokenFingerprint: TokenFingerprint(token),
+		CreatedAt:        timeNow(),
+	}
+}
+
+// runInTransaction runs f in a transaction of the datastore client of d, in the context of the operation,
+// so that its timeout applies. The transaction is retried as a whole according to the retry policy of d,
+// and f reads the tokens again in each attempt.
+func (d *Storage) runInTransaction(ctx context.Context, f func(tx datastore.Transaction) error) error {
+	run := func() error {
+		_, err := d.client.RunInTransaction(ctx, f)
+		return err
+	}
+	if d.retryPolicy == nil {
+		return run()
+	}
+	return d.retryPolicy.do(ctx, run)
+}
+
+// Methods below write tokens and their events in a transaction, used by Storage with outbox.
+// Audit events are written in the transaction as well if auditInTransaction reports true.
+
+func (d *Storage) saveAccessWithOutbox(ctx context.Context, ad *AccessData, ref *Refresh, ev *TokenEvent, audits []*AuditEvent) error {
+	err := d.runInTransaction(ctx, func(tx datastore.Transaction) error {
+		if _, err := tx.Put(d.client.NameKey(KindAccessData, ad.AccessToken, nil), ad); err != nil {
+			return err
+		}
+		if ref != nil {
+			if _, err := tx.Put(d.client.NameKey(KindRefresh, ref.RefreshToken, nil), ref); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Put(d.client.IncompleteKey(KindOutboxEvent, nil), ev); err != nil {
+			return err
+		}
+		return d.putAuditEvents(tx, audits)
+	})
+	if err != nil {
+		return newError(OperationSaveAccess, KindAccessData, ad.AccessToken, err)
+	}
+	return nil
+}
+
+// removeAccessWithOutbox removes the access token, and returns the removed one or nil if it does not exist.
+// The event is TokenEventRotated if the token is rotated, or TokenEventRevoked otherwise.
+// Audit event of auditType is written for the removed token unless auditType is empty.
+func (d *Storage) removeAccessWithOutbox(ctx context.Context, token string, rotated bool, auditType AuditEventType) (*AccessData, error) {
+	var removed *AccessData
+	err := d.runInTransaction(ctx, func(tx datastore.Transaction) error {
+		removed = nil
+		key := d.client.NameKey(KindAccessData, token, nil)
+		ad := new(AccessData)
+		if err := tx.Get(key, ad); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		ad.AccessToken = token
+
+		if d.denormalized && ad.RefreshToken != "" {
+			if err := tx.Delete(d.client.NameKey(KindRefresh, ad.RefreshToken, nil)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(key); err != nil {
+			return err
+		}
+		typ := TokenEventRevoked
+		if rotated {
+			typ = TokenEventRotated
+		}
+		ev := newTokenEvent(typ, ad.ClientKey, ad.UserData, token)
+		if _, err := tx.Put(d.client.IncompleteKey(KindOutboxEvent, nil), ev); err != nil {
+			return err
+		}
+		if auditType != "" {
+			if err := d.putAuditEvents(tx, []*AuditEvent{NewAuditEvent(auditType, ad.ClientKey, ad.UserData, ad.Scope, token)}); err != nil {
+				return err
+			}
+		}
+		removed = ad
+		return nil
+	})
+	if err != nil {
+		return nil, newError(OperationRemoveAccess, KindAccessData, token, err)
+	}
+	return removed, nil
+}
+
+// removeRefreshWithOutbox removes the refresh token. The event is TokenEventRefreshRotated if the access token
+// of the refresh token has been refreshed through the view, or TokenEventRefreshRevoked otherwise.
+func (d *Storage) removeRefreshWithOutbox(ctx context.Context, token string) error {
+	err := d.runInTransaction(ctx, func(tx datastore.Transaction) error {
+		key := d.client.NameKey(KindRefresh, token, nil)
+		ref := new(Refresh)
+		if err := tx.Get(key, ref); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(key); err != nil {
+			return err
+		}
+		typ := TokenEventRefreshRevoked
+		if d.rotated != nil && d.rotated.has(ref.AccessToken) {
+			typ = TokenEventRefreshRotated
+		}
+		ev := newTokenEvent(typ, ref.Access.ClientKey, ref.Access.UserData, token)
+		_, err := tx.Put(d.client.IncompleteKey(KindOutboxEvent, nil), ev)
+		return err
+	})
+	if err != nil {
+		return newError(OperationRemoveRefresh, KindRefresh, token, err)
+	}
+	return nil
+}
+
+// Publisher publishes event to other services.
+// Publisher must be idempotent for the event, since it may receive the same event again.
+type Publisher func(ctx context.Context, ev *TokenEvent) error
+
+// OutboxPoller delivers token lifecycle events in outbox to Publisher at least once.
+// Events are removed from outbox after they are published, and redelivered if publish or removal fails.
+// Events are delivered roughly in order of CreatedAt, since queries of Datastore are eventually consistent.
+// Multiple pollers can run concurrently, in which case an event may be delivered by each of them.
+type OutboxPoller struct {
+	// BatchSize is max number of events delivered by a Poll. Zero means DefaultOutboxBatchSize.
+	BatchSize int
+	// Interval is wait between Polls of Run which do not fill a batch. Zero means DefaultOutboxInterval.
+	Interval time.Duration
+	// OnError is called with error of Poll in Run, if it is set. Run polls again after Interval anyway.
+	OnError func(err error)
+
+	client  datastore.Client
+	publish Publisher
+}
+
+// NewOutboxPoller creates OutboxPoller which delivers events in outbox of client to publish.
+func NewOutboxPoller(client datastore.Client, publish Publisher) *OutboxPoller {
+	return &OutboxPoller{
+		client:  client,
+		publish: publish,
+	}
+}
+
+func (p *OutboxPoller) batchSize() int {
+	if p.BatchSize <= 0 {
+		return DefaultOutboxBatchSize
+	}
+	return p.BatchSize
+}
+
+// Poll delivers the oldest events in outbox, up to BatchSize, and returns number of delivered events.
+// Poll stops at the first event which publish fails, and the event and the following ones are delivered by the next Poll.
+func (p *OutboxPoller) Poll(ctx context.Context) (int, error) {
+	q := p.client.NewQuery(KindOutboxEvent).Order("CreatedAt").Limit(p.batchSize())
+	var events []*TokenEvent
+	keys, err := p.client.GetAll(ctx, q, &events)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	var perr error
+	for i, ev := range events {
+		ev.ID = keys[i].ID()
+		if perr = p.publish(ctx, ev); perr != nil {
+			break
+		}
+		published++
+	}
+	if published == 0 {
+		return 0, perr
+	}
+	err = runChunks(published, maxDeleteMultiSize, func(start, end int) error {
+		return p.client.DeleteMulti(ctx, keys[start:end])
+	})
+	if err != nil {
+		return published, err
+	}
+	return published, perr
+}
+
+// Run polls outbox until ctx is done, and returns error of ctx.
+// Run polls again without waiting while events fill a batch.
+func (p *OutboxPoller) Run(ctx context.Context) error {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultOutboxInterval
+	}
+
+	for {
+		n, err := p.Poll(ctx)
+		if err != nil && p.OnError != nil && ctx.Err() == nil {
+			p.OnError(err)
+		}
+		if err == nil && n == p.batchSize() {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
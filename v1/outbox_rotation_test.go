@@ -0,0 +1,67 @@
+package datastore_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+
+	osindatastore "github.com/ryutah/osin-datastore/v1"
+	"github.com/ryutah/osin-datastore/v1/datastoretest"
+)
+
+func TestStorage_Outbox_RefreshRotation(t *testing.T) {
+	ctx := context.Background()
+	client := datastoretest.NewClient()
+	storage := osindatastore.NewStorageWithClient(ctx, client, osindatastore.WithDenormalizedTokens(), osindatastore.WithOutbox())
+	oc := &osindatastore.Client{ID: "client", Secret: "secret"}
+
+	old := &osin.AccessData{Client: oc, AccessToken: "token1", RefreshToken: "refresh1", ExpiresIn: 3600, CreatedAt: time.Now()}
+	if err := storage.Clone().SaveAccess(old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refresh exchange, in the order osin.Server.FinishAccessRequest calls the storage of a response.
+	exchange := storage.Clone()
+	refreshed := &osin.AccessData{Client: oc, AccessData: old, AccessToken: "token2", RefreshToken: "refresh2", ExpiresIn: 3600, CreatedAt: time.Now()}
+	if err := exchange.SaveAccess(refreshed); err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange.RemoveRefresh(old.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange.RemoveAccess(old.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revocation of the refreshed tokens through another response.
+	revoke := storage.Clone()
+	if err := revoke.RemoveRefresh(refreshed.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := revoke.RemoveAccess(refreshed.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	// Events of each token in order of CreatedAt, in which OutboxPoller delivers them.
+	published := map[string][]osindatastore.TokenEventType{}
+	poller := osindatastore.NewOutboxPoller(client, func(_ context.Context, ev *osindatastore.TokenEvent) error {
+		published[ev.TokenFingerprint] = append(published[ev.TokenFingerprint], ev.Type)
+		return nil
+	})
+	if _, err := poller.Poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string][]osindatastore.TokenEventType{
+		osindatastore.TokenFingerprint("token1"):   {osindatastore.TokenEventIssued, osindatastore.TokenEventRotated},
+		osindatastore.TokenFingerprint("refresh1"): {osindatastore.TokenEventRefreshRotated},
+		osindatastore.TokenFingerprint("token2"):   {osindatastore.TokenEventRefreshed, osindatastore.TokenEventRevoked},
+		osindatastore.TokenFingerprint("refresh2"): {osindatastore.TokenEventRefreshRevoked},
+	}
+	if !reflect.DeepEqual(want, published) {
+		t.Errorf("published events\nwant: %v\n got: %v", want, published)
+	}
+}
@@ -0,0 +1,409 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+)
+
+// expectTransaction makes mockDS run transaction function with mockTx.
+func expectTransaction(mockDS *MockClient, mockTx *MockTransaction) {
+	mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, f func(datastore.Transaction) error) (datastore.Commit, error) {
+		return nil, f(mockTx)
+	})
+}
+
+func TestStorage_SaveAccess_Outbox(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		testName string
+		in       *osin.AccessData
+		out      *TokenEvent
+	}{
+		{
+			testName: "issued",
+			in:       &osin.AccessData{Client: &Client{ID: "client"}, AccessToken: "token", UserData: "user"},
+			out:      &TokenEvent{Type: TokenEventIssued, ClientID: "client", Subject: "user", TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+		},
+		{
+			testName: "refreshed",
+			in: &osin.AccessData{
+				Client:       &Client{ID: "client"},
+				AccessData:   &osin.AccessData{AccessToken: "old"},
+				AccessToken:  "token",
+				RefreshToken: "refresh",
+				UserData:     "user",
+			},
+			out: &TokenEvent{Type: TokenEventRefreshed, ClientID: "client", Subject: "user", TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			defer func(f func() time.Time) { timeNow = f }(timeNow)
+			timeNow = func() time.Time { return now }
+
+			var (
+				accessKey = &mockKey{kind: KindAccessData, name: tt.in.AccessToken}
+				outboxKey = &mockKey{kind: KindOutboxEvent}
+				mockDS    = NewMockClient(ctrl)
+				mockTx    = NewMockTransaction(ctrl)
+			)
+			expectTransaction(mockDS, mockTx)
+			mockDS.EXPECT().NameKey(KindAccessData, tt.in.AccessToken, gomock.Nil()).Return(accessKey)
+			mockTx.EXPECT().Put(accessKey, gomock.Any()).Return(nil, nil)
+			if tt.in.RefreshToken != "" {
+				refreshKey := &mockKey{kind: KindRefresh, name: tt.in.RefreshToken}
+				mockDS.EXPECT().NameKey(KindRefresh, tt.in.RefreshToken, gomock.Nil()).Return(refreshKey)
+				mockTx.EXPECT().Put(refreshKey, newRefresh(tt.in.RefreshToken, tt.in.AccessToken)).Return(nil, nil)
+			}
+			mockDS.EXPECT().IncompleteKey(KindOutboxEvent, gomock.Nil()).Return(outboxKey)
+			mockTx.EXPECT().Put(outboxKey, tt.out).Return(nil, nil)
+
+			storage := NewStorageWithClient(context.Background(), mockDS, WithOutbox())
+			if err := storage.SaveAccess(tt.in); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStorage_RemoveAccess_Outbox(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		testName string
+		returns  *AccessData
+		out      *TokenEvent
+	}{
+		{
+			testName: "revoked",
+			returns:  &AccessData{ClientKey: "client", UserData: "user"},
+			out:      &TokenEvent{Type: TokenEventRevoked, ClientID: "client", Subject: "user", TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+		},
+		{
+			testName: "not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			defer func(f func() time.Time) { timeNow = f }(timeNow)
+			timeNow = func() time.Time { return now }
+
+			var (
+				accessKey = &mockKey{kind: KindAccessData, name: "token"}
+				outboxKey = &mockKey{kind: KindOutboxEvent}
+				mockDS    = NewMockClient(ctrl)
+				mockTx    = NewMockTransaction(ctrl)
+			)
+			expectTransaction(mockDS, mockTx)
+			mockDS.EXPECT().NameKey(KindAccessData, "token", gomock.Nil()).Return(accessKey)
+			mockTx.EXPECT().Get(accessKey, gomock.Any()).DoAndReturn(func(_ datastore.Key, dst interface{}) error {
+				if tt.returns == nil {
+					return datastore.ErrNoSuchEntity
+				}
+				*dst.(*AccessData) = *tt.returns
+				return nil
+			})
+			if tt.out != nil {
+				mockTx.EXPECT().Delete(accessKey).Return(nil)
+				mockDS.EXPECT().IncompleteKey(KindOutboxEvent, gomock.Nil()).Return(outboxKey)
+				mockTx.EXPECT().Put(outboxKey, tt.out).Return(nil, nil)
+			}
+
+			storage := NewStorageWithClient(context.Background(), mockDS, WithOutbox())
+			if err := storage.RemoveAccess("token"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStorage_Outbox_Audit(t *testing.T) {
+	now := time.Now()
+	stored := &AccessData{ClientKey: "client", UserData: "user", Scope: []string{"read"}}
+
+	tests := []struct {
+		testName string
+		call     func(s *Storage) error
+		out      *AuditEvent
+	}{
+		{
+			testName: "issued",
+			call: func(s *Storage) error {
+				return s.SaveAccess(&osin.AccessData{Client: &Client{ID: "client"}, AccessToken: "token", Scope: "read", UserData: "user"})
+			},
+			out: &AuditEvent{Type: AuditTokenIssued, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+		},
+		{
+			testName: "revoked",
+			call: func(s *Storage) error {
+				return s.RemoveAccess("token")
+			},
+			out: &AuditEvent{Type: AuditTokenRevoked, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+		},
+		{
+			testName: "rotated",
+			call: func(s *Storage) error {
+				v := s.WithContext(context.Background())
+				v.rotated.add("token")
+				return v.RemoveAccess("token")
+			},
+			out: &AuditEvent{Type: AuditTokenRotated, ClientID: "client", Subject: "user", Scope: []string{"read"}, TokenFingerprint: TokenFingerprint("token"), CreatedAt: now},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			defer func(f func() time.Time) { timeNow = f }(timeNow)
+			timeNow = func() time.Time { return now }
+
+			var (
+				accessKey = &mockKey{kind: KindAccessData, name: "token"}
+				outboxKey = &mockKey{kind: KindOutboxEvent}
+				auditKey  = &mockKey{kind: KindAuditEvent}
+				mockDS    = NewMockClient(ctrl)
+				mockTx    = NewMockTransaction(ctrl)
+			)
+			// Audit events are written only by the transaction, not by PutMulti of the client.
+			expectTransaction(mockDS, mockTx)
+			mockDS.EXPECT().NameKey(KindAccessData, "token", gomock.Nil()).Return(accessKey)
+			mockTx.EXPECT().Get(accessKey, gomock.Any()).DoAndReturn(func(_ datastore.Key, dst interface{}) error {
+				*dst.(*AccessData) = *stored
+				return nil
+			}).AnyTimes()
+			mockTx.EXPECT().Put(accessKey, gomock.Any()).Return(nil, nil).AnyTimes()
+			mockTx.EXPECT().Delete(accessKey).Return(nil).AnyTimes()
+			mockDS.EXPECT().IncompleteKey(KindOutboxEvent, gomock.Nil()).Return(outboxKey)
+			mockTx.EXPECT().Put(outboxKey, gomock.Any()).Return(nil, nil)
+			mockDS.EXPECT().IncompleteKey(KindAuditEvent, gomock.Nil()).Return(auditKey)
+			mockTx.EXPECT().PutMulti([]datastore.Key{auditKey}, []*AuditEvent{tt.out}).Return(nil, nil)
+
+			storage := NewStorageWithClient(context.Background(), mockDS, WithOutbox(), WithAudit())
+			if err := tt.call(storage); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestStorage_RemoveRefresh_Outbox(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	now := time.Now()
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	timeNow = func() time.Time { return now }
+
+	var (
+		refreshKey = &mockKey{kind: KindRefresh, name: "refresh"}
+		outboxKey  = &mockKey{kind: KindOutboxEvent}
+		mockDS     = NewMockClient(ctrl)
+		mockTx     = NewMockTransaction(ctrl)
+	)
+	expectTransaction(mockDS, mockTx)
+	mockDS.EXPECT().NameKey(KindRefresh, "refresh", gomock.Nil()).Return(refreshKey)
+	mockTx.EXPECT().Get(refreshKey, gomock.Any()).DoAndReturn(func(_ datastore.Key, dst interface{}) error {
+		*dst.(*Refresh) = Refresh{AccessToken: "token", Denormalized: true, Access: AccessData{ClientKey: "client", UserData: "user"}}
+		return nil
+	})
+	mockTx.EXPECT().Delete(refreshKey).Return(nil)
+	mockDS.EXPECT().IncompleteKey(KindOutboxEvent, gomock.Nil()).Return(outboxKey)
+	mockTx.EXPECT().Put(outboxKey, &TokenEvent{
+		Type:             TokenEventRefreshRevoked,
+		ClientID:         "client",
+		Subject:          "user",
+		TokenFingerprint: TokenFingerprint("refresh"),
+		CreatedAt:        now,
+	}).Return(nil, nil)
+
+	storage := NewStorageWithClient(context.Background(), mockDS, WithOutbox())
+	if err := storage.RemoveRefresh("refresh"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage_Outbox_Retry(t *testing.T) {
+	tests := []struct {
+		testName string
+		failures int
+		out      error
+	}{
+		{testName: "retried", failures: 1},
+		{testName: "out of attempts", failures: 2, out: datastore.ErrConcurrentTransaction},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				refreshKey = &mockKey{kind: KindRefresh, name: "refresh"}
+				mockDS     = NewMockClient(ctrl)
+				mockTx     = NewMockTransaction(ctrl)
+				attempts   = 0
+			)
+			mockDS.EXPECT().RunInTransaction(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, f func(datastore.Transaction) error) (datastore.Commit, error) {
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("transaction must run with timeout of the operation")
+				}
+				attempts++
+				if attempts <= tt.failures {
+					return nil, datastore.ErrConcurrentTransaction
+				}
+				return nil, f(mockTx)
+			}).Times(2)
+			if tt.out == nil {
+				mockDS.EXPECT().NameKey(KindRefresh, "refresh", gomock.Nil()).Return(refreshKey)
+				mockTx.EXPECT().Get(refreshKey, gomock.Any()).Return(datastore.ErrNoSuchEntity)
+			}
+
+			storage := NewStorageWithClient(context.Background(), mockDS,
+				WithOutbox(),
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+				WithTimeout(time.Minute),
+			)
+			err := storage.RemoveRefresh("refresh")
+			if tt.out == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) || e.Op != OperationRemoveRefresh || !errors.Is(err, tt.out) {
+				t.Errorf("return error\nwant: error of %s caused by %#v\n got: %#v", OperationRemoveRefresh, tt.out, err)
+			}
+		})
+	}
+}
+
+func TestOutboxPoller_Poll(t *testing.T) {
+	errPublish := errors.New("publish error")
+
+	type out struct {
+		published []int64
+		deleted   []datastore.Key
+		n         int
+		err       error
+	}
+
+	keys := []datastore.Key{
+		&mockKey{kind: KindOutboxEvent, id: 1},
+		&mockKey{kind: KindOutboxEvent, id: 2},
+	}
+
+	tests := []struct {
+		testName string
+		failID   int64
+		out      out
+	}{
+		{
+			testName: "all published",
+			out:      out{published: []int64{1, 2}, deleted: keys, n: 2},
+		},
+		{
+			testName: "publish failed",
+			failID:   2,
+			out:      out{published: []int64{1}, deleted: keys[:1], n: 1, err: errPublish},
+		},
+		{
+			testName: "first publish failed",
+			failID:   1,
+			out:      out{err: errPublish},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				mockDS = NewMockClient(ctrl)
+				mockQ  = NewMockQuery(ctrl)
+			)
+			mockDS.EXPECT().NewQuery(KindOutboxEvent).Return(mockQ)
+			mockQ.EXPECT().Order("CreatedAt").Return(mockQ)
+			mockQ.EXPECT().Limit(DefaultOutboxBatchSize).Return(mockQ)
+			mockDS.EXPECT().GetAll(gomock.Any(), mockQ, gomock.Any()).DoAndReturn(func(_ context.Context, _ datastore.Query, dst interface{}) ([]datastore.Key, error) {
+				*dst.(*[]*TokenEvent) = []*TokenEvent{{Type: TokenEventIssued}, {Type: TokenEventRevoked}}
+				return keys, nil
+			})
+			if tt.out.deleted != nil {
+				mockDS.EXPECT().DeleteMulti(gomock.Any(), tt.out.deleted).Return(nil)
+			}
+
+			var published []int64
+			poller := NewOutboxPoller(mockDS, func(_ context.Context, ev *TokenEvent) error {
+				if ev.ID == tt.failID {
+					return errPublish
+				}
+				published = append(published, ev.ID)
+				return nil
+			})
+			n, err := poller.Poll(context.Background())
+			if err != tt.out.err {
+				t.Errorf("return error\nwant: %#v\n got: %#v", tt.out.err, err)
+			}
+			if n != tt.out.n {
+				t.Errorf("published count\nwant: %v\n got: %v", tt.out.n, n)
+			}
+			if !reflect.DeepEqual(tt.out.published, published) {
+				t.Errorf("\nwant: %#v\n got: %#v", tt.out.published, published)
+			}
+		})
+	}
+}
+
+func TestOutboxPoller_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mockDS = NewMockClient(ctrl)
+		mockQ  = NewMockQuery(ctrl)
+		errRPC = errors.New("rpc error")
+	)
+	mockDS.EXPECT().NewQuery(KindOutboxEvent).Return(mockQ).AnyTimes()
+	mockQ.EXPECT().Order("CreatedAt").Return(mockQ).AnyTimes()
+	mockQ.EXPECT().Limit(DefaultOutboxBatchSize).Return(mockQ).AnyTimes()
+	mockDS.EXPECT().GetAll(gomock.Any(), mockQ, gomock.Any()).Return(nil, errRPC).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var errs []error
+	poller := NewOutboxPoller(mockDS, nil)
+	poller.Interval = time.Millisecond
+	poller.OnError = func(err error) {
+		errs = append(errs, err)
+		if len(errs) == 2 {
+			cancel()
+		}
+	}
+
+	if err := poller.Run(ctx); err != context.Canceled {
+		t.Errorf("return error\nwant: %#v\n got: %#v", context.Canceled, err)
+	}
+	if want := []error{errRPC, errRPC}; !reflect.DeepEqual(want, errs) {
+		t.Errorf("errors passed to OnError\nwant: %#v\n got: %#v", want, errs)
+	}
+}
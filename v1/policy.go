@@ -0,0 +1,208 @@
+package datastore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/RangelReale/osin"
+)
+
+// PolicyError is returned when a request is not allowed by the policy of the client.
+// Code is OAuth2 error code (e.g. osin.E_INVALID_SCOPE) which should be responded to the client.
+type PolicyError struct {
+	Code        string
+	Description string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// AllowsScope reports whether every scope in space separated scope is allowed for the client.
+func (c *Client) AllowsScope(scope string) bool {
+	if len(c.AllowedScopes) == 0 {
+		return true
+	}
+	for _, s := range strings.Fields(scope) {
+		if !contains(c.AllowedScopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsGrantType reports whether the client can use grant type at token endpoint.
+func (c *Client) AllowsGrantType(grantType string) bool {
+	return len(c.AllowedGrantTypes) == 0 || contains(c.AllowedGrantTypes, grantType)
+}
+
+// AllowsResponseType reports whether the client can use response type at authorize endpoint.
+func (c *Client) AllowsResponseType(responseType string) bool {
+	return len(c.AllowedResponseTypes) == 0 || contains(c.AllowedResponseTypes, responseType)
+}
+
+func (c *Client) checkAuthorize(responseType, scope, codeChallenge, codeChallengeMethod string) error {
+	if !c.AllowsResponseType(responseType) {
+		return &PolicyError{
+			Code:        osin.E_UNAUTHORIZED_CLIENT,
+			Description: fmt.Sprintf("response type %q is not allowed for the client", responseType),
+		}
+	}
+	if !c.AllowsScope(scope) {
+		return &PolicyError{
+			Code:        osin.E_INVALID_SCOPE,
+			Description: "requested scope is not allowed for the client",
+		}
+	}
+	if responseType == string(osin.CODE) && c.requiresPKCE() {
+		if codeChallenge == "" || codeChallengeMethod != osin.PKCE_S256 {
+			return &PolicyError{
+				Code:        osin.E_INVALID_REQUEST,
+				Description: "code_challenge with S256 method is required for the client",
+			}
+		}
+	}
+	return nil
+}
+
+// checkAccess validates grant of access token. grantType is osin.IMPLICIT for access token issued at authorize endpoint,
+// and empty if the grant cannot be told. Unknown grant is rejected if the client restricts grants,
+// so that the policy cannot be bypassed by a grant which is not checked.
+func (c *Client) checkAccess(grantType, scope string) error {
+	switch {
+	case grantType == "":
+		if c.restrictsGrants() {
+			return &PolicyError{
+				Code:        osin.E_UNAUTHORIZED_CLIENT,
+				Description: "grant type cannot be told for the client, check the request with CheckAccessRequest or CheckAuthorizeRequest",
+			}
+		}
+	case grantType == string(osin.IMPLICIT):
+		if !c.AllowsResponseType(string(osin.TOKEN)) {
+			return &PolicyError{
+				Code:        osin.E_UNAUTHORIZED_CLIENT,
+				Description: fmt.Sprintf("response type %q is not allowed for the client", osin.TOKEN),
+			}
+		}
+	case !c.AllowsGrantType(grantType):
+		return &PolicyError{
+			Code:        osin.E_UNAUTHORIZED_CLIENT,
+			Description: fmt.Sprintf("grant type %q is not allowed for the client", grantType),
+		}
+	}
+	if grantType == string(osin.CLIENT_CREDENTIALS) && c.IsPublic() {
+		return &PolicyError{
+			Code:        osin.E_UNAUTHORIZED_CLIENT,
+			Description: "public client cannot use client_credentials grant",
+		}
+	}
+	if !c.AllowsScope(scope) {
+		return &PolicyError{
+			Code:        osin.E_INVALID_SCOPE,
+			Description: "requested scope is not allowed for the client",
+		}
+	}
+	return nil
+}
+
+// CheckAuthorizeRequest validates authorize request with policy of the client.
+// If the request is not allowed, CheckAuthorizeRequest sets OAuth2 error to w and returns false.
+// Call this between osin.Server.HandleAuthorizeRequest and osin.Server.FinishAuthorizeRequest
+// to respond proper error code, because Storage.SaveAuthorize can only report the violation as server_error.
+// Allowed implicit grant is recorded to w.Storage, which must be a view of Storage to issue the token for client restricting grants.
+func CheckAuthorizeRequest(w *osin.Response, ar *osin.AuthorizeRequest) bool {
+	c, ok := ar.Client.(*Client)
+	if !ok {
+		return true
+	}
+	err := c.checkAuthorize(string(ar.Type), ar.Scope, ar.CodeChallenge, ar.CodeChallengeMethod)
+	if err != nil {
+		perr := err.(*PolicyError)
+		w.SetErrorState(perr.Code, perr.Description, ar.State)
+		return false
+	}
+	if ar.Type == osin.TOKEN {
+		recordGrant(w.Storage, c.GetId(), string(osin.IMPLICIT))
+	}
+	return true
+}
+
+// CheckAccessRequest validates access request with policy of the client.
+// If the request is not allowed, CheckAccessRequest sets OAuth2 error to w and returns false.
+// Call this between osin.Server.HandleAccessRequest and osin.Server.FinishAccessRequest
+// to respond proper error code, because Storage.SaveAccess can only report the violation as server_error.
+// Allowed grant type is recorded to w.Storage, because Storage.SaveAccess cannot tell password, client_credentials and assertion grants,
+// and rejects them for client restricting grants unless they are recorded.
+func CheckAccessRequest(w *osin.Response, ar *osin.AccessRequest) bool {
+	c, ok := ar.Client.(*Client)
+	if !ok {
+		return true
+	}
+	if err := c.checkAccess(string(ar.Type), ar.Scope); err != nil {
+		perr := err.(*PolicyError)
+		w.SetError(perr.Code, perr.Description)
+		return false
+	}
+	recordGrant(w.Storage, c.GetId(), string(ar.Type))
+	return true
+}
+
+// restrictsGrants reports whether the client does not allow some grants.
+// Public client cannot use client_credentials grant, which osin authenticates with empty secret.
+func (c *Client) restrictsGrants() bool {
+	return len(c.AllowedGrantTypes) > 0 || len(c.AllowedResponseTypes) > 0 || c.IsPublic()
+}
+
+// requestGrants records grant types checked in a request for each client ID,
+// so that a view of Storage can tell the grant of access data which osin does not tell.
+type requestGrants struct {
+	mu     sync.Mutex
+	grants map[string]string
+}
+
+func newRequestGrants() *requestGrants {
+	return &requestGrants{grants: make(map[string]string)}
+}
+
+func (r *requestGrants) get(clientID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.grants[clientID]
+}
+
+func (r *requestGrants) add(clientID, grantType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.grants[clientID] = grantType
+}
+
+// recordGrant records checked grant type to storage if it is a view of Storage, optionally wrapped by LoggingStorage.
+// Storage which is not a view is shared between requests, so nothing is recorded.
+func recordGrant(storage osin.Storage, clientID, grantType string) {
+	switch s := storage.(type) {
+	case *Storage:
+		if s.grants != nil {
+			s.grants.add(clientID, grantType)
+		}
+	case *LoggingStorage:
+		recordGrant(s.storage, clientID, grantType)
+	}
+}
+
+// requiresPKCE reports whether authorization code requests of the client must carry S256 code challenge.
+// Public client always requires PKCE.
+func (c *Client) requiresPKCE() bool {
+	return c.RequirePKCE || c.IsPublic()
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RangelReale/osin"
+)
+
+func TestClient_AllowsScope(t *testing.T) {
+	type (
+		in struct {
+			client *Client
+			scope  string
+		}
+
+		out struct {
+			allowed bool
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "no restriction",
+			in: in{
+				client: &Client{ID: "client"},
+				scope:  "scope1 scope2",
+			},
+			out: out{allowed: true},
+		},
+		{
+			testName: "allowed scopes",
+			in: in{
+				client: &Client{ID: "client", AllowedScopes: []string{"scope1", "scope2"}},
+				scope:  "scope1 scope2",
+			},
+			out: out{allowed: true},
+		},
+		{
+			testName: "empty scope",
+			in: in{
+				client: &Client{ID: "client", AllowedScopes: []string{"scope1"}},
+				scope:  "",
+			},
+			out: out{allowed: true},
+		},
+		{
+			testName: "disallowed scope",
+			in: in{
+				client: &Client{ID: "client", AllowedScopes: []string{"scope1"}},
+				scope:  "scope1 scope2",
+			},
+			out: out{allowed: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := tt.in.client.AllowsScope(tt.in.scope); got != tt.out.allowed {
+				t.Errorf("want: %v, got: %v", tt.out.allowed, got)
+			}
+		})
+	}
+}
+
+func TestCheckAuthorizeRequest(t *testing.T) {
+	type (
+		in struct {
+			request *osin.AuthorizeRequest
+		}
+
+		out struct {
+			ok      bool
+			errorID string
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "allowed",
+			in: in{
+				request: &osin.AuthorizeRequest{
+					Type: osin.CODE,
+					Client: &Client{
+						ID:                   "client",
+						AllowedScopes:        []string{"scope1"},
+						AllowedResponseTypes: []string{"code"},
+						RequirePKCE:          true,
+					},
+					Scope:               "scope1",
+					CodeChallenge:       "challenge",
+					CodeChallengeMethod: osin.PKCE_S256,
+				},
+			},
+			out: out{ok: true},
+		},
+		{
+			testName: "disallowed response type",
+			in: in{
+				request: &osin.AuthorizeRequest{
+					Type:   osin.TOKEN,
+					Client: &Client{ID: "client", AllowedResponseTypes: []string{"code"}},
+				},
+			},
+			out: out{ok: false, errorID: osin.E_UNAUTHORIZED_CLIENT},
+		},
+		{
+			testName: "disallowed scope",
+			in: in{
+				request: &osin.AuthorizeRequest{
+					Type:   osin.CODE,
+					Client: &Client{ID: "client", AllowedScopes: []string{"scope1"}},
+					Scope:  "scope2",
+				},
+			},
+			out: out{ok: false, errorID: osin.E_INVALID_SCOPE},
+		},
+		{
+			testName: "missing pkce",
+			in: in{
+				request: &osin.AuthorizeRequest{
+					Type:   osin.CODE,
+					Client: &Client{ID: "client", RequirePKCE: true},
+				},
+			},
+			out: out{ok: false, errorID: osin.E_INVALID_REQUEST},
+		},
+		{
+			testName: "plain pkce",
+			in: in{
+				request: &osin.AuthorizeRequest{
+					Type:                osin.CODE,
+					Client:              &Client{ID: "client", RequirePKCE: true},
+					CodeChallenge:       "challenge",
+					CodeChallengeMethod: osin.PKCE_PLAIN,
+				},
+			},
+			out: out{ok: false, errorID: osin.E_INVALID_REQUEST},
+		},
+		{
+			testName: "public client without pkce",
+			in: in{
+				request: &osin.AuthorizeRequest{
+					Type:   osin.CODE,
+					Client: &Client{ID: "client", Type: ClientTypePublic},
+				},
+			},
+			out: out{ok: false, errorID: osin.E_INVALID_REQUEST},
+		},
+		{
+			testName: "other client implementation",
+			in: in{
+				request: &osin.AuthorizeRequest{
+					Type:   osin.CODE,
+					Client: &osin.DefaultClient{Id: "client"},
+				},
+			},
+			out: out{ok: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			w := new(osin.Response)
+			if got := CheckAuthorizeRequest(w, tt.in.request); got != tt.out.ok {
+				t.Errorf("want: %v, got: %v", tt.out.ok, got)
+			}
+			if w.ErrorId != tt.out.errorID {
+				t.Errorf("error id\nwant: %q\n got: %q", tt.out.errorID, w.ErrorId)
+			}
+		})
+	}
+}
+
+func TestCheckAccessRequest(t *testing.T) {
+	type (
+		in struct {
+			request *osin.AccessRequest
+		}
+
+		out struct {
+			ok      bool
+			errorID string
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "allowed",
+			in: in{
+				request: &osin.AccessRequest{
+					Type:   osin.REFRESH_TOKEN,
+					Client: &Client{ID: "client", AllowedGrantTypes: []string{"authorization_code", "refresh_token"}},
+				},
+			},
+			out: out{ok: true},
+		},
+		{
+			testName: "disallowed grant type",
+			in: in{
+				request: &osin.AccessRequest{
+					Type:   osin.CLIENT_CREDENTIALS,
+					Client: &Client{ID: "client", AllowedGrantTypes: []string{"authorization_code"}},
+				},
+			},
+			out: out{ok: false, errorID: osin.E_UNAUTHORIZED_CLIENT},
+		},
+		{
+			testName: "public client credentials",
+			in: in{
+				request: &osin.AccessRequest{
+					Type:   osin.CLIENT_CREDENTIALS,
+					Client: &Client{ID: "client", Type: ClientTypePublic},
+				},
+			},
+			out: out{ok: false, errorID: osin.E_UNAUTHORIZED_CLIENT},
+		},
+		{
+			testName: "disallowed scope",
+			in: in{
+				request: &osin.AccessRequest{
+					Type:   osin.CLIENT_CREDENTIALS,
+					Client: &Client{ID: "client", AllowedScopes: []string{"scope1"}},
+					Scope:  "scope1 scope2",
+				},
+			},
+			out: out{ok: false, errorID: osin.E_INVALID_SCOPE},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			w := new(osin.Response)
+			if got := CheckAccessRequest(w, tt.in.request); got != tt.out.ok {
+				t.Errorf("want: %v, got: %v", tt.out.ok, got)
+			}
+			if w.ErrorId != tt.out.errorID {
+				t.Errorf("error id\nwant: %q\n got: %q", tt.out.errorID, w.ErrorId)
+			}
+		})
+	}
+}
+
+func TestCheckRequest_RecordsGrant(t *testing.T) {
+	restricted := &Client{
+		ID:                   "client",
+		AllowedGrantTypes:    []string{"password"},
+		AllowedResponseTypes: []string{"token"},
+	}
+
+	type (
+		in struct {
+			check   func(w *osin.Response) bool
+			wrapped bool
+		}
+
+		out struct {
+			grantType string
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "password",
+			in: in{
+				check: func(w *osin.Response) bool {
+					return CheckAccessRequest(w, &osin.AccessRequest{Type: osin.PASSWORD, Client: restricted})
+				},
+			},
+			out: out{grantType: "password"},
+		},
+		{
+			testName: "implicit",
+			in: in{
+				check: func(w *osin.Response) bool {
+					return CheckAuthorizeRequest(w, &osin.AuthorizeRequest{Type: osin.TOKEN, Client: restricted})
+				},
+			},
+			out: out{grantType: string(osin.IMPLICIT)},
+		},
+		{
+			testName: "disallowed grant is not recorded",
+			in: in{
+				check: func(w *osin.Response) bool {
+					return CheckAccessRequest(w, &osin.AccessRequest{Type: osin.CLIENT_CREDENTIALS, Client: restricted})
+				},
+			},
+			out: out{grantType: ""},
+		},
+		{
+			testName: "wrapped by logging storage",
+			in: in{
+				check: func(w *osin.Response) bool {
+					return CheckAccessRequest(w, &osin.AccessRequest{Type: osin.PASSWORD, Client: restricted})
+				},
+				wrapped: true,
+			},
+			out: out{grantType: "password"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			view := new(Storage).WithContext(context.Background())
+			w := &osin.Response{Storage: view}
+			if tt.in.wrapped {
+				w.Storage = NewLoggingStorage(view, LoggerFunc(func(LogLevel, string, ...LogField) {}))
+			}
+			tt.in.check(w)
+
+			got := view.grantTypeOf(&osin.AccessData{Client: restricted})
+			if got != tt.out.grantType {
+				t.Errorf("\nwant: %q\n got: %q", tt.out.grantType, got)
+			}
+			if err := restricted.checkAccess(got, ""); (err == nil) != (got != "") {
+				t.Errorf("checkAccess of %q: %v", got, err)
+			}
+		})
+	}
+}
@@ -9,35 +9,54 @@ import (
 // KindRefresh is datastore kind name of OAuth2 refresh token
 const KindRefresh = "refresh"
 
-type refresh struct {
+// Refresh is datastore entity of OAuth2 refresh token.
+// RefreshToken is used as Datastore's key.
+type Refresh struct {
 	RefreshToken string `datastore:"-"`
 	AccessToken  string `datastore:",noindex"`
+
+	// Denormalized reports whether Access holds copy of access data.
+	// It is false for entities stored with legacy schema.
+	Denormalized bool `datastore:",noindex"`
+	// Access is copy of access data for AccessToken. AccessToken of it is not stored.
+	// It is not indexed, since tokens are never queried by it.
+	Access AccessData `datastore:",noindex"`
 }
 
-func newRefresh(refToken, accToken string) *refresh {
-	return &refresh{
+func newRefresh(refToken, accToken string) *Refresh {
+	return &Refresh{
 		RefreshToken: refToken,
 		AccessToken:  accToken,
 	}
 }
 
-type refreshStorage struct {
+// denormalize copies access data into the entity.
+func (r *Refresh) denormalize(ad *AccessData) {
+	r.Denormalized = true
+	r.Access = *ad
+}
+
+// RefreshStorage is datastore handler for refresh token.
+type RefreshStorage struct {
 	client datastore.Client
 }
 
-func newRefreshStorage(client datastore.Client) *refreshStorage {
-	return &refreshStorage{client: client}
+// NewRefreshStorage create RefreshStorage object which uses given datastore client.
+func NewRefreshStorage(client datastore.Client) *RefreshStorage {
+	return &RefreshStorage{client: client}
 }
 
-func (r *refreshStorage) put(ctx context.Context, ref *refresh) error {
+// Put create or update refresh token entity.
+func (r *RefreshStorage) Put(ctx context.Context, ref *Refresh) error {
 	key := r.client.NameKey(KindRefresh, ref.RefreshToken, nil)
 	_, err := r.client.Put(ctx, key, ref)
 	return err
 }
 
-func (r *refreshStorage) get(ctx context.Context, token string) (*refresh, error) {
+// Get search refresh token for given token.
+func (r *RefreshStorage) Get(ctx context.Context, token string) (*Refresh, error) {
 	key := r.client.NameKey(KindRefresh, token, nil)
-	ref := new(refresh)
+	ref := new(Refresh)
 	if err := r.client.Get(ctx, key, ref); err != nil {
 		return nil, err
 	}
@@ -45,7 +64,8 @@ func (r *refreshStorage) get(ctx context.Context, token string) (*refresh, error
 	return ref, nil
 }
 
-func (r *refreshStorage) delete(ctx context.Context, token string) error {
+// Delete removes refresh token entity for token from Datastore.
+func (r *RefreshStorage) Delete(ctx context.Context, token string) error {
 	key := r.client.NameKey(KindRefresh, token, nil)
 	return r.client.Delete(ctx, key)
 }
@@ -13,7 +13,7 @@ import (
 func TestRefreshRepository_Put(t *testing.T) {
 	type (
 		in struct {
-			refresh *refresh
+			refresh *Refresh
 		}
 
 		returns struct {
@@ -29,7 +29,7 @@ func TestRefreshRepository_Put(t *testing.T) {
 		{
 			testName: "test1",
 			in: in{
-				refresh: &refresh{
+				refresh: &Refresh{
 					RefreshToken: "refresh",
 					AccessToken:  "access",
 				},
@@ -49,8 +49,8 @@ func TestRefreshRepository_Put(t *testing.T) {
 			mockDSClient.EXPECT().NameKey(KindRefresh, tt.in.refresh.RefreshToken, gomock.Nil()).Return(tt.returns.key)
 			mockDSClient.EXPECT().Put(gomock.Any(), tt.returns.key, tt.in.refresh).Return(tt.returns.key, nil)
 
-			storage := &refreshStorage{client: mockDSClient}
-			err := storage.put(context.Background(), tt.in.refresh)
+			storage := &RefreshStorage{client: mockDSClient}
+			err := storage.Put(context.Background(), tt.in.refresh)
 			if err != nil {
 				t.Error(err)
 			}
@@ -65,12 +65,12 @@ func TestRefreshRepository_Get(t *testing.T) {
 		}
 
 		out struct {
-			refresh *refresh
+			refresh *Refresh
 		}
 
 		returns struct {
 			key     datastore.Key
-			refresh *refresh
+			refresh *Refresh
 		}
 	)
 
@@ -86,14 +86,14 @@ func TestRefreshRepository_Get(t *testing.T) {
 				refreshToken: "refresh",
 			},
 			out: out{
-				refresh: &refresh{
+				refresh: &Refresh{
 					RefreshToken: "refresh",
 					AccessToken:  "access",
 				},
 			},
 			returns: returns{
 				key: &mockKey{kind: "kind", name: "token"},
-				refresh: &refresh{
+				refresh: &Refresh{
 					AccessToken: "access",
 				},
 			},
@@ -114,8 +114,8 @@ func TestRefreshRepository_Get(t *testing.T) {
 				return nil
 			})
 
-			storage := &refreshStorage{client: mockDatastoreClient}
-			got, err := storage.get(context.Background(), tt.in.refreshToken)
+			storage := &RefreshStorage{client: mockDatastoreClient}
+			got, err := storage.Get(context.Background(), tt.in.refreshToken)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -162,8 +162,8 @@ func TestRefreshRepository_Delete(t *testing.T) {
 			mockDatastoreClient.EXPECT().NameKey(KindRefresh, tt.in.refreshToken, gomock.Nil()).Return(tt.returns.key)
 			mockDatastoreClient.EXPECT().Delete(gomock.Any(), tt.returns.key).Return(nil)
 
-			storage := &refreshStorage{client: mockDatastoreClient}
-			if err := storage.delete(context.Background(), tt.in.refreshToken); err != nil {
+			storage := &RefreshStorage{client: mockDatastoreClient}
+			if err := storage.Delete(context.Background(), tt.in.refreshToken); err != nil {
 				t.Error(err)
 			}
 		})
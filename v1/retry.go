@@ -0,0 +1,216 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Default values of RetryPolicy used for zero fields.
+const (
+	DefaultRetryMaxAttempts    = 3
+	DefaultRetryInitialBackoff = 50 * time.Millisecond
+	DefaultRetryMaxBackoff     = time.Second
+)
+
+// RetryPolicy configures retries of Datastore operations which are safe to repeat.
+// Zero fields are replaced with the default values.
+type RetryPolicy struct {
+	// MaxAttempts is max number of attempts including the first one.
+	MaxAttempts int
+	// InitialBackoff is wait before the first retry, which doubles for each retry up to MaxBackoff.
+	// Actual wait is jittered between half of the backoff and the backoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Retryable reports whether err is transient and the operation should be retried.
+	// IsTransientError is used if nil.
+	Retryable func(err error) bool
+}
+
+// IsTransientError reports whether err is transient Datastore error,
+// which is contention of transactions, or unavailable, aborted and deadline exceeded RPC.
+func IsTransientError(err error) bool {
+	if errors.Is(err, datastore.ErrConcurrentTransaction) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsTransientError
+	}
+	return p
+}
+
+// backoff returns jittered wait before retry-th retry.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// do calls f until it succeeds, returns error which is not retryable, or runs out of attempts.
+// do gives up without waiting if ctx is done or its deadline comes before the next attempt,
+// and returns the last error of f in any case.
+func (p *RetryPolicy) do(ctx context.Context, f func() error) error {
+	err := f()
+	for retry := 1; retry < p.MaxAttempts && err != nil && p.Retryable(err); retry++ {
+		wait := p.backoff(retry)
+		if deadline, ok := ctx.Deadline(); ok && timeNow().Add(wait).After(deadline) {
+			return err
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		err = f()
+	}
+	return err
+}
+
+// Handlers below retry every operation, because they are all keyed reads, overwrites and deletes which are safe to repeat.
+
+type retryingClientGetter struct {
+	ClientGetter
+	policy *RetryPolicy
+}
+
+func (r retryingClientGetter) Get(ctx context.Context, id string) (c *Client, err error) {
+	err = r.policy.do(ctx, func() error {
+		c, err = r.ClientGetter.Get(ctx, id)
+		return err
+	})
+	return c, err
+}
+
+type retryingAuthorizeDataHandler struct {
+	AuthorizeDataHandler
+	policy *RetryPolicy
+}
+
+func (r retryingAuthorizeDataHandler) Put(ctx context.Context, a *AuthorizeData) error {
+	return r.policy.do(ctx, func() error {
+		return r.AuthorizeDataHandler.Put(ctx, a)
+	})
+}
+
+func (r retryingAuthorizeDataHandler) Get(ctx context.Context, code string) (a *AuthorizeData, err error) {
+	err = r.policy.do(ctx, func() error {
+		a, err = r.AuthorizeDataHandler.Get(ctx, code)
+		return err
+	})
+	return a, err
+}
+
+func (r retryingAuthorizeDataHandler) Delete(ctx context.Context, code string) error {
+	return r.policy.do(ctx, func() error {
+		return r.AuthorizeDataHandler.Delete(ctx, code)
+	})
+}
+
+type retryingAccessDataHandler struct {
+	AccessDataHandler
+	policy *RetryPolicy
+}
+
+func (r retryingAccessDataHandler) Put(ctx context.Context, a *AccessData) error {
+	return r.policy.do(ctx, func() error {
+		return r.AccessDataHandler.Put(ctx, a)
+	})
+}
+
+func (r retryingAccessDataHandler) Get(ctx context.Context, token string) (a *AccessData, err error) {
+	err = r.policy.do(ctx, func() error {
+		a, err = r.AccessDataHandler.Get(ctx, token)
+		return err
+	})
+	return a, err
+}
+
+func (r retryingAccessDataHandler) Delete(ctx context.Context, token string) error {
+	return r.policy.do(ctx, func() error {
+		return r.AccessDataHandler.Delete(ctx, token)
+	})
+}
+
+type retryingRefreshHandler struct {
+	RefreshHandler
+	policy *RetryPolicy
+}
+
+func (r retryingRefreshHandler) Put(ctx context.Context, ref *Refresh) error {
+	return r.policy.do(ctx, func() error {
+		return r.RefreshHandler.Put(ctx, ref)
+	})
+}
+
+func (r retryingRefreshHandler) Get(ctx context.Context, token string) (ref *Refresh, err error) {
+	err = r.policy.do(ctx, func() error {
+		ref, err = r.RefreshHandler.Get(ctx, token)
+		return err
+	})
+	return ref, err
+}
+
+func (r retryingRefreshHandler) Delete(ctx context.Context, token string) error {
+	return r.policy.do(ctx, func() error {
+		return r.RefreshHandler.Delete(ctx, token)
+	})
+}
+
+// wrapRetry makes handlers of d retry with policy. Handlers which are not set are left nil.
+func (d *Storage) wrapRetry(policy RetryPolicy) {
+	p := policy.withDefaults()
+	// Transactions of outbox are retried by runInTransaction with the policy.
+	d.retryPolicy = &p
+	if d.clientGetter != nil {
+		d.clientGetter = retryingClientGetter{ClientGetter: d.clientGetter, policy: &p}
+	}
+	if d.authDataHandler != nil {
+		d.authDataHandler = retryingAuthorizeDataHandler{AuthorizeDataHandler: d.authDataHandler, policy: &p}
+	}
+	if d.accessDataHandler != nil {
+		d.accessDataHandler = retryingAccessDataHandler{AccessDataHandler: d.accessDataHandler, policy: &p}
+	}
+	if d.refreshHandler != nil {
+		d.refreshHandler = retryingRefreshHandler{RefreshHandler: d.refreshHandler, policy: &p}
+	}
+}
+
+// unwrapClientGetter returns ClientGetter given to Storage without retry.
+func unwrapClientGetter(g ClientGetter) ClientGetter {
+	if r, ok := g.(retryingClientGetter); ok {
+		return r.ClientGetter
+	}
+	return g
+}
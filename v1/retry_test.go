@@ -0,0 +1,170 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		testName string
+		err      error
+		want     bool
+	}{
+		{testName: "concurrent transaction", err: datastore.ErrConcurrentTransaction, want: true},
+		{testName: "unavailable", err: status.Error(codes.Unavailable, "unavailable"), want: true},
+		{testName: "aborted", err: status.Error(codes.Aborted, "aborted"), want: true},
+		{testName: "deadline exceeded", err: status.Error(codes.DeadlineExceeded, "deadline"), want: true},
+		{testName: "invalid argument", err: status.Error(codes.InvalidArgument, "invalid"), want: false},
+		{testName: "no such entity", err: datastore.ErrNoSuchEntity, want: false},
+		{testName: "other", err: errors.New("error"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			if got := IsTransientError(tt.err); got != tt.want {
+				t.Errorf("want: %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_do(t *testing.T) {
+	errTransient := status.Error(codes.Unavailable, "unavailable")
+	errPermanent := errors.New("permanent")
+
+	type (
+		in struct {
+			errs    []error
+			timeout time.Duration
+		}
+
+		out struct {
+			calls int
+			err   error
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "success",
+			in:       in{errs: []error{nil}},
+			out:      out{calls: 1},
+		},
+		{
+			testName: "success after retry",
+			in:       in{errs: []error{errTransient, errTransient, nil}},
+			out:      out{calls: 3},
+		},
+		{
+			testName: "not retryable",
+			in:       in{errs: []error{errPermanent, nil}},
+			out:      out{calls: 1, err: errPermanent},
+		},
+		{
+			testName: "max attempts",
+			in:       in{errs: []error{errTransient, errTransient, errTransient, nil}},
+			out:      out{calls: 3, err: errTransient},
+		},
+		{
+			testName: "deadline before next attempt",
+			in:       in{errs: []error{errTransient, nil}, timeout: time.Millisecond},
+			out:      out{calls: 1, err: errTransient},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.in.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.in.timeout)
+				defer cancel()
+			}
+
+			p := RetryPolicy{InitialBackoff: 10 * time.Millisecond}.withDefaults()
+			calls := 0
+			err := p.do(ctx, func() error {
+				err := tt.in.errs[calls]
+				calls++
+				return err
+			})
+			if calls != tt.out.calls {
+				t.Errorf("calls\nwant: %v\n got: %v", tt.out.calls, calls)
+			}
+			if err != tt.out.err {
+				t.Errorf("return error\nwant: %#v\n got: %#v", tt.out.err, err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 30 * time.Millisecond}.withDefaults()
+
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{retry: 1, min: 5 * time.Millisecond, max: 10 * time.Millisecond},
+		{retry: 2, min: 10 * time.Millisecond, max: 20 * time.Millisecond},
+		{retry: 3, min: 15 * time.Millisecond, max: 30 * time.Millisecond},
+		{retry: 10, min: 15 * time.Millisecond, max: 30 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if got := p.backoff(tt.retry); got < tt.min || got > tt.max {
+				t.Errorf("retry %d: want between %v and %v, got: %v", tt.retry, tt.min, tt.max, got)
+			}
+		}
+	}
+}
+
+func TestStorage_WithRetryPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach = NewMockAccessDataHandler(ctrl)
+		mch  = NewMockClientGetter(ctrl)
+	)
+	gomock.InOrder(
+		mach.EXPECT().Get(gomock.Any(), "token").Return(nil, status.Error(codes.Unavailable, "unavailable")),
+		mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{AccessToken: "token", ClientKey: "client"}, nil),
+	)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(nil, datastore.ErrNoSuchEntity)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{
+		Client:     mch,
+		AccessData: mach,
+	}, WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond}))
+
+	// Missing client is not retried.
+	if _, err := storage.LoadAccess("token"); !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("return error\nwant: %#v\n got: %#v", osin.ErrNotFound, err)
+	}
+}
+
+func TestStorage_ClientStorage_WithRetryPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	storage := NewStorageWithClient(context.Background(), NewMockClient(ctrl), WithRetryPolicy(RetryPolicy{}))
+	if storage.ClientStorage() != unwrapClientGetter(storage.clientGetter) {
+		t.Error("ClientStorage must return ClientStorage used by the storage")
+	}
+}
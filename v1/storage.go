@@ -3,7 +3,9 @@ package datastore
 
 import (
 	"context"
-	"strings"
+	"errors"
+	"sync"
+	"time"
 
 	"go.mercari.io/datastore"
 	"go.mercari.io/datastore/aedatastore"
@@ -12,40 +14,81 @@ import (
 	"github.com/RangelReale/osin"
 )
 
+// Handler interfaces below are used by Storage to store each kind of entities.
+// Implementations must be safe for concurrent use,
+// and should return datastore.ErrNoSuchEntity or osin.ErrNotFound when there is no entity for the key.
 type (
-	clientGetter interface {
+	// ClientGetter loads client. ClientStorage implements this.
+	ClientGetter interface {
 		Get(ctx context.Context, id string) (*Client, error)
 	}
 
-	authDataHandler interface {
-		put(ctx context.Context, auth *authorizeData) error
-		get(ctx context.Context, code string) (*authorizeData, error)
-		delete(ctx context.Context, code string) error
+	// AuthorizeDataHandler stores authorize data. AuthorizeDataStorage implements this.
+	AuthorizeDataHandler interface {
+		Put(ctx context.Context, auth *AuthorizeData) error
+		Get(ctx context.Context, code string) (*AuthorizeData, error)
+		Delete(ctx context.Context, code string) error
 	}
 
-	accessDataHandler interface {
-		put(ctx context.Context, ac *accessData) error
-		get(ctx context.Context, token string) (*accessData, error)
-		delete(ctx context.Context, token string) error
+	// AccessDataHandler stores access data. AccessDataStorage implements this.
+	AccessDataHandler interface {
+		Put(ctx context.Context, ac *AccessData) error
+		Get(ctx context.Context, token string) (*AccessData, error)
+		Delete(ctx context.Context, token string) error
 	}
 
-	refreshHandler interface {
-		put(ctx context.Context, ref *refresh) error
-		get(ctx context.Context, token string) (*refresh, error)
-		delete(ctx context.Context, token string) error
+	// RefreshHandler stores refresh token. RefreshStorage implements this.
+	RefreshHandler interface {
+		Put(ctx context.Context, ref *Refresh) error
+		Get(ctx context.Context, token string) (*Refresh, error)
+		Delete(ctx context.Context, token string) error
+	}
+
+	// AuditHandler records audit events. AuditStorage implements this.
+	AuditHandler interface {
+		Record(ctx context.Context, events ...*AuditEvent) error
 	}
 )
 
+// Handlers is set of handlers used by Storage.
+// Each handler can be implemented by different backend.
+type Handlers struct {
+	Client        ClientGetter
+	AuthorizeData AuthorizeDataHandler
+	AccessData    AccessDataHandler
+	Refresh       RefreshHandler
+	// Audit is optional. Storage records grant lifecycle events if it is set.
+	// Events are recorded after the change of the grant, so the change is kept without its events if Record fails.
+	Audit AuditHandler
+}
+
 // Storage is handler to store OAuth2 tokens at GCP Datastore.
-//  This struct must be live in only request scope.
-//  So, instance should be created at like each "handler.ServeHTTP" methods.
+// Storage is safe for concurrent use, so it should be created once and shared between requests.
+// Use WithContext to bind context of each request, and Clone to get a view for each osin.Response.
 type Storage struct {
 	ctx               context.Context
 	client            datastore.Client
-	clientGetter      clientGetter
-	authDataHandler   authDataHandler
-	accessDataHandler accessDataHandler
-	refreshHandler    refreshHandler
+	ownsClient        bool
+	clientGetter      ClientGetter
+	authDataHandler   AuthorizeDataHandler
+	accessDataHandler AccessDataHandler
+	refreshHandler    RefreshHandler
+	auditHandler      AuditHandler
+	auditWithClient   bool
+	outbox            bool
+	denormalized      bool
+	accessCache       AccessCache
+	accessCacheTTL    time.Duration
+	retryPolicy       *RetryPolicy
+	timeout           time.Duration
+	timeouts          map[Operation]time.Duration
+	observer          Observer
+	// clients memoizes clients loaded through a view, nil for the storage itself.
+	clients *requestClients
+	// grants records grant types checked through a view, nil for the storage itself.
+	grants *requestGrants
+	// rotated records access tokens refreshed through a view, nil for the storage itself.
+	rotated *rotatedTokens
 }
 
 // NewStorage is constructor for storage of Google Cloud Datastore.
@@ -56,171 +99,572 @@ func NewStorage(ctx context.Context, opts ...datastore.ClientOption) (*Storage,
 	if err != nil {
 		return nil, err
 	}
-	return &Storage{
-		ctx:               ctx,
-		client:            client,
-		clientGetter:      newClientStorage(client),
-		authDataHandler:   newAuthorizeDataStorage(client),
-		accessDataHandler: newAccessDataStorage(client),
-		refreshHandler:    newRefreshStorage(client),
-	}, nil
+	s := newStorage(ctx, client)
+	s.ownsClient = true
+	return s, nil
 }
 
 // NewStorageForGAE is constructor for storage of Google Cloud Datastore.
 // The object created by this constructor uses Google App Engine SDK for Go.
 // If you want to use on other of Google App Engine Standard Edition, you must create object by NewStorage rather than use this.
+// aedatastore builds keys with ctx rather than the context bound by WithContext,
+// so create the storage for each request with App Engine context of the request and close it at the end of the request.
 func NewStorageForGAE(ctx context.Context, opts ...datastore.ClientOption) (*Storage, error) {
 	client, err := aedatastore.FromContext(ctx, opts...)
 	if err != nil {
 		return nil, err
 	}
-	return &Storage{
+	s := newStorage(ctx, client)
+	s.ownsClient = true
+	return s, nil
+}
+
+// NewStorageWithClient is constructor for storage which uses existing datastore client.
+// Use this to share a client with ClientStorage and other code, to add middleware to the client, or to inject fake client in tests.
+// The client is not closed by Close of the storage, so the caller must close it.
+func NewStorageWithClient(ctx context.Context, client datastore.Client, opts ...StorageOption) *Storage {
+	s := newStorage(ctx, client)
+	s.apply(opts)
+	return s
+}
+
+// NewStorageWithHandlers is constructor for storage which uses given handlers for each kind of entities.
+// The handlers can be any mix of implementations, e.g. clients in Datastore with ClientStorage and authorize data in other store.
+// Storage keeps mapping between osin entities and the handlers.
+func NewStorageWithHandlers(ctx context.Context, h Handlers, opts ...StorageOption) *Storage {
+	s := &Storage{
 		ctx:               ctx,
-		client:            client,
-		clientGetter:      newClientStorage(client),
-		authDataHandler:   newAuthorizeDataStorage(client),
-		accessDataHandler: newAccessDataStorage(client),
-		refreshHandler:    newRefreshStorage(client),
-	}, nil
+		clientGetter:      h.Client,
+		authDataHandler:   h.AuthorizeData,
+		accessDataHandler: h.AccessData,
+		refreshHandler:    h.Refresh,
+		auditHandler:      h.Audit,
+	}
+	s.apply(opts)
+	return s
+}
+
+func newStorage(ctx context.Context, client datastore.Client) *Storage {
+	s := NewStorageWithHandlers(ctx, Handlers{
+		Client:        newClientStorage(client),
+		AuthorizeData: NewAuthorizeDataStorage(client),
+		AccessData:    NewAccessDataStorage(client),
+		Refresh:       NewRefreshStorage(client),
+	})
+	s.client = client
+	return s
 }
 
-// Clone is clonning storage instance
+// ClientStorage returns ClientStorage which shares datastore client with the storage.
+// If the storage is created by NewStorageWithHandlers with ClientGetter other than ClientStorage, ClientStorage returns nil.
+func (d *Storage) ClientStorage() *ClientStorage {
+	if cs, ok := unwrapClientGetter(d.clientGetter).(*ClientStorage); ok {
+		return cs
+	}
+	if d.client == nil {
+		return nil
+	}
+	return newClientStorage(d.client)
+}
+
+// Clone returns a lightweight view of the storage which shares datastore connections and context with d.
+// osin calls Clone for each osin.Response, and Close of the view does not release the shared connections.
+// Clients loaded through a view are memoized in the view, and Clone of a view shares them.
 func (d *Storage) Clone() osin.Storage {
-	// TODO investigate some error could be happen.
-	return d
+	v := d.view(d.ctx)
+	if d.clients != nil {
+		v.clients = d.clients
+		v.grants = d.grants
+		v.rotated = d.rotated
+	}
+	return v
+}
+
+// WithContext returns a lightweight view of the storage bound to ctx.
+// The view shares datastore connections with d, and Close of the view does not release them.
+// Use this to run osin flows with context of each request, e.g. set it to osin.Response.Storage.
+func (d *Storage) WithContext(ctx context.Context) *Storage {
+	return d.view(ctx)
+}
+
+func (d *Storage) view(ctx context.Context) *Storage {
+	v := *d
+	v.ctx = ctx
+	v.ownsClient = false
+	v.clients = newRequestClients()
+	v.grants = newRequestGrants()
+	v.rotated = newRotatedTokens()
+	return &v
 }
 
 // Close releases resources used as datastore connections.
-// This method must be call to finish use storage instance.
+// This method must be call to finish use storage instance created by constructors.
+// Close of views created by Clone or WithContext does nothing.
 func (d *Storage) Close() {
-	d.client.Close()
+	if d.ownsClient {
+		d.client.Close()
+	}
 }
 
 // GetClient loads client entity from datastore.
-// If there is no match entity for the id, GetClient returns osin.ErrNotFound.
-func (d *Storage) GetClient(id string) (osin.Client, error) {
-	client, err := d.clientGetter.Get(d.ctx, id)
+// If there is no match entity for the id or the client is suspended, GetClient returns osin.ErrNotFound as is,
+// because osin tells unknown client by comparing the error with it. Other errors are returned as *Error.
+func (d *Storage) GetClient(id string) (client osin.Client, err error) {
+	ctx, o := d.startOperation(OperationGetClient, KindClient, id)
+	defer func() { o.end(err) }()
+	o.setClient(id)
+
+	client, err = d.getClient(ctx, OperationGetClient, id)
+	if errors.Is(err, osin.ErrNotFound) {
+		return nil, osin.ErrNotFound
+	}
+	return client, err
+}
+
+func (d *Storage) getClient(ctx context.Context, op Operation, id string) (osin.Client, error) {
+	client, err := d.loadClient(ctx, id)
 	if err != nil {
-		return nil, errNoEntityOrDefault(err)
+		return nil, errNoEntityOrDefault(op, KindClient, id, err)
+	}
+	if client.Suspended {
+		return nil, newError(op, KindClient, id, ErrClientDisabled)
 	}
 
 	return client, nil
 }
 
+// loadClient loads client through the memo of the view if d is a view.
+func (d *Storage) loadClient(ctx context.Context, id string) (*Client, error) {
+	if d.clients == nil {
+		return d.clientGetter.Get(ctx, id)
+	}
+
+	if c, ok := d.clients.get(id); ok {
+		if c == nil {
+			return nil, osin.ErrNotFound
+		}
+		return c, nil
+	}
+	c, err := d.clientGetter.Get(ctx, id)
+	if err == nil || isNotFound(err) {
+		d.clients.add(id, c)
+	}
+	return c, err
+}
+
 // SaveAuthorize stores authorize data entity to datastore.
-func (d *Storage) SaveAuthorize(auth *osin.AuthorizeData) error {
+// If the authorization is not allowed by policy of the client, SaveAuthorize returns *PolicyError.
+func (d *Storage) SaveAuthorize(auth *osin.AuthorizeData) (err error) {
+	ctx, o := d.startOperation(OperationSaveAuthorize, KindAuthorizeData, auth.Code)
+	defer func() { o.end(err) }()
+	o.setClient(auth.Client.GetId())
+
+	if c, ok := auth.Client.(*Client); ok {
+		if err := c.checkAuthorize(string(osin.CODE), auth.Scope, auth.CodeChallenge, auth.CodeChallengeMethod); err != nil {
+			return err
+		}
+	}
+
 	dauth, err := newAuthorizeDataFrom(auth)
 	if err != nil {
 		return err
 	}
 
-	return d.authDataHandler.put(d.ctx, dauth)
+	if err := d.authDataHandler.Put(ctx, dauth); err != nil {
+		return newError(OperationSaveAuthorize, KindAuthorizeData, dauth.Code, err)
+	}
+	if d.auditHandler == nil {
+		return nil
+	}
+	return d.audit(ctx, OperationSaveAuthorize, NewAuditEvent(AuditCodeIssued, dauth.ClientKey, dauth.UserData, dauth.Scope, dauth.Code))
 }
 
 // LoadAuthorize loads authorize data entity with client entity from datastore.
-// If there is no match entity for the id, LoadAuthorize returns osin.ErrNotFound.
-func (d *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
-	auth, err := d.authDataHandler.get(d.ctx, code)
+// If there is no match entity for the id, LoadAuthorize returns error which is osin.ErrNotFound.
+// Expired authorize code is rejected with error which is ErrExpired, without loading the client.
+func (d *Storage) LoadAuthorize(code string) (_ *osin.AuthorizeData, err error) {
+	ctx, o := d.startOperation(OperationLoadAuthorize, KindAuthorizeData, code)
+	defer func() { o.end(err) }()
+
+	auth, err := d.authDataHandler.Get(ctx, code)
 	if err != nil {
-		return nil, errNoEntityOrDefault(err)
+		return nil, errNoEntityOrDefault(OperationLoadAuthorize, KindAuthorizeData, code, err)
+	}
+	o.setClient(auth.ClientKey)
+	if auth.expired() {
+		return nil, newError(OperationLoadAuthorize, KindAuthorizeData, code, ErrExpired)
 	}
 
-	client, err := d.GetClient(auth.ClientKey)
+	client, err := d.getClient(ctx, OperationLoadAuthorize, auth.ClientKey)
 	if err != nil {
 		return nil, err
 	}
 
-	return &osin.AuthorizeData{
-		Code:                auth.Code,
-		Client:              client,
-		ExpiresIn:           int32(auth.ExpiresIn),
-		Scope:               strings.Join(auth.Scope, " "),
-		RedirectUri:         auth.RedirectURI,
-		State:               auth.State,
-		CreatedAt:           auth.CreatedAt,
-		CodeChallenge:       auth.CodeChallenge,
-		CodeChallengeMethod: auth.CodeChallengeMethod,
-		UserData:            auth.UserData,
-	}, nil
+	return auth.toOsin(client), nil
 }
 
 // RemoveAuthorize delete authorize data from datastore.
-func (d *Storage) RemoveAuthorize(code string) error {
-	return d.authDataHandler.delete(d.ctx, code)
+func (d *Storage) RemoveAuthorize(code string) (err error) {
+	ctx, o := d.startOperation(OperationRemoveAuthorize, KindAuthorizeData, code)
+	defer func() { o.end(err) }()
+
+	if err := d.authDataHandler.Delete(ctx, code); err != nil {
+		return newError(OperationRemoveAuthorize, KindAuthorizeData, code, err)
+	}
+	return nil
 }
 
 // SaveAccess stores accesstoken entity to datastore.
-func (d *Storage) SaveAccess(a *osin.AccessData) error {
+// If the grant is not allowed by policy of the client, SaveAccess returns *PolicyError.
+// Only authorization_code and refresh_token grants can be told from a. Other grants are told from
+// CheckAccessRequest or CheckAuthorizeRequest called with the view, and rejected for client restricting grants if they are not.
+func (d *Storage) SaveAccess(a *osin.AccessData) (err error) {
+	ctx, o := d.startOperation(OperationSaveAccess, KindAccessData, a.AccessToken)
+	defer func() { o.end(err) }()
+	o.setClient(a.Client.GetId())
+
+	if c, ok := a.Client.(*Client); ok {
+		if err := c.checkAccess(d.grantTypeOf(a), a.Scope); err != nil {
+			return err
+		}
+	}
+
 	ad, err := newAccessDataFrom(a)
 	if err != nil {
 		return err
 	}
-	if err := d.accessDataHandler.put(d.ctx, ad); err != nil {
-		return err
+	if d.denormalized {
+		if err := ad.denormalize(a); err != nil {
+			return err
+		}
 	}
 
+	var ref *Refresh
 	if a.RefreshToken != "" {
-		return d.refreshHandler.put(d.ctx, newRefresh(a.RefreshToken, a.AccessToken))
+		ref = newRefresh(a.RefreshToken, a.AccessToken)
+		if d.denormalized {
+			ref.denormalize(ad)
+		}
 	}
 
-	return nil
+	var audits []*AuditEvent
+	if d.auditHandler != nil {
+		if ad.AuthorizeCode != "" {
+			audits = append(audits, NewAuditEvent(AuditCodeExchanged, ad.ClientKey, ad.UserData, ad.Scope, ad.AuthorizeCode))
+		}
+		typ := AuditTokenIssued
+		if a.AccessData != nil {
+			typ = AuditTokenRefreshed
+		}
+		audits = append(audits, NewAuditEvent(typ, ad.ClientKey, ad.UserData, ad.Scope, ad.AccessToken))
+	}
+
+	if d.outbox {
+		typ := TokenEventIssued
+		if a.AccessData != nil {
+			typ = TokenEventRefreshed
+		}
+		var txAudits []*AuditEvent
+		if d.auditInTransaction() {
+			txAudits, audits = audits, nil
+		}
+		if err := d.saveAccessWithOutbox(ctx, ad, ref, newTokenEvent(typ, ad.ClientKey, ad.UserData, ad.AccessToken), txAudits); err != nil {
+			return err
+		}
+	} else {
+		if err := d.accessDataHandler.Put(ctx, ad); err != nil {
+			return newError(OperationSaveAccess, KindAccessData, ad.AccessToken, err)
+		}
+		if ref != nil {
+			if err := d.refreshHandler.Put(ctx, ref); err != nil {
+				return newError(OperationSaveAccess, KindRefresh, ref.RefreshToken, err)
+			}
+		}
+	}
+	if a.AccessData != nil && d.rotated != nil {
+		d.rotated.add(a.AccessData.AccessToken)
+	}
+
+	if len(audits) == 0 {
+		return nil
+	}
+	return d.audit(ctx, OperationSaveAccess, audits...)
 }
 
 // LoadAccess loads accesstoken data entity for access token with authorize data entity and client entity from datastore.
-// If there is no match entity for the access token, LoadAuthorize returns osin.ErrNotFound.
-func (d *Storage) LoadAccess(token string) (*osin.AccessData, error) {
-	ad, err := d.accessDataHandler.get(d.ctx, token)
+// If there is no match entity for the access token, LoadAccess returns error which is osin.ErrNotFound.
+// osin removes authorize data once the code is exchanged, so the token stored with legacy schema is loaded
+// without AuthorizeData if its authorize data no longer exists. Other errors of authorize data are returned as they are.
+// The token of suspended client is rejected with error which is ErrClientDisabled and osin.ErrNotFound.
+// With access cache, the client is still loaded on cache hit, so suspension of the client takes effect immediately.
+func (d *Storage) LoadAccess(token string) (_ *osin.AccessData, err error) {
+	ctx, o := d.startOperation(OperationLoadAccess, KindAccessData, token)
+	defer func() { o.end(err) }()
+
+	a, err := d.loadAccess(ctx, OperationLoadAccess, token)
+	if err != nil {
+		return nil, err
+	}
+	o.setClient(a.Client.GetId())
+	return a, nil
+}
+
+func (d *Storage) loadAccess(ctx context.Context, op Operation, token string) (*osin.AccessData, error) {
+	if d.accessCache != nil {
+		if ad, ok := d.accessCache.Get(ctx, token); ok {
+			return d.loadAccessFrom(ctx, op, ad)
+		}
+	}
+
+	ad, err := d.accessDataHandler.Get(ctx, token)
 	if err != nil {
-		return nil, errNoEntityOrDefault(err)
+		return nil, errNoEntityOrDefault(op, KindAccessData, token, err)
 	}
 
-	client, err := d.GetClient(ad.ClientKey)
+	a, err := d.loadAccessFrom(ctx, op, ad)
 	if err != nil {
 		return nil, err
 	}
+	if d.accessCache != nil {
+		d.cacheAccess(ctx, ad, a)
+	}
+	return a, nil
+}
+
+// cacheAccess caches denormalized copy of ad, so that cache hit needs to load only the client and returns the same as a.
+func (d *Storage) cacheAccess(ctx context.Context, ad *AccessData, a *osin.AccessData) {
+	ttl := accessCacheTTL(ad, d.accessCacheTTL)
+	if ttl <= 0 {
+		return
+	}
+	cached := *ad
+	if err := cached.denormalize(a); err != nil {
+		return
+	}
+	d.accessCache.Set(ctx, ad.AccessToken, &cached, ttl)
+}
+
+// loadAccessFrom builds osin.AccessData from access data entity.
+// Authorize data is loaded from datastore only if ad is stored with legacy schema.
+// Client is always loaded, so that changes of the client such as suspension take effect on issued tokens.
+func (d *Storage) loadAccessFrom(ctx context.Context, op Operation, ad *AccessData) (*osin.AccessData, error) {
+	if ad.AuthorizeCode != "" && !ad.Denormalized {
+		return d.loadLegacyAccessFrom(ctx, op, ad)
+	}
 
-	auth, err := d.LoadAuthorize(ad.AuthorizeCode)
+	client, err := d.getClient(ctx, op, ad.ClientKey)
 	if err != nil {
 		return nil, err
 	}
 
-	return &osin.AccessData{
-		AccessToken:   ad.AccessToken,
-		AuthorizeData: auth,
-		Client:        client,
-		RefreshToken:  ad.RefreshToken,
-		ExpiresIn:     int32(ad.ExpiresIn),
-		Scope:         strings.Join(ad.Scope, " "),
-		RedirectUri:   ad.RedirectURI,
-		CreatedAt:     ad.CreatedAt,
-		UserData:      ad.UserData,
-	}, nil
+	var auth *osin.AuthorizeData
+	if ad.AuthorizeCode != "" {
+		a := ad.Authorize
+		a.Code = ad.AuthorizeCode
+		auth = a.toOsin(client)
+	}
+
+	return ad.toOsin(client, auth), nil
+}
+
+// loadLegacyAccessFrom loads client and authorize data of ad concurrently,
+// and loads client of the authorize data only if it differs from client of ad.
+// Errors are reported in the same order as loading them one by one.
+// osin removes authorize data once the code is exchanged, so the token is loaded without authorize data if it no longer exists.
+func (d *Storage) loadLegacyAccessFrom(ctx context.Context, op Operation, ad *AccessData) (*osin.AccessData, error) {
+	var (
+		wg      sync.WaitGroup
+		auth    *AuthorizeData
+		authErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		auth, authErr = d.authDataHandler.Get(ctx, ad.AuthorizeCode)
+	}()
+	client, err := d.getClient(ctx, op, ad.ClientKey)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if authErr != nil && isNotFound(authErr) {
+		return ad.toOsin(client, nil), nil
+	}
+	if authErr != nil {
+		return nil, errNoEntityOrDefault(op, KindAuthorizeData, ad.AuthorizeCode, authErr)
+	}
+
+	authClient := client
+	if auth.ClientKey != ad.ClientKey {
+		if authClient, err = d.getClient(ctx, op, auth.ClientKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return ad.toOsin(client, auth.toOsin(authClient)), nil
 }
 
 // RemoveAccess delete accesstoken data from datastore.
-func (d *Storage) RemoveAccess(token string) error {
-	return d.accessDataHandler.delete(d.ctx, token)
+// With denormalized schema, RemoveAccess deletes the refresh token of the access token as well,
+// because the refresh token holds copy of the access token and could be used after the access token is removed.
+// RemoveAccess evicts the token from access cache as well.
+// osin removes the old access token by RemoveAccess when the token is refreshed. The view which has saved the refreshed token
+// tells it from revocation, so that it is not observed as revocation, and is audited and written to outbox as rotation.
+func (d *Storage) RemoveAccess(token string) (err error) {
+	ctx, o := d.startOperation(OperationRemoveAccess, KindAccessData, token)
+	defer func() { o.end(err) }()
+	rotated := d.rotated != nil && d.rotated.has(token)
+	auditType := AuditTokenRevoked
+	if rotated {
+		auditType = AuditTokenRotated
+	}
+
+	var ad *AccessData
+	if d.outbox {
+		var txAudit AuditEventType
+		if d.auditInTransaction() {
+			txAudit = auditType
+		}
+		ad, err = d.removeAccessWithOutbox(ctx, token, rotated, txAudit)
+	} else {
+		ad, err = d.removeAccess(ctx, token)
+	}
+	if err != nil {
+		return err
+	}
+	if ad != nil {
+		o.setClient(ad.ClientKey)
+		if !rotated {
+			o.setRevoked()
+		}
+	}
+	if d.accessCache != nil {
+		if err := d.accessCache.Delete(ctx, token); err != nil {
+			return newError(OperationRemoveAccess, KindAccessData, token, err)
+		}
+	}
+
+	if d.auditHandler == nil || d.auditInTransaction() || ad == nil {
+		return nil
+	}
+	return d.audit(ctx, OperationRemoveAccess, NewAuditEvent(auditType, ad.ClientKey, ad.UserData, ad.Scope, token))
+}
+
+// removeAccess removes the access token by handlers, and returns the removed one.
+// The token is read only to remove its refresh token with denormalized schema and to record audit event,
+// so the returned token is nil if it is not read or does not exist.
+func (d *Storage) removeAccess(ctx context.Context, token string) (*AccessData, error) {
+	var ad *AccessData
+	// The token is read to tell whether it exists for observer as well.
+	if d.denormalized || d.auditHandler != nil || d.observer != nil {
+		loaded, err := d.accessDataHandler.Get(ctx, token)
+		if err != nil && !isNotFound(err) {
+			return nil, newError(OperationRemoveAccess, KindAccessData, token, err)
+		}
+		if err == nil {
+			ad = loaded
+		}
+	}
+	if d.denormalized && ad != nil && ad.RefreshToken != "" {
+		if err := d.refreshHandler.Delete(ctx, ad.RefreshToken); err != nil {
+			return nil, newError(OperationRemoveAccess, KindRefresh, ad.RefreshToken, err)
+		}
+	}
+	if err := d.accessDataHandler.Delete(ctx, token); err != nil {
+		return nil, newError(OperationRemoveAccess, KindAccessData, token, err)
+	}
+	return ad, nil
 }
 
 // LoadRefresh loads accesstoken data entity for refresh token with authorize data entity and client entity from datastore.
-// If there is no match entity for the refresh token, LoadAuthorize returns osin.ErrNotFound.
-func (d *Storage) LoadRefresh(token string) (*osin.AccessData, error) {
-	ref, err := d.refreshHandler.get(d.ctx, token)
+// If there is no match entity for the refresh token, LoadRefresh returns error which is osin.ErrNotFound.
+// If the access token of the refresh token has been removed, LoadRefresh returns error which is ErrRevoked and osin.ErrNotFound.
+func (d *Storage) LoadRefresh(token string) (_ *osin.AccessData, err error) {
+	ctx, o := d.startOperation(OperationLoadRefresh, KindRefresh, token)
+	defer func() { o.end(err) }()
+
+	ref, err := d.refreshHandler.Get(ctx, token)
 	if err != nil {
-		return nil, errNoEntityOrDefault(err)
+		return nil, errNoEntityOrDefault(OperationLoadRefresh, KindRefresh, token, err)
 	}
 
-	return d.LoadAccess(ref.AccessToken)
+	var a *osin.AccessData
+	if ref.Denormalized {
+		ad := ref.Access
+		ad.AccessToken = ref.AccessToken
+		a, err = d.loadAccessFrom(ctx, OperationLoadRefresh, &ad)
+	} else {
+		a, err = d.loadAccess(ctx, OperationLoadRefresh, ref.AccessToken)
+		if e, ok := err.(*Error); ok && e.Kind == KindAccessData && isNotFound(e.Err) {
+			return nil, newError(OperationLoadRefresh, KindRefresh, token, ErrRevoked)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	o.setClient(a.Client.GetId())
+	return a, nil
 }
 
 // RemoveRefresh delete refreshtoken data from datastore.
-func (d *Storage) RemoveRefresh(token string) error {
-	return d.refreshHandler.delete(d.ctx, token)
+// With outbox, removal of the refresh token of the token refreshed through the view is written as rotation, as RemoveAccess.
+func (d *Storage) RemoveRefresh(token string) (err error) {
+	ctx, o := d.startOperation(OperationRemoveRefresh, KindRefresh, token)
+	defer func() { o.end(err) }()
+
+	if d.outbox {
+		return d.removeRefreshWithOutbox(ctx, token)
+	}
+	if err := d.refreshHandler.Delete(ctx, token); err != nil {
+		return newError(OperationRemoveRefresh, KindRefresh, token, err)
+	}
+	return nil
 }
 
-func errNoEntityOrDefault(err error) error {
-	if err == datastore.ErrNoSuchEntity {
-		return osin.ErrNotFound
+// rotatedTokens records access tokens refreshed through a view of Storage.
+type rotatedTokens struct {
+	mu     sync.Mutex
+	tokens map[string]bool
+}
+
+func newRotatedTokens() *rotatedTokens {
+	return &rotatedTokens{tokens: make(map[string]bool)}
+}
+
+func (r *rotatedTokens) has(token string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.tokens[token]
+}
+
+func (r *rotatedTokens) add(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens[token] = true
+}
+
+// grantTypeOf returns grant type of a, or grant type recorded to the view for its client if a does not tell it.
+func (d *Storage) grantTypeOf(a *osin.AccessData) string {
+	switch {
+	case a.AccessData != nil:
+		return string(osin.REFRESH_TOKEN)
+	case a.AuthorizeData != nil && a.AuthorizeData.Code != "":
+		return string(osin.AUTHORIZATION_CODE)
+	case d.grants != nil:
+		return d.grants.get(a.Client.GetId())
+	default:
+		return ""
 	}
-	return err
+}
+
+// errNoEntityOrDefault returns *Error keeping err as its cause, which is osin.ErrNotFound if err is datastore.ErrNoSuchEntity.
+func errNoEntityOrDefault(op Operation, kind, key string, err error) error {
+	return newError(op, kind, key, err)
+}
+
+// isNotFound reports whether err is missing entity reported by Datastore or by a handler of other backend.
+func isNotFound(err error) bool {
+	return errors.Is(err, datastore.ErrNoSuchEntity) || errors.Is(err, osin.ErrNotFound)
 }
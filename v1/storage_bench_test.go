@@ -0,0 +1,190 @@
+package datastore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+
+	"go.mercari.io/datastore"
+)
+
+// countingHandlers is in-memory implementation of handlers which counts reads as Datastore RPCs.
+type countingHandlers struct {
+	rpcs     int64
+	clients  map[string]Client
+	auths    map[string]AuthorizeData
+	accesses map[string]AccessData
+	refreshs map[string]Refresh
+}
+
+func newCountingHandlers() *countingHandlers {
+	return &countingHandlers{
+		clients:  make(map[string]Client),
+		auths:    make(map[string]AuthorizeData),
+		accesses: make(map[string]AccessData),
+		refreshs: make(map[string]Refresh),
+	}
+}
+
+func (h *countingHandlers) handlers() Handlers {
+	return Handlers{
+		Client:        countingClients{h},
+		AuthorizeData: countingAuths{h},
+		AccessData:    countingAccesses{h},
+		Refresh:       countingRefreshs{h},
+	}
+}
+
+type (
+	countingClients  struct{ *countingHandlers }
+	countingAuths    struct{ *countingHandlers }
+	countingAccesses struct{ *countingHandlers }
+	countingRefreshs struct{ *countingHandlers }
+)
+
+func (h countingClients) Get(_ context.Context, id string) (*Client, error) {
+	atomic.AddInt64(&h.rpcs, 1)
+	c, ok := h.clients[id]
+	if !ok {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return &c, nil
+}
+
+func (h countingAuths) Put(_ context.Context, a *AuthorizeData) error {
+	h.auths[a.Code] = *a
+	return nil
+}
+
+func (h countingAuths) Get(_ context.Context, code string) (*AuthorizeData, error) {
+	atomic.AddInt64(&h.rpcs, 1)
+	a, ok := h.auths[code]
+	if !ok {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return &a, nil
+}
+
+func (h countingAuths) Delete(_ context.Context, code string) error {
+	delete(h.auths, code)
+	return nil
+}
+
+func (h countingAccesses) Put(_ context.Context, a *AccessData) error {
+	h.accesses[a.AccessToken] = *a
+	return nil
+}
+
+func (h countingAccesses) Get(_ context.Context, token string) (*AccessData, error) {
+	atomic.AddInt64(&h.rpcs, 1)
+	a, ok := h.accesses[token]
+	if !ok {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return &a, nil
+}
+
+func (h countingAccesses) Delete(_ context.Context, token string) error {
+	delete(h.accesses, token)
+	return nil
+}
+
+func (h countingRefreshs) Put(_ context.Context, r *Refresh) error {
+	h.refreshs[r.RefreshToken] = *r
+	return nil
+}
+
+func (h countingRefreshs) Get(_ context.Context, token string) (*Refresh, error) {
+	atomic.AddInt64(&h.rpcs, 1)
+	r, ok := h.refreshs[token]
+	if !ok {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return &r, nil
+}
+
+func (h countingRefreshs) Delete(_ context.Context, token string) error {
+	delete(h.refreshs, token)
+	return nil
+}
+
+func benchmarkStorageSetup(b *testing.B, opts ...StorageOption) (*Storage, *countingHandlers) {
+	h := newCountingHandlers()
+	h.clients["client"] = Client{ID: "client", Secret: "secret"}
+
+	storage := NewStorageWithHandlers(context.Background(), h.handlers(), opts...)
+	client := &Client{ID: "client", Secret: "secret"}
+	auth := &osin.AuthorizeData{
+		Code:      "code",
+		Client:    client,
+		ExpiresIn: 60,
+		Scope:     "scope",
+		CreatedAt: time.Now(),
+	}
+	if err := storage.SaveAuthorize(auth); err != nil {
+		b.Fatal(err)
+	}
+	err := storage.SaveAccess(&osin.AccessData{
+		AccessToken:   "token",
+		RefreshToken:  "refresh",
+		AuthorizeData: auth,
+		Client:        client,
+		ExpiresIn:     3600,
+		Scope:         "scope",
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	h.rpcs = 0
+	return storage, h
+}
+
+func BenchmarkStorage_LoadAccess(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		opts []StorageOption
+	}{
+		{name: "legacy"},
+		{name: "denormalized", opts: []StorageOption{WithDenormalizedTokens()}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			storage, h := benchmarkStorageSetup(b, bm.opts...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.LoadAccess("token"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(h.rpcs)/float64(b.N), "rpcs/op")
+		})
+	}
+}
+
+func BenchmarkStorage_LoadRefresh(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		opts []StorageOption
+	}{
+		{name: "legacy"},
+		{name: "denormalized", opts: []StorageOption{WithDenormalizedTokens()}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			storage, h := benchmarkStorageSetup(b, bm.opts...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.LoadRefresh("refresh"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(h.rpcs)/float64(b.N), "rpcs/op")
+		})
+	}
+}
@@ -1,14 +1,103 @@
 package datastore
 
 import (
+	"context"
+	"errors"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/RangelReale/osin"
 	"github.com/golang/mock/gomock"
+
+	"go.mercari.io/datastore"
 )
 
+func TestNewStorageWithClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDSClient := NewMockClient(ctrl)
+	mockDSClient.EXPECT().Close().Times(0)
+
+	storage := NewStorageWithClient(context.Background(), mockDSClient)
+	if cs := storage.ClientStorage(); cs.client != mockDSClient {
+		t.Errorf("ClientStorage must share datastore client\nwant: %#v\n got: %#v", mockDSClient, cs.client)
+	}
+
+	// The storage must not close the client given by the caller.
+	storage.Close()
+}
+
+func TestNewStorageWithHandlers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mch  = NewMockClientGetter(ctrl)
+		mauh = NewMockAuthorizeDataHandler(ctrl)
+	)
+	mauh.EXPECT().Get(gomock.Any(), "code").Return(&AuthorizeData{Code: "code", ClientKey: "client"}, nil)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(&Client{ID: "client"}, nil)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{
+		Client:        mch,
+		AuthorizeData: mauh,
+		AccessData:    NewMockAccessDataHandler(ctrl),
+		Refresh:       NewMockRefreshHandler(ctrl),
+	})
+
+	got, err := storage.LoadAuthorize("code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &osin.AuthorizeData{Code: "code", Client: &Client{ID: "client"}, UserData: ""}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got)
+	}
+	if cs := storage.ClientStorage(); cs != nil {
+		t.Errorf("want nil ClientStorage, got: %#v", cs)
+	}
+	storage.Close()
+}
+
+func TestStorage_Close(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDSClient := NewMockClient(ctrl)
+	mockDSClient.EXPECT().Close().Return(nil).Times(1)
+
+	storage := &Storage{ctx: context.Background(), client: mockDSClient, ownsClient: true}
+
+	// Close of views must not release the shared client.
+	storage.Clone().Close()
+	storage.WithContext(context.Background()).Close()
+
+	storage.Close()
+}
+
+func TestStorage_WithContext(t *testing.T) {
+	type ctxKey struct{}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	mch := NewMockClientGetter(ctrl)
+	mch.EXPECT().Get(ctx, "client").Return(&Client{ID: "client"}, nil)
+
+	storage := &Storage{ctx: context.Background(), clientGetter: mch}
+
+	view := storage.WithContext(ctx)
+	if _, err := view.Clone().GetClient("client"); err != nil {
+		t.Fatal(err)
+	}
+	if storage.ctx == ctx {
+		t.Error("WithContext must not change context of original storage")
+	}
+}
+
 func TestStorage_GetClient(t *testing.T) {
 	type (
 		in struct {
@@ -45,7 +134,7 @@ func TestStorage_GetClient(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
-			mch := NewMockclientGetter(ctrl)
+			mch := NewMockClientGetter(ctrl)
 			mch.EXPECT().Get(gomock.Any(), tt.in.id).Return(tt.out.client, nil)
 
 			storage := &Storage{clientGetter: mch}
@@ -61,6 +150,24 @@ func TestStorage_GetClient(t *testing.T) {
 	}
 }
 
+func TestStorage_GetClient_Suspended(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mch := NewMockClientGetter(ctrl)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(&Client{ID: "client", Suspended: true}, nil)
+
+	storage := &Storage{clientGetter: mch}
+
+	got, err := storage.GetClient("client")
+	if err != osin.ErrNotFound {
+		t.Errorf("return error\nwant: %#v\n got: %#v", osin.ErrNotFound, err)
+	}
+	if got != nil {
+		t.Errorf("want nil client, got: %#v", got)
+	}
+}
+
 func TestStorage_SaveAuthorize(t *testing.T) {
 	type (
 		in struct {
@@ -68,7 +175,7 @@ func TestStorage_SaveAuthorize(t *testing.T) {
 		}
 
 		args struct {
-			authorize *authorizeData
+			authorize *AuthorizeData
 		}
 	)
 	createdAt := time.Now()
@@ -95,7 +202,7 @@ func TestStorage_SaveAuthorize(t *testing.T) {
 				},
 			},
 			args: args{
-				authorize: &authorizeData{
+				authorize: &AuthorizeData{
 					Code:                "code",
 					ClientKey:           "client",
 					ExpiresIn:           1,
@@ -116,8 +223,8 @@ func TestStorage_SaveAuthorize(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
-			mauh := NewMockauthDataHandler(ctrl)
-			mauh.EXPECT().put(gomock.Any(), tt.args.authorize).Return(nil)
+			mauh := NewMockAuthorizeDataHandler(ctrl)
+			mauh.EXPECT().Put(gomock.Any(), tt.args.authorize).Return(nil)
 
 			storage := &Storage{authDataHandler: mauh}
 
@@ -128,6 +235,74 @@ func TestStorage_SaveAuthorize(t *testing.T) {
 	}
 }
 
+func TestStorage_SaveAuthorize_PolicyViolation(t *testing.T) {
+	type (
+		in struct {
+			authorize *osin.AuthorizeData
+		}
+
+		out struct {
+			code string
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "disallowed scope",
+			in: in{
+				authorize: &osin.AuthorizeData{
+					Code:   "code",
+					Client: &Client{ID: "client", AllowedScopes: []string{"scope1"}},
+					Scope:  "scope1 scope2",
+				},
+			},
+			out: out{code: osin.E_INVALID_SCOPE},
+		},
+		{
+			testName: "disallowed response type",
+			in: in{
+				authorize: &osin.AuthorizeData{
+					Code:   "code",
+					Client: &Client{ID: "client", AllowedResponseTypes: []string{"token"}},
+				},
+			},
+			out: out{code: osin.E_UNAUTHORIZED_CLIENT},
+		},
+		{
+			testName: "missing pkce",
+			in: in{
+				authorize: &osin.AuthorizeData{
+					Code:   "code",
+					Client: &Client{ID: "client", RequirePKCE: true},
+				},
+			},
+			out: out{code: osin.E_INVALID_REQUEST},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storage := &Storage{authDataHandler: NewMockAuthorizeDataHandler(ctrl)}
+
+			err := storage.SaveAuthorize(tt.in.authorize)
+			perr, ok := err.(*PolicyError)
+			if !ok {
+				t.Fatalf("want *PolicyError, got: %#v", err)
+			}
+			if perr.Code != tt.out.code {
+				t.Errorf("code\nwant: %q\n got: %q", tt.out.code, perr.Code)
+			}
+		})
+	}
+}
+
 func TestStorage_LoadAuthorize(t *testing.T) {
 	type (
 		in struct {
@@ -135,7 +310,7 @@ func TestStorage_LoadAuthorize(t *testing.T) {
 		}
 
 		returns struct {
-			authorize *authorizeData
+			authorize *AuthorizeData
 			client    *Client
 		}
 
@@ -163,7 +338,7 @@ func TestStorage_LoadAuthorize(t *testing.T) {
 				},
 			},
 			returns: returns{
-				authorize: &authorizeData{Code: "auth", ClientKey: "client"},
+				authorize: &AuthorizeData{Code: "auth", ClientKey: "client"},
 				client:    &Client{ID: "client"},
 			},
 		},
@@ -175,10 +350,10 @@ func TestStorage_LoadAuthorize(t *testing.T) {
 			defer ctrl.Finish()
 
 			var (
-				mauh = NewMockauthDataHandler(ctrl)
-				mch  = NewMockclientGetter(ctrl)
+				mauh = NewMockAuthorizeDataHandler(ctrl)
+				mch  = NewMockClientGetter(ctrl)
 			)
-			mauh.EXPECT().get(gomock.Any(), tt.in.code).Return(tt.returns.authorize, nil)
+			mauh.EXPECT().Get(gomock.Any(), tt.in.code).Return(tt.returns.authorize, nil)
 			mch.EXPECT().Get(gomock.Any(), tt.returns.authorize.ClientKey).Return(tt.returns.client, nil)
 
 			storage := &Storage{
@@ -220,8 +395,8 @@ func TestStorage_RemoveAuthorize(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
-			mauh := NewMockauthDataHandler(ctrl)
-			mauh.EXPECT().delete(gomock.Any(), tt.in.code).Return(nil)
+			mauh := NewMockAuthorizeDataHandler(ctrl)
+			mauh.EXPECT().Delete(gomock.Any(), tt.in.code).Return(nil)
 
 			storage := &Storage{authDataHandler: mauh}
 
@@ -239,7 +414,7 @@ func TestStorage_SaveAccess(t *testing.T) {
 		}
 
 		args struct {
-			access *accessData
+			access *AccessData
 		}
 	)
 	createdAt := time.Now()
@@ -265,7 +440,7 @@ func TestStorage_SaveAccess(t *testing.T) {
 				},
 			},
 			args: args{
-				access: &accessData{
+				access: &AccessData{
 					AccessToken:       "token",
 					ParentAccessToken: "token2",
 					ClientKey:         "client",
@@ -286,8 +461,8 @@ func TestStorage_SaveAccess(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
-			mach := NewMockaccessDataHandler(ctrl)
-			mach.EXPECT().put(gomock.Any(), tt.args.access).Return(nil)
+			mach := NewMockAccessDataHandler(ctrl)
+			mach.EXPECT().Put(gomock.Any(), tt.args.access).Return(nil)
 
 			storage := &Storage{accessDataHandler: mach}
 
@@ -305,7 +480,7 @@ func TestStorage_SaveAccess_WithRefreshToken(t *testing.T) {
 		}
 
 		args struct {
-			refresh *refresh
+			refresh *Refresh
 		}
 	)
 
@@ -325,7 +500,7 @@ func TestStorage_SaveAccess_WithRefreshToken(t *testing.T) {
 				},
 			},
 			args: args{
-				refresh: &refresh{
+				refresh: &Refresh{
 					RefreshToken: "refresh",
 					AccessToken:  "token",
 				},
@@ -339,11 +514,11 @@ func TestStorage_SaveAccess_WithRefreshToken(t *testing.T) {
 			defer ctrl.Finish()
 
 			var (
-				mach = NewMockaccessDataHandler(ctrl)
-				mrh  = NewMockrefreshHandler(ctrl)
+				mach = NewMockAccessDataHandler(ctrl)
+				mrh  = NewMockRefreshHandler(ctrl)
 			)
-			mach.EXPECT().put(gomock.Any(), gomock.Any()).Return(nil)
-			mrh.EXPECT().put(gomock.Any(), tt.args.refresh).Return(nil)
+			mach.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+			mrh.EXPECT().Put(gomock.Any(), tt.args.refresh).Return(nil)
 
 			storage := &Storage{
 				accessDataHandler: mach,
@@ -357,6 +532,95 @@ func TestStorage_SaveAccess_WithRefreshToken(t *testing.T) {
 	}
 }
 
+func TestStorage_SaveAccess_PolicyViolation(t *testing.T) {
+	type (
+		in struct {
+			access *osin.AccessData
+		}
+
+		out struct {
+			code string
+		}
+	)
+
+	tests := []struct {
+		testName string
+		in       in
+		out      out
+	}{
+		{
+			testName: "disallowed refresh",
+			in: in{
+				access: &osin.AccessData{
+					AccessToken: "token",
+					AccessData:  &osin.AccessData{AccessToken: "token2"},
+					Client:      &Client{ID: "client", AllowedGrantTypes: []string{"authorization_code"}},
+				},
+			},
+			out: out{code: osin.E_UNAUTHORIZED_CLIENT},
+		},
+		{
+			testName: "disallowed scope",
+			in: in{
+				access: &osin.AccessData{
+					AccessToken: "token",
+					Client:      &Client{ID: "client", AllowedScopes: []string{"scope1"}},
+					Scope:       "scope2",
+				},
+			},
+			out: out{code: osin.E_INVALID_SCOPE},
+		},
+		{
+			testName: "unknown grant of client restricting grant types",
+			in: in{
+				access: &osin.AccessData{
+					AccessToken: "token",
+					Client:      &Client{ID: "client", AllowedGrantTypes: []string{"authorization_code"}},
+				},
+			},
+			out: out{code: osin.E_UNAUTHORIZED_CLIENT},
+		},
+		{
+			testName: "unknown grant of public client",
+			in: in{
+				access: &osin.AccessData{
+					AccessToken: "token",
+					Client:      &Client{ID: "client", Type: ClientTypePublic},
+				},
+			},
+			out: out{code: osin.E_UNAUTHORIZED_CLIENT},
+		},
+		{
+			testName: "unknown grant of client restricting response types",
+			in: in{
+				access: &osin.AccessData{
+					AccessToken: "token",
+					Client:      &Client{ID: "client", AllowedResponseTypes: []string{"code"}},
+				},
+			},
+			out: out{code: osin.E_UNAUTHORIZED_CLIENT},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			storage := (&Storage{accessDataHandler: NewMockAccessDataHandler(ctrl)}).WithContext(context.Background())
+
+			err := storage.SaveAccess(tt.in.access)
+			perr, ok := err.(*PolicyError)
+			if !ok {
+				t.Fatalf("want *PolicyError, got: %#v", err)
+			}
+			if perr.Code != tt.out.code {
+				t.Errorf("code\nwant: %q\n got: %q", tt.out.code, perr.Code)
+			}
+		})
+	}
+}
+
 func TestStorage_LoadAccess(t *testing.T) {
 	type (
 		in struct {
@@ -364,8 +628,8 @@ func TestStorage_LoadAccess(t *testing.T) {
 		}
 
 		returns struct {
-			access *accessData
-			auth   *authorizeData
+			access *AccessData
+			auth   *AuthorizeData
 			client *Client
 		}
 
@@ -398,12 +662,39 @@ func TestStorage_LoadAccess(t *testing.T) {
 				},
 			},
 			returns: returns{
-				access: &accessData{
+				access: &AccessData{
+					AccessToken:   "token",
+					ClientKey:     "client",
+					AuthorizeCode: "auth",
+				},
+				auth:   &AuthorizeData{Code: "auth", ClientKey: "a_client"},
+				client: &Client{ID: "client"},
+			},
+		},
+		{
+			testName: "same client",
+			in: in{
+				token: "token",
+			},
+			out: out{
+				access: &osin.AccessData{
+					Client: &Client{ID: "client"},
+					AuthorizeData: &osin.AuthorizeData{
+						Code:     "auth",
+						Client:   &Client{ID: "client"},
+						UserData: "",
+					},
+					AccessToken: "token",
+					UserData:    "",
+				},
+			},
+			returns: returns{
+				access: &AccessData{
 					AccessToken:   "token",
 					ClientKey:     "client",
 					AuthorizeCode: "auth",
 				},
-				auth:   &authorizeData{Code: "auth", ClientKey: "a_client"},
+				auth:   &AuthorizeData{Code: "auth", ClientKey: "client"},
 				client: &Client{ID: "client"},
 			},
 		},
@@ -414,14 +705,16 @@ func TestStorage_LoadAccess(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 			var (
-				mach = NewMockaccessDataHandler(ctrl)
-				mch  = NewMockclientGetter(ctrl)
-				mauh = NewMockauthDataHandler(ctrl)
+				mach = NewMockAccessDataHandler(ctrl)
+				mch  = NewMockClientGetter(ctrl)
+				mauh = NewMockAuthorizeDataHandler(ctrl)
 			)
-			mach.EXPECT().get(gomock.Any(), tt.in.token).Return(tt.returns.access, nil)
+			mach.EXPECT().Get(gomock.Any(), tt.in.token).Return(tt.returns.access, nil)
 			mch.EXPECT().Get(gomock.Any(), tt.returns.access.ClientKey).Return(tt.returns.client, nil)
-			mauh.EXPECT().get(gomock.Any(), tt.returns.access.AuthorizeCode).Return(tt.returns.auth, nil)
-			mch.EXPECT().Get(gomock.Any(), tt.returns.auth.ClientKey).Return(tt.returns.client, nil)
+			mauh.EXPECT().Get(gomock.Any(), tt.returns.access.AuthorizeCode).Return(tt.returns.auth, nil)
+			if tt.returns.auth.ClientKey != tt.returns.access.ClientKey {
+				mch.EXPECT().Get(gomock.Any(), tt.returns.auth.ClientKey).Return(tt.returns.client, nil)
+			}
 
 			storage := &Storage{
 				accessDataHandler: mach,
@@ -449,6 +742,125 @@ func TestStorage_LoadAccess(t *testing.T) {
 	}
 }
 
+func TestStorage_LoadAccess_WithoutAuthorizeCode(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach = NewMockAccessDataHandler(ctrl)
+		mch  = NewMockClientGetter(ctrl)
+	)
+	mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{AccessToken: "token", ClientKey: "client"}, nil)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(&Client{ID: "client"}, nil)
+
+	storage := &Storage{
+		accessDataHandler: mach,
+		clientGetter:      mch,
+	}
+
+	got, err := storage.LoadAccess("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &osin.AccessData{
+		AccessToken: "token",
+		Client:      &Client{ID: "client"},
+		UserData:    "",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+func TestStorage_LoadAccess_SuspendedClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach = NewMockAccessDataHandler(ctrl)
+		mch  = NewMockClientGetter(ctrl)
+		mauh = NewMockAuthorizeDataHandler(ctrl)
+	)
+	mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{AccessToken: "token", ClientKey: "client", AuthorizeCode: "auth"}, nil)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(&Client{ID: "client", Suspended: true}, nil)
+	// Authorize data is loaded concurrently with the client.
+	mauh.EXPECT().Get(gomock.Any(), "auth").Return(&AuthorizeData{Code: "auth", ClientKey: "client"}, nil).MaxTimes(1)
+
+	storage := &Storage{
+		accessDataHandler: mach,
+		clientGetter:      mch,
+		authDataHandler:   mauh,
+	}
+
+	_, err := storage.LoadAccess("token")
+	if !errors.Is(err, osin.ErrNotFound) || !errors.Is(err, ErrClientDisabled) {
+		t.Errorf("return error\nwant: %#v\n got: %#v", ErrClientDisabled, err)
+	}
+}
+
+func TestStorage_LoadAccess_RemovedAuthorize(t *testing.T) {
+	client := &Client{ID: "client"}
+	errRPC := errors.New("rpc error")
+
+	tests := []struct {
+		testName string
+		authErr  error
+		out      *osin.AccessData
+	}{
+		{
+			testName: "removed from datastore",
+			authErr:  datastore.ErrNoSuchEntity,
+			out:      &osin.AccessData{AccessToken: "token", Client: client, Scope: "read", UserData: ""},
+		},
+		{
+			testName: "removed from other backend",
+			authErr:  osin.ErrNotFound,
+			out:      &osin.AccessData{AccessToken: "token", Client: client, Scope: "read", UserData: ""},
+		},
+		{
+			testName: "error",
+			authErr:  errRPC,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				mach = NewMockAccessDataHandler(ctrl)
+				mch  = NewMockClientGetter(ctrl)
+				mauh = NewMockAuthorizeDataHandler(ctrl)
+			)
+			mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{AccessToken: "token", ClientKey: "client", AuthorizeCode: "auth", Scope: []string{"read"}}, nil)
+			mch.EXPECT().Get(gomock.Any(), "client").Return(client, nil)
+			mauh.EXPECT().Get(gomock.Any(), "auth").Return(nil, tt.authErr)
+
+			storage := &Storage{
+				accessDataHandler: mach,
+				clientGetter:      mch,
+				authDataHandler:   mauh,
+			}
+
+			got, err := storage.LoadAccess("token")
+			if tt.out == nil {
+				var e *Error
+				if !errors.As(err, &e) || e.Kind != KindAuthorizeData || !errors.Is(err, errRPC) || errors.Is(err, osin.ErrNotFound) {
+					t.Errorf("return error\nwant: error of %s caused by %#v\n got: %#v", KindAuthorizeData, errRPC, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.out, got) {
+				t.Errorf("\nwant: %#v\n got: %#v", tt.out, got)
+			}
+		})
+	}
+}
+
 func TestStorage_RemoveAccess(t *testing.T) {
 	type (
 		in struct {
@@ -473,8 +885,8 @@ func TestStorage_RemoveAccess(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
-			mach := NewMockaccessDataHandler(ctrl)
-			mach.EXPECT().delete(gomock.Any(), tt.in.token).Return(nil)
+			mach := NewMockAccessDataHandler(ctrl)
+			mach.EXPECT().Delete(gomock.Any(), tt.in.token).Return(nil)
 
 			storage := &Storage{accessDataHandler: mach}
 
@@ -496,9 +908,9 @@ func TestStorage_LoadRefresh(t *testing.T) {
 		}
 
 		returns struct {
-			refresh      *refresh
-			access       *accessData
-			authorize    *authorizeData
+			refresh      *Refresh
+			access       *AccessData
+			authorize    *AuthorizeData
 			accessClient *Client
 			authClient   *Client
 		}
@@ -534,7 +946,7 @@ func TestStorage_LoadRefresh(t *testing.T) {
 				},
 			},
 			returns: returns{
-				access: &accessData{
+				access: &AccessData{
 					AccessToken:   "token",
 					AuthorizeCode: "auth",
 					ClientKey:     "client",
@@ -545,11 +957,11 @@ func TestStorage_LoadRefresh(t *testing.T) {
 					CreatedAt:     createdAt,
 					UserData:      "user_data",
 				},
-				refresh: &refresh{
+				refresh: &Refresh{
 					RefreshToken: "refresh_token",
 					AccessToken:  "token",
 				},
-				authorize: &authorizeData{
+				authorize: &AuthorizeData{
 					Code:      "auth",
 					ClientKey: "a_client",
 				},
@@ -565,15 +977,15 @@ func TestStorage_LoadRefresh(t *testing.T) {
 			defer ctrl.Finish()
 
 			var (
-				mrh  = NewMockrefreshHandler(ctrl)
-				mach = NewMockaccessDataHandler(ctrl)
-				mch  = NewMockclientGetter(ctrl)
-				mauh = NewMockauthDataHandler(ctrl)
+				mrh  = NewMockRefreshHandler(ctrl)
+				mach = NewMockAccessDataHandler(ctrl)
+				mch  = NewMockClientGetter(ctrl)
+				mauh = NewMockAuthorizeDataHandler(ctrl)
 			)
-			mrh.EXPECT().get(gomock.Any(), tt.in.refreshToken).Return(tt.returns.refresh, nil)
-			mach.EXPECT().get(gomock.Any(), tt.returns.refresh.AccessToken).Return(tt.returns.access, nil)
+			mrh.EXPECT().Get(gomock.Any(), tt.in.refreshToken).Return(tt.returns.refresh, nil)
+			mach.EXPECT().Get(gomock.Any(), tt.returns.refresh.AccessToken).Return(tt.returns.access, nil)
 			mch.EXPECT().Get(gomock.Any(), tt.returns.access.ClientKey).Return(tt.returns.accessClient, nil)
-			mauh.EXPECT().get(gomock.Any(), tt.returns.access.AuthorizeCode).Return(tt.returns.authorize, nil)
+			mauh.EXPECT().Get(gomock.Any(), tt.returns.access.AuthorizeCode).Return(tt.returns.authorize, nil)
 			mch.EXPECT().Get(gomock.Any(), tt.returns.authorize.ClientKey).Return(tt.returns.authClient, nil)
 
 			storage := &Storage{
@@ -627,8 +1039,8 @@ func TestStorage_RemoveRefresh(t *testing.T) {
 			ctrl := gomock.NewController(t)
 			defer ctrl.Finish()
 
-			mrh := NewMockrefreshHandler(ctrl)
-			mrh.EXPECT().delete(gomock.Any(), tt.in.refreshToken).Return(nil)
+			mrh := NewMockRefreshHandler(ctrl)
+			mrh.EXPECT().Delete(gomock.Any(), tt.in.refreshToken).Return(nil)
 
 			storage := &Storage{refreshHandler: mrh}
 
@@ -638,3 +1050,158 @@ func TestStorage_RemoveRefresh(t *testing.T) {
 		})
 	}
 }
+
+func TestStorage_SaveAccess_Denormalized(t *testing.T) {
+	createdAt := time.Now()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach = NewMockAccessDataHandler(ctrl)
+		mrh  = NewMockRefreshHandler(ctrl)
+	)
+	wantAccess := &AccessData{
+		AccessToken:   "token",
+		ClientKey:     "client",
+		AuthorizeCode: "code",
+		RefreshToken:  "refresh",
+		ExpiresIn:     1,
+		Scope:         []string{"scope1"},
+		CreatedAt:     createdAt,
+		Denormalized:  true,
+		Authorize: AuthorizeData{
+			Code:        "code",
+			ClientKey:   "client",
+			ExpiresIn:   2,
+			Scope:       []string{"scope1"},
+			RedirectURI: "redirect",
+			CreatedAt:   createdAt,
+		},
+	}
+	mach.EXPECT().Put(gomock.Any(), wantAccess).Return(nil)
+	mrh.EXPECT().Put(gomock.Any(), &Refresh{
+		RefreshToken: "refresh",
+		AccessToken:  "token",
+		Denormalized: true,
+		Access:       *wantAccess,
+	}).Return(nil)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{
+		AccessData: mach,
+		Refresh:    mrh,
+	}, WithDenormalizedTokens())
+
+	err := storage.SaveAccess(&osin.AccessData{
+		AccessToken: "token",
+		AuthorizeData: &osin.AuthorizeData{
+			Code:        "code",
+			Client:      &Client{ID: "client"},
+			ExpiresIn:   2,
+			Scope:       "scope1",
+			RedirectUri: "redirect",
+			CreatedAt:   createdAt,
+		},
+		Client:       &Client{ID: "client"},
+		RefreshToken: "refresh",
+		ExpiresIn:    1,
+		Scope:        "scope1",
+		CreatedAt:    createdAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage_LoadRefresh_Denormalized(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mrh = NewMockRefreshHandler(ctrl)
+		mch = NewMockClientGetter(ctrl)
+	)
+	// Only refresh token and client are loaded.
+	mrh.EXPECT().Get(gomock.Any(), "refresh").Return(&Refresh{
+		RefreshToken: "refresh",
+		AccessToken:  "token",
+		Denormalized: true,
+		Access: AccessData{
+			ClientKey:     "client",
+			AuthorizeCode: "code",
+			RefreshToken:  "refresh",
+			Denormalized:  true,
+			Authorize:     AuthorizeData{ClientKey: "client", State: "state"},
+		},
+	}, nil)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(&Client{ID: "client"}, nil)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{
+		Client:        mch,
+		AuthorizeData: NewMockAuthorizeDataHandler(ctrl),
+		AccessData:    NewMockAccessDataHandler(ctrl),
+		Refresh:       mrh,
+	}, WithDenormalizedTokens())
+
+	got, err := storage.LoadRefresh("refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &osin.AccessData{
+		AccessToken: "token",
+		AuthorizeData: &osin.AuthorizeData{
+			Code:     "code",
+			Client:   &Client{ID: "client"},
+			State:    "state",
+			UserData: "",
+		},
+		Client:       &Client{ID: "client"},
+		RefreshToken: "refresh",
+		UserData:     "",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+func TestStorage_RemoveAccess_Denormalized(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		mach = NewMockAccessDataHandler(ctrl)
+		mrh  = NewMockRefreshHandler(ctrl)
+	)
+	mach.EXPECT().Get(gomock.Any(), "token").Return(&AccessData{AccessToken: "token", RefreshToken: "refresh"}, nil)
+	mrh.EXPECT().Delete(gomock.Any(), "refresh").Return(nil)
+	mach.EXPECT().Delete(gomock.Any(), "token").Return(nil)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{
+		AccessData: mach,
+		Refresh:    mrh,
+	}, WithDenormalizedTokens())
+
+	if err := storage.RemoveAccess("token"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage_GetClient_MemoizedInView(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mch := NewMockClientGetter(ctrl)
+	mch.EXPECT().Get(gomock.Any(), "client").Return(&Client{ID: "client"}, nil)
+	mch.EXPECT().Get(gomock.Any(), "unknown").Return(nil, datastore.ErrNoSuchEntity)
+
+	storage := NewStorageWithHandlers(context.Background(), Handlers{Client: mch})
+	view := storage.WithContext(context.Background())
+	for i := 0; i < 2; i++ {
+		if _, err := view.Clone().GetClient("client"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := view.GetClient("unknown"); err != osin.ErrNotFound {
+			t.Errorf("return error\nwant: %#v\n got: %#v", osin.ErrNotFound, err)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+// Package storagetest provides conformance tests of osin.Storage, which check the behavior osin relies on.
+//
+// Run the tests in a test of the storage with Factory which creates empty storage holding given clients:
+//
+//	func TestStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T, clients ...osin.Client) osin.Storage {
+//			return newStorageWithClients(t, clients)
+//		})
+//	}
+//
+// Besides round trips of each grant type, the tests expect that missing entities are reported with error which is osin.ErrNotFound
+// (GetClient returns osin.ErrNotFound as is), removal of missing entities succeeds,
+// and tokens remain loadable after osin removes the authorize code they are issued with.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+)
+
+// Factory creates empty storage for a test, where GetClient finds clients.
+// UserData of the clients and of tokens saved by the tests is string. The tests close the storage.
+type Factory func(t *testing.T, clients ...osin.Client) osin.Storage
+
+// Run runs the conformance tests of storages created by factory as subtests of t.
+func Run(t *testing.T, factory Factory) {
+	t.Run("GetClient", func(t *testing.T) { testGetClient(t, factory) })
+	t.Run("AuthorizationCode", func(t *testing.T) { testAuthorizationCode(t, factory) })
+	t.Run("AccessGrants", func(t *testing.T) { testAccessGrants(t, factory) })
+	t.Run("RefreshChain", func(t *testing.T) { testRefreshChain(t, factory) })
+	t.Run("NotFound", func(t *testing.T) { testNotFound(t, factory) })
+	t.Run("RemoveAccess", func(t *testing.T) { testRemoveAccess(t, factory) })
+	t.Run("RemoveRefresh", func(t *testing.T) { testRemoveRefresh(t, factory) })
+	t.Run("Clone", func(t *testing.T) { testClone(t, factory) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, factory) })
+}
+
+const clientID = "storagetest-client"
+
+func newClient(id string) *osin.DefaultClient {
+	return &osin.DefaultClient{
+		Id:          id,
+		Secret:      id + "-secret",
+		RedirectUri: "http://localhost/" + id,
+		UserData:    id + "-data",
+	}
+}
+
+// newStorage creates storage holding the test client, and returns the client loaded from it as osin does.
+func newStorage(t *testing.T, factory Factory) (osin.Storage, osin.Client) {
+	storage := factory(t, newClient(clientID))
+	client, err := storage.GetClient(clientID)
+	if err != nil {
+		storage.Close()
+		t.Fatalf("GetClient(%q): %v", clientID, err)
+	}
+	return storage, client
+}
+
+// now returns current time in seconds, which any storage keeps.
+func now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// newAccess creates access data as osin does for grants other than authorization code and refresh token.
+func newAccess(client osin.Client, token string, refresh bool) *osin.AccessData {
+	a := &osin.AccessData{
+		Client:      client,
+		AccessToken: token,
+		ExpiresIn:   3600,
+		Scope:       "read write",
+		RedirectUri: client.GetRedirectUri(),
+		CreatedAt:   now(),
+		UserData:    "user-" + token,
+	}
+	if refresh {
+		a.RefreshToken = "refresh-" + token
+	}
+	return a
+}
+
+func saveAccess(t *testing.T, storage osin.Storage, a *osin.AccessData) {
+	t.Helper()
+	if err := storage.SaveAccess(a); err != nil {
+		t.Fatalf("SaveAccess(%q): %v", a.AccessToken, err)
+	}
+}
+
+func testGetClient(t *testing.T, factory Factory) {
+	want := newClient(clientID)
+	storage := factory(t, want, newClient("other"))
+	defer storage.Close()
+
+	got, err := storage.GetClient(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetId() != want.Id || got.GetRedirectUri() != want.RedirectUri {
+		t.Errorf("\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+func testAuthorizationCode(t *testing.T, factory Factory) {
+	storage, client := newStorage(t, factory)
+	defer storage.Close()
+
+	auth := &osin.AuthorizeData{
+		Client:              client,
+		Code:                "code",
+		ExpiresIn:           600,
+		Scope:               "read",
+		RedirectUri:         client.GetRedirectUri(),
+		State:               "state",
+		CreatedAt:           now(),
+		UserData:            "user",
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "S256",
+	}
+	if err := storage.SaveAuthorize(auth); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := storage.LoadAuthorize(auth.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAuthorize(t, auth, loaded)
+
+	// osin issues the token with loaded authorize data, and then removes the code.
+	access := &osin.AccessData{
+		Client:        client,
+		AuthorizeData: loaded,
+		AccessToken:   "token",
+		RefreshToken:  "refresh",
+		ExpiresIn:     3600,
+		Scope:         loaded.Scope,
+		RedirectUri:   loaded.RedirectUri,
+		CreatedAt:     now(),
+		UserData:      loaded.UserData,
+	}
+	saveAccess(t, storage, access)
+	if err := storage.RemoveAuthorize(auth.Code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.LoadAuthorize(auth.Code); !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("LoadAuthorize of removed code\nwant: %#v\n got: %#v", osin.ErrNotFound, err)
+	}
+
+	got, err := storage.LoadAccess(access.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAccess(t, access, got)
+	// Loading the token again, e.g. from cache, returns the same data.
+	again, err := storage.LoadAccess(access.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAccess(t, got, again)
+	checkSameAuthorize(t, got.AuthorizeData, again.AuthorizeData)
+	got, err = storage.LoadRefresh(access.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAccess(t, access, got)
+}
+
+func testAccessGrants(t *testing.T, factory Factory) {
+	tests := []struct {
+		testName string
+		refresh  bool
+		userData bool
+	}{
+		{testName: string(osin.IMPLICIT), userData: true},
+		{testName: string(osin.PASSWORD), refresh: true, userData: true},
+		{testName: string(osin.CLIENT_CREDENTIALS)},
+		{testName: string(osin.ASSERTION), userData: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			storage, client := newStorage(t, factory)
+			defer storage.Close()
+
+			access := newAccess(client, "token", tt.refresh)
+			if !tt.userData {
+				access.UserData = nil
+			}
+			saveAccess(t, storage, access)
+
+			got, err := storage.LoadAccess(access.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkAccess(t, access, got)
+			if !tt.refresh {
+				return
+			}
+			got, err = storage.LoadRefresh(access.RefreshToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkAccess(t, access, got)
+		})
+	}
+}
+
+// testRefreshChain refreshes a token repeatedly as osin does:
+// the new token is saved with the loaded one, and then the old refresh token and access token are removed.
+func testRefreshChain(t *testing.T, factory Factory) {
+	storage, client := newStorage(t, factory)
+	defer storage.Close()
+
+	current := newAccess(client, "token-0", true)
+	saveAccess(t, storage, current)
+
+	for i := 1; i <= 3; i++ {
+		previous, err := storage.LoadRefresh(current.RefreshToken)
+		if err != nil {
+			t.Fatalf("LoadRefresh of generation %d: %v", i-1, err)
+		}
+		checkAccess(t, current, previous)
+
+		next := newAccess(previous.Client, fmt.Sprintf("token-%d", i), true)
+		next.AccessData = previous
+		next.Scope = previous.Scope
+		next.UserData = previous.UserData
+		saveAccess(t, storage, next)
+		if err := storage.RemoveRefresh(previous.RefreshToken); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.RemoveAccess(previous.AccessToken); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := storage.LoadRefresh(previous.RefreshToken); !errors.Is(err, osin.ErrNotFound) {
+			t.Errorf("LoadRefresh of generation %d\nwant: %#v\n got: %#v", i-1, osin.ErrNotFound, err)
+		}
+		if _, err := storage.LoadAccess(previous.AccessToken); !errors.Is(err, osin.ErrNotFound) {
+			t.Errorf("LoadAccess of generation %d\nwant: %#v\n got: %#v", i-1, osin.ErrNotFound, err)
+		}
+		got, err := storage.LoadAccess(next.AccessToken)
+		if err != nil {
+			t.Fatalf("LoadAccess of generation %d: %v", i, err)
+		}
+		checkAccess(t, next, got)
+		current = next
+	}
+}
+
+func testNotFound(t *testing.T, factory Factory) {
+	storage, _ := newStorage(t, factory)
+	defer storage.Close()
+
+	if c, err := storage.GetClient("unknown"); c != nil || err != osin.ErrNotFound {
+		t.Errorf("GetClient\nwant: %#v, %#v\n got: %#v, %#v", nil, osin.ErrNotFound, c, err)
+	}
+	if a, err := storage.LoadAuthorize("unknown"); a != nil || !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("LoadAuthorize\nwant: %#v, %#v\n got: %#v, %#v", nil, osin.ErrNotFound, a, err)
+	}
+	if a, err := storage.LoadAccess("unknown"); a != nil || !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("LoadAccess\nwant: %#v, %#v\n got: %#v, %#v", nil, osin.ErrNotFound, a, err)
+	}
+	if a, err := storage.LoadRefresh("unknown"); a != nil || !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("LoadRefresh\nwant: %#v, %#v\n got: %#v, %#v", nil, osin.ErrNotFound, a, err)
+	}
+	if err := storage.RemoveAuthorize("unknown"); err != nil {
+		t.Errorf("RemoveAuthorize: %v", err)
+	}
+	if err := storage.RemoveAccess("unknown"); err != nil {
+		t.Errorf("RemoveAccess: %v", err)
+	}
+	if err := storage.RemoveRefresh("unknown"); err != nil {
+		t.Errorf("RemoveRefresh: %v", err)
+	}
+}
+
+// testRemoveAccess checks that refresh token can not be used after its access token is removed.
+func testRemoveAccess(t *testing.T, factory Factory) {
+	storage, client := newStorage(t, factory)
+	defer storage.Close()
+
+	access := newAccess(client, "token", true)
+	saveAccess(t, storage, access)
+	if err := storage.RemoveAccess(access.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.LoadAccess(access.AccessToken); !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("LoadAccess\nwant: %#v\n got: %#v", osin.ErrNotFound, err)
+	}
+	if a, err := storage.LoadRefresh(access.RefreshToken); a != nil || !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("LoadRefresh\nwant: %#v, %#v\n got: %#v, %#v", nil, osin.ErrNotFound, a, err)
+	}
+}
+
+// testRemoveRefresh checks that access token is still valid after its refresh token is removed.
+func testRemoveRefresh(t *testing.T, factory Factory) {
+	storage, client := newStorage(t, factory)
+	defer storage.Close()
+
+	access := newAccess(client, "token", true)
+	saveAccess(t, storage, access)
+	if err := storage.RemoveRefresh(access.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.LoadRefresh(access.RefreshToken); !errors.Is(err, osin.ErrNotFound) {
+		t.Errorf("LoadRefresh\nwant: %#v\n got: %#v", osin.ErrNotFound, err)
+	}
+	got, err := storage.LoadAccess(access.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAccess(t, access, got)
+}
+
+// testClone checks that osin can use clones of the storage for each response, and close them.
+func testClone(t *testing.T, factory Factory) {
+	storage, client := newStorage(t, factory)
+	defer storage.Close()
+
+	clone := storage.Clone()
+	access := newAccess(client, "token", true)
+	saveAccess(t, clone, access)
+	clone.Close()
+
+	got, err := storage.LoadAccess(access.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAccess(t, access, got)
+
+	clone = storage.Clone()
+	defer clone.Close()
+	got, err = clone.LoadRefresh(access.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAccess(t, access, got)
+}
+
+// testConcurrency runs token lifecycles on clones concurrently, while they read a shared token.
+func testConcurrency(t *testing.T, factory Factory) {
+	const workers = 8
+
+	storage, client := newStorage(t, factory)
+	defer storage.Close()
+
+	shared := newAccess(client, "shared", true)
+	saveAccess(t, storage, shared)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- runLifecycle(storage, client, fmt.Sprintf("token-%d", i), shared)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func runLifecycle(storage osin.Storage, client osin.Client, token string, shared *osin.AccessData) error {
+	clone := storage.Clone()
+	defer clone.Close()
+
+	access := newAccess(client, token, true)
+	if err := clone.SaveAccess(access); err != nil {
+		return fmt.Errorf("SaveAccess(%q): %v", token, err)
+	}
+	if _, err := clone.LoadAccess(shared.AccessToken); err != nil {
+		return fmt.Errorf("LoadAccess(%q): %v", shared.AccessToken, err)
+	}
+	if a, err := clone.LoadRefresh(access.RefreshToken); err != nil {
+		return fmt.Errorf("LoadRefresh(%q): %v", access.RefreshToken, err)
+	} else if a.AccessToken != token {
+		return fmt.Errorf("LoadRefresh(%q) returned token %q", access.RefreshToken, a.AccessToken)
+	}
+	if err := clone.RemoveAccess(token); err != nil {
+		return fmt.Errorf("RemoveAccess(%q): %v", token, err)
+	}
+	if _, err := clone.LoadAccess(token); !errors.Is(err, osin.ErrNotFound) {
+		return fmt.Errorf("LoadAccess(%q) after RemoveAccess: %v", token, err)
+	}
+	return nil
+}
+
+// checkAuthorize compares fields of authorize data which osin uses.
+func checkAuthorize(t *testing.T, want, got *osin.AuthorizeData) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("authorize data %q is nil", want.Code)
+	}
+	if got.Code != want.Code ||
+		got.Client == nil || got.Client.GetId() != want.Client.GetId() ||
+		got.ExpiresIn != want.ExpiresIn ||
+		got.Scope != want.Scope ||
+		got.RedirectUri != want.RedirectUri ||
+		got.State != want.State ||
+		!got.CreatedAt.Equal(want.CreatedAt) ||
+		!sameUserData(got.UserData, want.UserData) ||
+		got.CodeChallenge != want.CodeChallenge ||
+		got.CodeChallengeMethod != want.CodeChallengeMethod {
+		t.Errorf("authorize data\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+// checkSameAuthorize checks that got is the same authorize data as want loaded before, which may be nil
+// if the storage does not keep authorize data of the token once the code is removed.
+func checkSameAuthorize(t *testing.T, want, got *osin.AuthorizeData) {
+	t.Helper()
+	if want == nil || got == nil {
+		if want != got {
+			t.Errorf("authorize data\nwant: %#v\n got: %#v", want, got)
+		}
+		return
+	}
+	checkAuthorize(t, want, got)
+}
+
+// checkAccess compares fields of access data which osin uses.
+// Authorize data and previous access data are not compared, since osin does not use them after the token is issued.
+func checkAccess(t *testing.T, want, got *osin.AccessData) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("access data %q is nil", want.AccessToken)
+	}
+	if got.AccessToken != want.AccessToken ||
+		got.RefreshToken != want.RefreshToken ||
+		got.Client == nil || got.Client.GetId() != want.Client.GetId() ||
+		got.ExpiresIn != want.ExpiresIn ||
+		got.Scope != want.Scope ||
+		got.RedirectUri != want.RedirectUri ||
+		!got.CreatedAt.Equal(want.CreatedAt) ||
+		!sameUserData(got.UserData, want.UserData) {
+		t.Errorf("access data\nwant: %#v\n got: %#v", want, got)
+	}
+}
+
+// sameUserData reports whether user data are equal, where nil and empty string are the same.
+func sameUserData(a, b interface{}) bool {
+	if a == nil {
+		a = ""
+	}
+	if b == nil {
+		b = ""
+	}
+	return a == b
+}
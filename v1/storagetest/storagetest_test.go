@@ -0,0 +1,94 @@
+package storagetest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+
+	osindatastore "github.com/ryutah/osin-datastore/v1"
+	"github.com/ryutah/osin-datastore/v1/datastoretest"
+)
+
+// newDatastoreStorage returns Factory of Storage backed by in-memory Datastore.
+func newDatastoreStorage(opts ...osindatastore.StorageOption) Factory {
+	return func(t *testing.T, clients ...osin.Client) osin.Storage {
+		ctx := context.Background()
+		storage := datastoretest.NewStorage(ctx, opts...)
+		for _, c := range clients {
+			client := &osindatastore.Client{
+				ID:          c.GetId(),
+				Secret:      c.GetSecret(),
+				RedirectUri: c.GetRedirectUri(),
+				UserData:    c.GetUserData().(string),
+			}
+			if err := storage.ClientStorage().Put(ctx, client); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return storage
+	}
+}
+
+func TestRun_Storage(t *testing.T) {
+	// Options which change how tokens are stored and loaded are tested in every combination.
+	options := []struct {
+		name string
+		opt  func() osindatastore.StorageOption
+	}{
+		{name: "denormalized", opt: osindatastore.WithDenormalizedTokens},
+		{name: "client_cache", opt: func() osindatastore.StorageOption {
+			return osindatastore.WithClientCache(osindatastore.NewLRUClientCache(10, time.Minute), time.Second)
+		}},
+		{name: "access_cache", opt: func() osindatastore.StorageOption {
+			return osindatastore.WithAccessCache(osindatastore.NewLRUAccessCache(10), time.Minute)
+		}},
+		{name: "audit", opt: osindatastore.WithAudit},
+		{name: "outbox", opt: osindatastore.WithOutbox},
+	}
+
+	for mask := 0; mask < 1<<uint(len(options)); mask++ {
+		var (
+			names []string
+			opts  []func() osindatastore.StorageOption
+		)
+		for i, o := range options {
+			if mask&(1<<uint(i)) != 0 {
+				names = append(names, o.name)
+				opts = append(opts, o.opt)
+			}
+		}
+		name := strings.Join(names, "+")
+		if name == "" {
+			name = "default"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			Run(t, func(t *testing.T, clients ...osin.Client) osin.Storage {
+				// Options are created for each storage, so that storages do not share caches.
+				storageOpts := make([]osindatastore.StorageOption, len(opts))
+				for i, opt := range opts {
+					storageOpts[i] = opt()
+				}
+				return newDatastoreStorage(storageOpts...)(t, clients...)
+			})
+		})
+	}
+}
+
+func TestRun_StorageWithRuntimeOptions(t *testing.T) {
+	Run(t, newDatastoreStorage(
+		osindatastore.WithRetryPolicy(osindatastore.RetryPolicy{}),
+		osindatastore.WithTimeout(time.Minute),
+		osindatastore.WithObserver(osindatastore.ObserverFunc(func(context.Context, osindatastore.Event) {})),
+	))
+}
+
+func TestRun_LoggingStorage(t *testing.T) {
+	Run(t, func(t *testing.T, clients ...osin.Client) osin.Storage {
+		storage := newDatastoreStorage(osindatastore.WithDenormalizedTokens())(t, clients...)
+		return osindatastore.NewLoggingStorage(storage, osindatastore.LoggerFunc(func(osindatastore.LogLevel, string, ...osindatastore.LogField) {}))
+	})
+}